@@ -8,6 +8,7 @@ package api
 
 import (
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/securekey/fabric-snaps/util/errors"
@@ -38,12 +39,77 @@ type ConfigKV struct {
 	Value []byte
 }
 
+//ConfigHistoryEntry represents one value that was stored for a config key by a transaction
+type ConfigHistoryEntry struct {
+	TxID      string
+	Timestamp time.Time
+	Value     []byte
+	IsDelete  bool
+	//Creator is the submitter of the transaction. It is nil for changes that were made before creators were recorded.
+	Creator *ConfigCreator `json:",omitempty"`
+}
+
+//ConfigCreator identifies the submitter of a transaction that changed configuration
+type ConfigCreator struct {
+	//MspID is the MSP of the submitter
+	MspID string
+	//Subject is the subject of the submitter's certificate
+	Subject string `json:",omitempty"`
+}
+
+//PageOptions specifies the size of a page of query results and where the page starts
+type PageOptions struct {
+	//PageSize is the maximum number of configs returned in the page
+	PageSize int
+	//Bookmark (optional) is the bookmark returned with the previous page. If empty then the first page is returned.
+	Bookmark string `json:",omitempty"`
+}
+
+//ConfigQuery contains the criteria for a paged config query
+type ConfigQuery struct {
+	//MspID (mandatory) is the MSP whose configs are queried
+	MspID string
+	//PeerID (optional) restricts the results to configs of the given peer
+	PeerID string `json:",omitempty"`
+	//AppName (optional) restricts the results to configs of the given app
+	AppName string `json:",omitempty"`
+	//ComponentName (optional) restricts the results to configs of the given component
+	ComponentName string `json:",omitempty"`
+	PageOptions
+}
+
+//ConfigQueryResult is a page of configs returned by a query
+type ConfigQueryResult struct {
+	Configs []*ConfigKV
+	//Bookmark is used to retrieve the next page. It is empty if there are no more results.
+	Bookmark string `json:",omitempty"`
+}
+
+//ReindexResult lists the configs whose index entries were rebuilt by a reindex
+type ReindexResult struct {
+	//Reindexed are the keys of the configs whose index entries were rebuilt
+	Reindexed []ConfigKey
+	//Removed are the keys of configs that no longer exist and whose stale index entries were removed
+	Removed []ConfigKey `json:",omitempty"`
+}
+
 //ComponentConfig represents app component
 type ComponentConfig struct {
 	Name    string
 	Config  string
 	Version string
 	TxID    string
+	//ConfigType (optional) is the format of the config (YAML or JSON). When set the config syntax is checked on save.
+	ConfigType ConfigType `json:",omitempty"`
+	//Encrypted indicates that Config holds an encrypted config (see EncryptedConfig).
+	//Encrypted configs are decrypted by the config service before they are cached.
+	Encrypted bool `json:",omitempty"`
+	//ExpectedHash (optional) is the hash of the config currently stored for the component.
+	//The save is rejected if the stored config no longer has this hash.
+	ExpectedHash string `json:",omitempty"`
+	//ExpectedTxID (optional) is the ID of the transaction that stored the current config for the component.
+	//The save is rejected if the stored config was since updated by another transaction.
+	ExpectedTxID string `json:",omitempty"`
 }
 
 //AppConfig identifier has application name , config version
@@ -52,6 +118,32 @@ type AppConfig struct {
 	Version    string
 	Config     string
 	Components []ComponentConfig
+	//ConfigType (optional) is the format of the config (YAML or JSON). When set the config syntax is checked on save.
+	ConfigType ConfigType `json:",omitempty"`
+	//Encrypted indicates that Config holds an encrypted config (see EncryptedConfig).
+	//Encrypted configs are decrypted by the config service before they are cached.
+	Encrypted bool `json:",omitempty"`
+	//Templated indicates that Config is a template whose ${name} placeholders are resolved when the config is read.
+	//Placeholders may reference the peer's ID, MSP ID and address (peer.id, peer.localMspId, peer.address)
+	//or the variables of the MSP. Components are not templated.
+	Templated bool `json:",omitempty"`
+	//ExpectedHash (optional) is the hash of the config currently stored for the app.
+	//The save is rejected if the stored config no longer has this hash.
+	ExpectedHash string `json:",omitempty"`
+	//ExpectedTxID (optional) is the ID of the transaction that stored the current config for the app.
+	//The save is rejected if the stored config was since updated by another transaction.
+	ExpectedTxID string `json:",omitempty"`
+}
+
+//EncryptedConfig is the envelope of an encrypted config. The config is encrypted with a random
+//AES-256-GCM content key which is in turn encrypted with the public key of the peer's key.
+type EncryptedConfig struct {
+	//KeyID is the (hex encoded) SKI of the peer's key
+	KeyID string
+	//WrappedKey is the content key encrypted (RSA-OAEP with SHA-256) with the peer's public key
+	WrappedKey []byte
+	Nonce      []byte
+	Ciphertext []byte
 }
 
 //PeerConfig identifier has peer identifier and collection of application configurations
@@ -60,6 +152,12 @@ type PeerConfig struct {
 	App    []AppConfig
 }
 
+//Precondition is the expected state of a stored config which must hold for a save to succeed
+type Precondition struct {
+	ExpectedHash string
+	ExpectedTxID string
+}
+
 //ConfigMessage - has MSP identifier and collection of peers
 type ConfigMessage struct {
 	MspID string
@@ -67,6 +165,26 @@ type ConfigMessage struct {
 	Apps  []AppConfig
 }
 
+//ConfigBatch is a set of config messages for different MSPs which are validated and saved together in one transaction
+type ConfigBatch struct {
+	Messages []ConfigMessage
+}
+
+//ConfigEvent is the payload of the config change event
+type ConfigEvent struct {
+	//Changes are the configs that were changed by the transaction
+	Changes []ConfigChange
+}
+
+//ConfigChange is a change to the config stored for a key
+type ConfigChange struct {
+	Key ConfigKey
+	//OldHash is the hash of the config before the change. It is empty if the config was added.
+	OldHash string `json:",omitempty"`
+	//NewHash is the hash of the config after the change. It is empty if the config was deleted.
+	NewHash string `json:",omitempty"`
+}
+
 // ConfigClient is used to publish messages
 type ConfigClient interface {
 	Get(stub shim.ChaincodeStubInterface, configKey *ConfigKey) (viper *viper.Viper, err error)
@@ -74,7 +192,8 @@ type ConfigClient interface {
 
 //ConfigManager is used to manage configuration in ledger(save,get,delete)
 type ConfigManager interface {
-	//Save configuration - The submited payload should be in form of ConfigMessage
+	//Save configuration - The submited payload should be in form of ConfigMessage or ConfigBatch.
+	//The configs of all of the messages in a ConfigBatch are saved in the same transaction
 	Save(config []byte) errors.Error
 	//Get configuration - Gets configuration based on config key.
 	//For the valid config key retuned array will have only one element.
@@ -83,7 +202,30 @@ type ConfigManager interface {
 	//Delete configuration -
 	//For the valid config one config message will be deleted
 	//For the config key containing only MspID all configurations for that MspID will be deleted
+	//The config event lists the deleted keys so that the caches of the peers are updated
 	Delete(configKey ConfigKey) errors.Error
+	//GetHistory returns every value that was stored for the given (complete) config key in commit order
+	//along with the TxID, timestamp and creator of the transaction that stored it
+	GetHistory(configKey ConfigKey) ([]*ConfigHistoryEntry, errors.Error)
+	//GetAsOf returns the value that the given (complete) config key was set to by the given transaction
+	GetAsOf(configKey ConfigKey, txID string) (*ConfigHistoryEntry, errors.Error)
+	//Rollback restores the configuration that the given transaction changed to the values that it stored.
+	//For the valid config key only that key is restored
+	//For the config key containing only MspID (and optionally AppName) all matching configurations that the
+	//transaction changed are restored. Configurations that it didn't change are left as they are.
+	Rollback(configKey ConfigKey, txID string) errors.Error
+	//Query returns a page of the configurations of an MSP, optionally filtered by PeerID, AppName and ComponentName
+	Query(query ConfigQuery) (*ConfigQueryResult, errors.Error)
+	//Reindex rebuilds the index entries of the configurations of an MSP, or of all MSPs if the MSP ID is empty
+	//(e.g. after an upgrade that added indexes), and returns the keys of the reindexed configurations.
+	//The index entries of configurations that no longer exist are removed and their keys are returned separately.
+	Reindex(mspID string) (*ReindexResult, errors.Error)
+	//GetByAppName returns the configurations of the given app (and its components) for all MSPs.
+	//Configurations that were saved before the app name index was introduced are only returned once they are reindexed.
+	GetByAppName(appName string) ([]*ConfigKV, errors.Error)
+	//GetByPeerID returns the configurations of the given peer for all MSPs.
+	//Configurations that were saved before the peer ID index was introduced are only returned once they are reindexed.
+	GetByPeerID(peerID string) ([]*ConfigKV, errors.Error)
 }
 
 // ConfigType indicates the type (format) of the configuration
@@ -102,10 +244,89 @@ type ConfigService interface {
 	//Get returns the config bytes along with dirty flag for the given channel and config key.
 	// dirty flag bool returns true only if config is updated since its last retrieval
 	Get(channelID string, configKey ConfigKey) ([]byte, bool, errors.Error)
+	//GetForConsumer returns the config bytes along with dirty flag for the given channel and config key.
+	// Unlike Get, the dirty flag is tracked separately for each consumer so it returns true only if
+	// config is updated since its last retrieval by the given consumer
+	GetForConsumer(consumerID string, channelID string, configKey ConfigKey) ([]byte, bool, errors.Error)
+	//ResetConsumer clears the dirty tracking of the given consumer so that the next retrieval of every config
+	// by the consumer returns dirty flag true
+	ResetConsumer(consumerID string)
 	//GetViper returns a Viper instance along with dirty fla that wraps the config for the given channel and config key.
 	// If the config key doesn't exist then nil is returned.
 	//dirty flag bool returns true only if config is updated since its last retrieval
 	GetViper(channelID string, configKey ConfigKey, configType ConfigType) (*viper.Viper, bool, errors.Error)
+	//GetMerged returns the deep-merge of the app config of the general MSP, the MSP-level app config and
+	//the peer-level app config (in that order, so that later layers override earlier ones) for the given channel and config key.
+	// If none of the layers exist then nil is returned.
+	//dirty flag bool returns true if any of the layers is updated since the last GetMerged for the config key.
+	//It doesn't affect the dirty flags that are returned by Get.
+	GetMerged(channelID string, configKey ConfigKey, configType ConfigType) (*MergedConfig, bool, errors.Error)
+	//Subscribe returns a channel which receives a ConfigChange whenever the cached config of a key that matches
+	//the given filter is added, updated or removed on the given channel. Empty fields of the filter match any value.
+	//The returned function unsubscribes and closes the channel.
+	Subscribe(channelID string, filter ConfigKey) (<-chan ConfigChange, func())
+	//GetCacheInfo returns information about the cached configs of the given MSP on the given channel
+	//(e.g. the block height that they reflect) or false if the configs of the MSP aren't cached
+	GetCacheInfo(channelID string, mspID string) (*CacheInfo, bool)
+	//GetConfigsFromLedger reads the configs for the given keys directly from the ledger of the given channel
+	//in a single read (e.g. httpsnap reads its config and client identities with it when they aren't cached).
+	//The configs are returned in the order of the keys; the config of a key that doesn't exist is nil.
+	GetConfigsFromLedger(channelID string, configKeys []ConfigKey) ([][]byte, errors.Error)
+}
+
+//CacheInfo contains information about the cached configs of an MSP on a channel
+type CacheInfo struct {
+	//BlockHeight is the height of the channel's ledger when the configs were read
+	BlockHeight uint64
+	//Timestamp is the time when the configs were read
+	Timestamp time.Time
+	//FromSnapshot is true if the configs were loaded from the on-disk snapshot and
+	//haven't been refreshed from the ledger since
+	FromSnapshot bool
+}
+
+//CacheSnapshot is a snapshot of the cached configs of an MSP on a channel
+type CacheSnapshot struct {
+	ChannelID string
+	MspID     string
+	CacheInfo
+	//Configs are the configs as stored in the ledger (encrypted configs remain encrypted)
+	Configs []*ConfigKV
+}
+
+//SignedCacheSnapshot is a CacheSnapshot signed by the peer
+type SignedCacheSnapshot struct {
+	//Snapshot is the marshalled CacheSnapshot
+	Snapshot  []byte
+	Signature []byte
+}
+
+//CacheStatus describes the cached configs of an MSP on a channel
+type CacheStatus struct {
+	ChannelID string
+	MspID     string
+	CacheInfo
+	//KeyCount is the number of config keys that are tracked by the cache
+	KeyCount int
+	//Hashes contains the hash of the ledger value of each config key that is tracked by the cache
+	Hashes map[string]string
+}
+
+//CacheDiff is a config key whose cached hash differs from the hash of its value in the ledger.
+//An empty hash means that the key is not in the cache or not in the ledger.
+type CacheDiff struct {
+	ChannelID  string
+	MspID      string
+	Key        string
+	CachedHash string `json:",omitempty"`
+	LedgerHash string `json:",omitempty"`
+}
+
+//MergedConfig is a config that was merged from the general, MSP and peer layers of an app config
+type MergedConfig struct {
+	*viper.Viper
+	//Sources maps each key of the merged config to the config key of the layer that the value came from
+	Sources map[string]ConfigKey
 }
 
 //IsValid validates config message
@@ -130,6 +351,24 @@ func (cm ConfigMessage) IsValid() errors.Error {
 	return nil
 }
 
+//IsValid validates config batch
+func (cb ConfigBatch) IsValid() errors.Error {
+	if len(cb.Messages) == 0 {
+		return errors.New(errors.InvalidConfigMessage, "Config batch must contain at least one message")
+	}
+	mspIDs := make(map[string]bool)
+	for _, message := range cb.Messages {
+		if err := message.IsValid(); err != nil {
+			return err
+		}
+		if mspIDs[message.MspID] {
+			return errors.Errorf(errors.InvalidConfigMessage, "Config batch contains more than one message for MSP [%s]", message.MspID)
+		}
+		mspIDs[message.MspID] = true
+	}
+	return nil
+}
+
 //IsValid validates config messagegetIndexKey
 func (pc PeerConfig) IsValid() errors.Error {
 	if pc.PeerID == "" {
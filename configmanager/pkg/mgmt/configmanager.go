@@ -7,10 +7,19 @@ SPDX-License-Identifier: Apache-2.0
 package mgmt
 
 import (
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/logging"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	protosMSP "github.com/hyperledger/fabric/protos/msp"
 	"github.com/securekey/fabric-snaps/configmanager/api"
 	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
 	"github.com/securekey/fabric-snaps/util/errors"
@@ -21,14 +30,37 @@ var logger = logging.NewLogger("configsnap")
 const (
 	// indexOrg is the name of the index to retrieve configurations per org
 	indexMspID = "cfgmgmt-mspid"
+	// indexMspIDApp is the name of the index to retrieve configurations per org and app/component
+	indexMspIDApp = "cfgmgmt-mspid-app"
+	// indexMspIDPeer is the name of the index to retrieve configurations per org and peer
+	indexMspIDPeer = "cfgmgmt-mspid-peer"
+	// indexAppName is the name of the index to retrieve configurations per app across all orgs
+	indexAppName = "cfgmgmt-appname"
+	// indexPeerID is the name of the index to retrieve configurations per peer across all orgs
+	indexPeerID = "cfgmgmt-peerid"
+	// indexMspIDKnown is the name of the index of every config key that was ever saved per org. Unlike the other
+	// indexes its entries are not removed on delete so that deleted configs can be rolled back.
+	indexMspIDKnown = "cfgmgmt-mspid-known"
+	// txObjectType is the object type of the composite keys under which the transactions that change configs are recorded
+	txObjectType = "cfgmgmt-tx"
+	// minUnicodeRuneValue is appended to a key to get the smallest key that follows it
+	minUnicodeRuneValue = "\x00"
 )
 
 // indexes contains a list of indexes that should be added for configurations
-var indexes = [...]string{indexMspID}
+var indexes = [...]string{indexMspID, indexMspIDApp, indexMspIDPeer, indexAppName, indexPeerID}
 
 // ConfigManagerImpl implements configuration management functionality
 type configManagerImpl struct {
 	stub shim.ChaincodeStubInterface
+	//recordedTxID is the transaction that was recorded last
+	recordedTxID string
+}
+
+//txRecord is recorded for every transaction that changes configs
+type txRecord struct {
+	//Creator is the submitter of the transaction
+	Creator *api.ConfigCreator
 }
 
 //NewConfigManager returns config manager implementation
@@ -42,23 +74,118 @@ func (cmngr *configManagerImpl) Save(configData []byte) errors.Error {
 	if len(configData) == 0 {
 		return errors.New(errors.MissingRequiredParameterError, "Configuration must be provided")
 	}
+	if IsConfigBatch(configData) {
+		return cmngr.saveBatch(configData)
+	}
 	//parse configuration request
-	configMessageMap, err := ParseConfigMessage(configData, cmngr.stub.GetTxID())
+	parsedConfig, err := unmarshalConfigMessage(configData)
+	if err != nil {
+		return err
+	}
+	configMessageMap, err := cmngr.prepareConfigs(parsedConfig)
+	if err != nil {
+		return err
+	}
+	if err := cmngr.setConfigEvent(configMessageMap); err != nil {
+		return err
+	}
+
+	return cmngr.saveConfigs(configMessageMap)
+}
+
+//saveBatch validates every message of the batch and saves all of their configs in the current transaction.
+//A single event listing all of the affected keys is emitted.
+func (cmngr *configManagerImpl) saveBatch(configData []byte) errors.Error {
+	batch, err := unmarshalConfigBatch(configData)
 	if err != nil {
 		return err
 	}
+	if err := batch.IsValid(); err != nil {
+		return err
+	}
+	configMessageMap := make(map[api.ConfigKey][]byte)
+	for i := range batch.Messages {
+		configs, err := cmngr.prepareConfigs(&batch.Messages[i])
+		if err != nil {
+			return errors.WithMessage(err.ErrorCode(), err, fmt.Sprintf("Invalid config message for MSP [%s]", batch.Messages[i].MspID))
+		}
+		for key, value := range configs {
+			configMessageMap[key] = value
+		}
+	}
 
-	err1 := cmngr.stub.SetEvent(cfgsnapapi.ConfigCCEventName, nil)
-	if err1 != nil {
-		return errors.Wrap(errors.SystemError, err1, "SetEvent failed")
+	if err := cmngr.setConfigEvent(configMessageMap); err != nil {
+		return err
 	}
 
 	return cmngr.saveConfigs(configMessageMap)
 }
 
+//setConfigEvent emits the config change event. The payload lists the given keys along with the hashes
+//of their stored and new configs, where a nil config means that the key is being deleted.
+//It must be called before the new configs are written.
+func (cmngr *configManagerImpl) setConfigEvent(newConfigs map[api.ConfigKey][]byte) errors.Error {
+	event := &api.ConfigEvent{}
+	for key, config := range newConfigs {
+		current, err := cmngr.getConfig(key)
+		if err != nil {
+			return err
+		}
+		change := api.ConfigChange{Key: key}
+		if len(current) > 0 {
+			change.OldHash = GenerateHash(current)
+		}
+		if config != nil {
+			change.NewHash = GenerateHash(config)
+		}
+		if change.OldHash == "" && change.NewHash == "" {
+			//deleting a config that doesn't exist
+			continue
+		}
+		event.Changes = append(event.Changes, change)
+	}
+	sort.Slice(event.Changes, func(i, j int) bool { return event.Changes[i].Key.String() < event.Changes[j].Key.String() })
+
+	payload, e := json.Marshal(event)
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal config event")
+	}
+	if e := cmngr.stub.SetEvent(cfgsnapapi.ConfigCCEventName, payload); e != nil {
+		return errors.Wrap(errors.SystemError, e, "SetEvent failed")
+	}
+	return nil
+}
+
+//prepareConfigs parses and validates the given config message and returns the configs that are to be saved
+func (cmngr *configManagerImpl) prepareConfigs(parsedConfig *api.ConfigMessage) (map[api.ConfigKey][]byte, errors.Error) {
+	configMessageMap, err := parseConfigMessage(parsedConfig, cmngr.stub.GetTxID())
+	if err != nil {
+		return nil, err
+	}
+	//validate the configs against the schemas registered for their apps
+	if err := newSchemaValidator(cmngr).validate(parsedConfig); err != nil {
+		return nil, err
+	}
+	if err := cmngr.validateTemplates(parsedConfig); err != nil {
+		return nil, err
+	}
+	//reject the whole message if any of the stored configs has changed since the version expected by the caller
+	preconditions, err := parsePreconditions(parsedConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := cmngr.checkPreconditions(preconditions); err != nil {
+		return nil, err
+	}
+	return configMessageMap, nil
+}
+
 //saveConfigs saves key&configs to the repository.
 //also it adds indexes for saved records
 func (cmngr *configManagerImpl) saveConfigs(configMessageMap map[api.ConfigKey][]byte) errors.Error {
+	if err := cmngr.recordTx(); err != nil {
+		return err
+	}
 	for key, value := range configMessageMap {
 		logger.Debugf("Saving configs %v,%s", key, string(value[:]))
 		strkey, err := ConfigKeyToString(key)
@@ -140,82 +267,396 @@ func (cmngr *configManagerImpl) getConfigs(configKey api.ConfigKey) ([]*api.Conf
 	return configs, nil
 }
 
-func (cmngr *configManagerImpl) deleteConfigs(configKey api.ConfigKey) errors.Error {
-	if configKey.MspID == "" {
-		return errors.Errorf(errors.InvalidConfigKey, "Invalid config key %+v. MspID is required.", configKey)
-	}
-	configs, err := cmngr.getConfigs(configKey)
+//Delete deletes configuration from the ledger using config key. The config event lists the deleted keys.
+func (cmngr *configManagerImpl) Delete(configKey api.ConfigKey) errors.Error {
+	keys, err := cmngr.getDeleteKeys(configKey)
 	if err != nil {
 		return err
 	}
-	for _, value := range configs {
-		logger.Debugf("Deleting state for key: %+v", value.Key)
-		keyStr, err := ConfigKeyToString(value.Key)
-		if err != nil {
+
+	deletedConfigs := make(map[api.ConfigKey][]byte, len(keys))
+	for _, key := range keys {
+		deletedConfigs[key] = nil
+	}
+	if err := cmngr.setConfigEvent(deletedConfigs); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		logger.Debugf("Deleting state for key: %+v", key)
+		if err := cmngr.deleteConfig(key); err != nil {
 			return err
 		}
-		if err := cmngr.stub.DelState(keyStr); err != nil {
-			return errors.Wrap(errors.SystemError, err, "DeleteState failed")
-		}
 	}
 	return nil
 }
 
-//Delete deletes configuration from the ledger using config key
-func (cmngr *configManagerImpl) Delete(configKey api.ConfigKey) errors.Error {
-	err := ValidateConfigKey(configKey)
-	if err != nil {
+//getDeleteKeys returns the keys of the configs that are deleted for the given key. A partial key covers all of
+//the configs of the MSP that match it and a key without a component version covers every version of the component.
+func (cmngr *configManagerImpl) getDeleteKeys(configKey api.ConfigKey) ([]api.ConfigKey, errors.Error) {
+	if err := ValidateConfigKey(configKey); err != nil {
 		//search for all configs by mspID
-		return cmngr.deleteConfigs(configKey)
+		if configKey.MspID == "" {
+			return nil, errors.Errorf(errors.InvalidConfigKey, "Invalid config key %+v. MspID is required.", configKey)
+		}
+		configs, err := cmngr.getConfigs(configKey)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]api.ConfigKey, 0, len(configs))
+		for _, value := range configs {
+			keys = append(keys, value.Key)
+		}
+		return keys, nil
 	}
 
-	if deleteStateErr := cmngr.deleteState(configKey); deleteStateErr != nil {
-		return deleteStateErr
+	var keys []api.ConfigKey
+	if len(configKey.ComponentName) > 0 && len(configKey.ComponentVersion) == 0 {
+		configs, err := cmngr.getConfigs(configKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range configs {
+			if value.Key.ComponentName == configKey.ComponentName && value.Key.AppName == configKey.AppName {
+				keys = append(keys, value.Key)
+			}
+		}
 	}
+	//delete configuration for valid key
+	return append(keys, configKey), nil
+}
 
-	key, configKeyToStringErr := ConfigKeyToString(configKey)
-	if configKeyToStringErr != nil {
-		return configKeyToStringErr
+//deleteConfig deletes the config for a valid key along with its index entries
+func (cmngr *configManagerImpl) deleteConfig(configKey api.ConfigKey) errors.Error {
+	key, err := ConfigKeyToString(configKey)
+	if err != nil {
+		return err
 	}
-	//delete configuration for valid key
-	e := cmngr.stub.DelState(key)
-	if e != nil {
+	if err := cmngr.recordTx(); err != nil {
+		return err
+	}
+	if e := cmngr.stub.DelState(key); e != nil {
 		return errors.Wrap(errors.SystemError, e, "DelState failed")
 	}
+	return cmngr.removeIndexes(configKey)
+}
+
+//GetHistory returns all values stored in the ledger for the given config key
+func (cmngr *configManagerImpl) GetHistory(configKey api.ConfigKey) ([]*api.ConfigHistoryEntry, errors.Error) {
+	key, err := ConfigKeyToString(configKey)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debugf("Getting history for key %s", key)
+
+	it, e := cmngr.stub.GetHistoryForKey(key)
+	if e != nil {
+		return nil, errors.Wrap(errors.SystemError, e, "GetHistoryForKey failed")
+	}
+	defer func() {
+		iteratorErr := it.Close()
+		if iteratorErr != nil {
+			logger.Warnf("Failed to close history iterator : %s", iteratorErr)
+		}
+	}()
+
+	history := []*api.ConfigHistoryEntry{}
+	for it.HasNext() {
+		km, e := it.Next()
+		if e != nil {
+			return nil, errors.WithMessage(errors.SystemError, e, "Failed to get next value from history iterator")
+		}
+		var timestamp time.Time
+		if km.Timestamp != nil {
+			timestamp, e = ptypes.Timestamp(km.Timestamp)
+			if e != nil {
+				return nil, errors.WithMessage(errors.SystemError, e, "Invalid timestamp in history")
+			}
+		}
+		entry := &api.ConfigHistoryEntry{TxID: km.TxId, Timestamp: timestamp, Value: km.Value, IsDelete: km.IsDelete}
+		record, err := cmngr.getTxRecord(km.TxId)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			entry.Creator = record.Creator
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
 
+//GetAsOf returns the value that the given config key was set to by the given transaction.
+//The transaction is located in the history of the key, which is in commit order, in the same way as for a rollback,
+//so the client-set timestamps of the transactions are not used.
+func (cmngr *configManagerImpl) GetAsOf(configKey api.ConfigKey, txID string) (*api.ConfigHistoryEntry, errors.Error) {
+	if txID == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "TxID is required")
+	}
+	history, err := cmngr.GetHistory(configKey)
+	if err != nil {
+		return nil, err
+	}
+	current := valueAsOf(history, txID)
+	if current == nil || current.IsDelete {
+		return nil, errors.Errorf(errors.DataNotFoundError, "No config found for key %s as of transaction %s", configKey.String(), txID)
+	}
+	return current, nil
+}
+
+//recordTx records the submitter of the current transaction so that it can be returned
+//with the history of the configs that the transaction changes
+func (cmngr *configManagerImpl) recordTx() errors.Error {
+	txID := cmngr.stub.GetTxID()
+	if txID == cmngr.recordedTxID {
+		return nil
+	}
+	creator, err := cmngr.getCreator()
+	if err != nil {
+		return err
+	}
+	recordJSON, e := json.Marshal(&txRecord{Creator: creator})
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal transaction record")
+	}
+	key, e := cmngr.stub.CreateCompositeKey(txObjectType, []string{txID})
+	if e != nil {
+		return errors.Wrap(errors.SystemError, e, "Failed to create transaction record key")
+	}
+	if e := cmngr.stub.PutState(key, recordJSON); e != nil {
+		return errors.Wrap(errors.SystemError, e, "PutState has failed")
+	}
+	cmngr.recordedTxID = txID
 	return nil
 }
 
-func (cmngr *configManagerImpl) deleteState(configKey api.ConfigKey) errors.Error {
-	if len(configKey.ComponentName) > 0 && len(configKey.ComponentVersion) == 0 {
-		configs, err := cmngr.getConfigs(configKey)
+//getCreator returns the submitter of the current transaction
+func (cmngr *configManagerImpl) getCreator() (*api.ConfigCreator, errors.Error) {
+	creatorBytes, e := cmngr.stub.GetCreator()
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to get creator")
+	}
+	sid := &protosMSP.SerializedIdentity{}
+	if e := proto.Unmarshal(creatorBytes, sid); e != nil {
+		return nil, errors.WithMessage(errors.UnmarshalError, e, "Failed to unmarshal creator")
+	}
+	creator := &api.ConfigCreator{MspID: sid.Mspid}
+	if block, _ := pem.Decode(sid.IdBytes); block != nil {
+		if cert, e := x509.ParseCertificate(block.Bytes); e == nil {
+			creator.Subject = cert.Subject.String()
+		}
+	}
+	return creator, nil
+}
+
+//getTxRecord returns the record of the given transaction or nil if the transaction wasn't recorded
+//(i.e. it changed configs before transactions were recorded)
+func (cmngr *configManagerImpl) getTxRecord(txID string) (*txRecord, errors.Error) {
+	key, e := cmngr.stub.CreateCompositeKey(txObjectType, []string{txID})
+	if e != nil {
+		return nil, errors.Wrap(errors.SystemError, e, "Failed to create transaction record key")
+	}
+	recordJSON, e := cmngr.stub.GetState(key)
+	if e != nil {
+		return nil, errors.Wrap(errors.SystemError, e, "Failed to get transaction record")
+	}
+	if len(recordJSON) == 0 {
+		return nil, nil
+	}
+	record := &txRecord{}
+	if e := json.Unmarshal(recordJSON, record); e != nil {
+		return nil, errors.WithMessage(errors.UnmarshalError, e, "Failed to unmarshal transaction record")
+	}
+	return record, nil
+}
+
+//Rollback restores the configs for the given key (or all keys matching a partial key) that were changed by the given
+//transaction to the values that it stored. The history of each key is in commit order but there is no order across
+//keys, so configs that the transaction didn't change are left as they are.
+func (cmngr *configManagerImpl) Rollback(configKey api.ConfigKey, txID string) errors.Error {
+	if txID == "" {
+		return errors.New(errors.MissingRequiredParameterError, "TxID is required")
+	}
+	keys, err := cmngr.getRollbackKeys(configKey)
+	if err != nil {
+		return err
+	}
+
+	var restoredKeys []api.ConfigKey
+	entries := make(map[api.ConfigKey]*api.ConfigHistoryEntry)
+	for _, key := range keys {
+		history, err := cmngr.GetHistory(key)
 		if err != nil {
 			return err
 		}
-		for _, value := range configs {
-			logger.Debugf("Deleting state for key: %+v", value.Key)
-			keyStr, err := ConfigKeyToString(value.Key)
-			if err != nil {
-				return err
-			}
-			if value.Key.ComponentName == configKey.ComponentName && value.Key.AppName == configKey.AppName {
-				if err := cmngr.stub.DelState(keyStr); err != nil {
-					return errors.Wrap(errors.SystemError, err, "DeleteState failed")
-				}
-			}
+		if entry := valueAsOf(history, txID); entry != nil {
+			restoredKeys = append(restoredKeys, key)
+			entries[key] = entry
+		}
+	}
+	if len(restoredKeys) == 0 {
+		return errors.Errorf(errors.DataNotFoundError, "Transaction %s did not update any config for key %s", txID, configKey.String())
+	}
+
+	restoredConfigs := make(map[api.ConfigKey][]byte)
+	for key, entry := range entries {
+		if !entry.IsDelete {
+			restoredConfigs[key] = entry.Value
+		} else {
+			restoredConfigs[key] = nil
+		}
+	}
+	if err := cmngr.setConfigEvent(restoredConfigs); err != nil {
+		return err
+	}
+
+	for _, key := range restoredKeys {
+		if err := cmngr.restore(key, entries[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//getRollbackKeys returns the complete config keys that are covered by the given (possibly partial) key,
+//including keys that were deleted
+func (cmngr *configManagerImpl) getRollbackKeys(configKey api.ConfigKey) ([]api.ConfigKey, errors.Error) {
+	if ValidateConfigKey(configKey) == nil && (configKey.ComponentName == "" || configKey.ComponentVersion != "") {
+		return []api.ConfigKey{configKey}, nil
+	}
+	if configKey.MspID == "" {
+		return nil, errors.Errorf(errors.InvalidConfigKey, "Invalid config key %+v. MspID is required.", configKey)
+	}
+	knownKeys, err := cmngr.getIndexedKeys(indexMspIDKnown, []string{configKey.MspID})
+	if err != nil {
+		return nil, err
+	}
+	var keys []api.ConfigKey
+	for _, key := range knownKeys {
+		if MatchesKey(configKey, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+//getIndexedKeys returns the config keys of the given index and indexed fields
+func (cmngr *configManagerImpl) getIndexedKeys(index string, fields []string) ([]api.ConfigKey, errors.Error) {
+	it, e := cmngr.stub.GetStateByPartialCompositeKey(index, fields)
+	if e != nil {
+		return nil, errors.Wrapf(errors.SystemError, e, "Unexpected error querying index [%s]", index)
+	}
+	defer func() {
+		if iteratorErr := it.Close(); iteratorErr != nil {
+			logger.Warnf("Failed to close iterator : %s", iteratorErr)
+		}
+	}()
+	var keys []api.ConfigKey
+	for it.HasNext() {
+		compositeKey, e := it.Next()
+		if e != nil {
+			return nil, errors.WithMessage(errors.SystemError, e, "Failed to get next value from iterator")
+		}
+		key, err := cmngr.configKeyFromIndexKey(compositeKey.Key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+//restore puts the given history entry back into the ledger; a nil or deleted entry removes the key
+func (cmngr *configManagerImpl) restore(key api.ConfigKey, entry *api.ConfigHistoryEntry) errors.Error {
+	strKey, err := ConfigKeyToString(key)
+	if err != nil {
+		return err
+	}
+	if entry == nil || entry.IsDelete {
+		logger.Debugf("Rollback deletes config for key %s", strKey)
+		return cmngr.deleteConfig(key)
+	}
+	logger.Debugf("Rollback restores config for key %s from transaction %s", strKey, entry.TxID)
+	if err := cmngr.recordTx(); err != nil {
+		return err
+	}
+	if e := cmngr.stub.PutState(strKey, entry.Value); e != nil {
+		return errors.Wrap(errors.SystemError, e, "PutState has failed")
+	}
+	return cmngr.addIndexes(key)
+}
+
+//valueAsOf returns the history entry (in commit order) that was written by the given transaction
+//or nil if the transaction didn't change the key
+func valueAsOf(history []*api.ConfigHistoryEntry, txID string) *api.ConfigHistoryEntry {
+	for _, entry := range history {
+		if entry.TxID == txID {
+			return entry
 		}
 	}
 	return nil
 }
 
+//MatchesKey returns true if every non-empty field of the filter equals the corresponding field of the key
+func MatchesKey(filter api.ConfigKey, key api.ConfigKey) bool {
+	return matchesField(filter.MspID, key.MspID) &&
+		matchesField(filter.PeerID, key.PeerID) &&
+		matchesField(filter.AppName, key.AppName) &&
+		matchesField(filter.AppVersion, key.AppVersion) &&
+		matchesField(filter.ComponentName, key.ComponentName) &&
+		matchesField(filter.ComponentVersion, key.ComponentVersion)
+}
+
+func matchesField(filter, value string) bool {
+	return filter == "" || filter == value
+}
+
 //ParseConfigMessage unmarshals supplied config message and returns
 //map[compositekey]configurationbytes to the caller
 func ParseConfigMessage(configData []byte, txID string) (map[api.ConfigKey][]byte, errors.Error) {
+	parsedConfig, err := unmarshalConfigMessage(configData)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigMessage(parsedConfig, txID)
+}
+
+//ParseConfigData unmarshals the supplied ConfigMessage or ConfigBatch and returns
+//map[compositekey]configurationbytes of all of the configs to the caller
+func ParseConfigData(configData []byte, txID string) (map[api.ConfigKey][]byte, errors.Error) {
+	if !IsConfigBatch(configData) {
+		return ParseConfigMessage(configData, txID)
+	}
+	batch, err := unmarshalConfigBatch(configData)
+	if err != nil {
+		return nil, err
+	}
+	if err := batch.IsValid(); err != nil {
+		return nil, err
+	}
 	configMap := make(map[api.ConfigKey][]byte)
-	var parsedConfig api.ConfigMessage
-	if err := json.Unmarshal(configData, &parsedConfig); err != nil {
-		return nil, errors.Errorf(errors.UnmarshalError, "Cannot unmarshal config message %s %s", string(configData[:]), err)
+	for i := range batch.Messages {
+		configs, err := parseConfigMessage(&batch.Messages[i], txID)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range configs {
+			configMap[key] = value
+		}
+	}
+	return configMap, nil
+}
+
+//IsConfigBatch returns true if the supplied config data is a ConfigBatch rather than a ConfigMessage
+func IsConfigBatch(configData []byte) bool {
+	var batch struct {
+		Messages json.RawMessage
 	}
+	return json.Unmarshal(configData, &batch) == nil && len(batch.Messages) > 0
+}
+
+func parseConfigMessage(parsedConfig *api.ConfigMessage, txID string) (map[api.ConfigKey][]byte, errors.Error) {
+	configMap := make(map[api.ConfigKey][]byte)
 	//validate config
 	if err := parsedConfig.IsValid(); err != nil {
 		return nil, err
@@ -227,10 +668,10 @@ func ParseConfigMessage(configData []byte, txID string) (map[api.ConfigKey][]byt
 			if err != nil {
 				return nil, err
 			}
-			configMap[key] = []byte(appConfig.Config)
+			configMap[key] = appConfigValue(appConfig)
 		}
 	}
-	configMap, err := parseConfigComponent(parsedConfig, configMap, txID)
+	configMap, err := parseConfigComponent(*parsedConfig, configMap, txID)
 	if err != nil {
 		return nil, err
 	}
@@ -249,10 +690,13 @@ func parseConfigComponent(parsedConfig api.ConfigMessage, configMap map[api.Conf
 			if err != nil {
 				return nil, err
 			}
-			configMap[key] = []byte(app.Config)
+			configMap[key] = appConfigValue(app)
 		} else {
 			for _, v := range app.Components {
 				v.TxID = txID
+				//preconditions are only used when saving and are not stored with the component
+				v.ExpectedHash = ""
+				v.ExpectedTxID = ""
 				key, err = CreateConfigKey(mspID, "", app.AppName, app.Version, v.Name, v.Version)
 				if err != nil {
 					return nil, err
@@ -268,6 +712,115 @@ func parseConfigComponent(parsedConfig api.ConfigMessage, configMap map[api.Conf
 	return configMap, nil
 }
 
+//unmarshalConfigMessage unmarshals supplied config message
+func unmarshalConfigMessage(configData []byte) (*api.ConfigMessage, errors.Error) {
+	parsedConfig := &api.ConfigMessage{}
+	if err := json.Unmarshal(configData, parsedConfig); err != nil {
+		return nil, errors.Errorf(errors.UnmarshalError, "Cannot unmarshal config message %s %s", string(configData[:]), err)
+	}
+	return parsedConfig, nil
+}
+
+//unmarshalConfigBatch unmarshals supplied config batch
+func unmarshalConfigBatch(configData []byte) (*api.ConfigBatch, errors.Error) {
+	batch := &api.ConfigBatch{}
+	if err := json.Unmarshal(configData, batch); err != nil {
+		return nil, errors.Errorf(errors.UnmarshalError, "Cannot unmarshal config batch %s %s", string(configData[:]), err)
+	}
+	return batch, nil
+}
+
+//appConfigValue returns the value that is stored for the app config. Templated configs are prefixed
+//so that the config service knows to resolve them.
+func appConfigValue(app api.AppConfig) []byte {
+	if app.Templated {
+		return []byte(TemplatePrefix + app.Config)
+	}
+	return []byte(app.Config)
+}
+
+//parsePreconditions returns the preconditions that were specified for each config key
+func parsePreconditions(parsedConfig *api.ConfigMessage) (map[api.ConfigKey]api.Precondition, errors.Error) {
+	preconditions := make(map[api.ConfigKey]api.Precondition)
+	add := func(expectedHash, expectedTxID, peerID, appName, appVersion, componentName, componentVersion string) errors.Error {
+		if expectedHash == "" && expectedTxID == "" {
+			return nil
+		}
+		key, err := CreateConfigKey(parsedConfig.MspID, peerID, appName, appVersion, componentName, componentVersion)
+		if err != nil {
+			return err
+		}
+		preconditions[key] = api.Precondition{ExpectedHash: expectedHash, ExpectedTxID: expectedTxID}
+		return nil
+	}
+	for _, config := range parsedConfig.Peers {
+		for _, app := range config.App {
+			if err := add(app.ExpectedHash, app.ExpectedTxID, config.PeerID, app.AppName, app.Version, "", ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, app := range parsedConfig.Apps {
+		if len(app.Components) == 0 {
+			if err := add(app.ExpectedHash, app.ExpectedTxID, "", app.AppName, app.Version, "", ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, comp := range app.Components {
+			if err := add(comp.ExpectedHash, comp.ExpectedTxID, "", app.AppName, app.Version, comp.Name, comp.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return preconditions, nil
+}
+
+//checkPreconditions verifies that the stored configs still have the expected hash and TxID
+func (cmngr *configManagerImpl) checkPreconditions(preconditions map[api.ConfigKey]api.Precondition) errors.Error {
+	for key, precondition := range preconditions {
+		current, err := cmngr.getConfig(key)
+		if err != nil {
+			return err
+		}
+		if precondition.ExpectedHash != "" && GenerateHash(current) != precondition.ExpectedHash {
+			return errors.Errorf(errors.ConfigVersionConflict, "Config for key %s has changed: expected hash %s but stored hash is %s", key.String(), precondition.ExpectedHash, GenerateHash(current))
+		}
+		if precondition.ExpectedTxID != "" {
+			txID, err := cmngr.currentTxID(key, current)
+			if err != nil {
+				return err
+			}
+			if txID != precondition.ExpectedTxID {
+				return errors.Errorf(errors.ConfigVersionConflict, "Config for key %s has changed: expected TxID %s but stored config was written by TxID %s", key.String(), precondition.ExpectedTxID, txID)
+			}
+		}
+	}
+	return nil
+}
+
+//currentTxID returns the ID of the transaction that stored the current config for the given key
+func (cmngr *configManagerImpl) currentTxID(key api.ConfigKey, current []byte) (string, errors.Error) {
+	if len(current) == 0 {
+		return "", nil
+	}
+	if key.ComponentName != "" {
+		compConfig := api.ComponentConfig{}
+		if err := json.Unmarshal(current, &compConfig); err != nil {
+			return "", errors.Wrap(errors.UnmarshalError, err, "Failed to unmarshal app component")
+		}
+		return compConfig.TxID, nil
+	}
+	history, err := cmngr.GetHistory(key)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return "", nil
+	}
+	return history[len(history)-1].TxID, nil
+}
+
 //addIndexes for configKey
 func (cmngr *configManagerImpl) addIndexes(key api.ConfigKey) errors.Error {
 	if err := ValidateConfigKey(key); err != nil {
@@ -278,6 +831,29 @@ func (cmngr *configManagerImpl) addIndexes(key api.ConfigKey) errors.Error {
 			return addIndexErr
 		}
 	}
+	return cmngr.addIndex(indexMspIDKnown, key)
+}
+
+//removeIndexes removes the index entries of configKey
+func (cmngr *configManagerImpl) removeIndexes(key api.ConfigKey) errors.Error {
+	strKey, err := ConfigKeyToString(key)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		fields, err := getFieldsForIndex(index, key)
+		if err != nil {
+			return err
+		}
+		indexKey, err := cmngr.getIndexKey(index, strKey, fields)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Removing index [%s]\n", indexKey)
+		if e := cmngr.stub.DelState(indexKey); e != nil {
+			return errors.WithMessage(errors.SystemError, e, "Failed to remove index")
+		}
+	}
 	return nil
 }
 
@@ -335,8 +911,16 @@ func getFieldsForIndex(index string, key api.ConfigKey) ([]string, errors.Error)
 		return nil, err
 	}
 	switch index {
-	case indexMspID:
+	case indexMspID, indexMspIDKnown:
 		return []string{key.MspID}, nil
+	case indexMspIDApp:
+		return []string{key.MspID, key.AppName, key.ComponentName}, nil
+	case indexMspIDPeer:
+		return []string{key.MspID, key.PeerID}, nil
+	case indexAppName:
+		return []string{key.AppName, key.MspID}, nil
+	case indexPeerID:
+		return []string{key.PeerID, key.MspID}, nil
 	default:
 		return nil, errors.Errorf(errors.SystemError, "unknown index [%s]", index)
 	}
@@ -377,12 +961,7 @@ func (cmngr *configManagerImpl) getConfigurations(index string, fields []string)
 		if e != nil {
 			return nil, errors.WithMessage(errors.SystemError, e, "Failed to get next value from iterator")
 		}
-		_, compositeKeyParts, e := cmngr.stub.SplitCompositeKey(compositeKey.Key)
-		if e != nil {
-			return nil, errors.Wrapf(errors.SystemError, err, "Unexpected error splitting composite key. Key: [%s], Error: %s", compositeKey, err)
-		}
-		configID := compositeKeyParts[len(compositeKeyParts)-1]
-		ck, err := StringToConfigKey(configID)
+		ck, err := cmngr.configKeyFromIndexKey(compositeKey.Key)
 		if err != nil {
 			return nil, err
 		}
@@ -398,6 +977,176 @@ func (cmngr *configManagerImpl) getConfigurations(index string, fields []string)
 	return configKeys, nil
 }
 
+// GetByAppName returns the configurations of the given app for all MSPs
+func (cmngr *configManagerImpl) GetByAppName(appName string) ([]*api.ConfigKV, errors.Error) {
+	if appName == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "AppName is required")
+	}
+	return cmngr.getConfigurations(indexAppName, []string{appName})
+}
+
+// GetByPeerID returns the configurations of the given peer for all MSPs
+func (cmngr *configManagerImpl) GetByPeerID(peerID string) ([]*api.ConfigKV, errors.Error) {
+	if peerID == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "PeerID is required")
+	}
+	return cmngr.getConfigurations(indexPeerID, []string{peerID})
+}
+
+//configKeyFromIndexKey returns the config key that is referenced by the given index key
+func (cmngr *configManagerImpl) configKeyFromIndexKey(indexKey string) (api.ConfigKey, errors.Error) {
+	_, compositeKeyParts, err := cmngr.stub.SplitCompositeKey(indexKey)
+	if err != nil {
+		return api.ConfigKey{}, errors.Wrapf(errors.SystemError, err, "Unexpected error splitting composite key. Key: [%s]", indexKey)
+	}
+	if len(compositeKeyParts) == 0 {
+		return api.ConfigKey{}, errors.Errorf(errors.SystemError, "Invalid composite key [%s]", indexKey)
+	}
+	return StringToConfigKey(compositeKeyParts[len(compositeKeyParts)-1])
+}
+
+// Query returns a page of the configurations that match the given query.
+// The results are ordered by index key and the bookmark of the returned page
+// is the (base64 encoded) index key of its last config. The next page is read
+// from the index starting after that key.
+func (cmngr *configManagerImpl) Query(query api.ConfigQuery) (*api.ConfigQueryResult, errors.Error) {
+	if query.MspID == "" {
+		return nil, errors.Errorf(errors.InvalidConfigKey, "MspID is required for query %+v", query)
+	}
+	if query.PageSize <= 0 {
+		return nil, errors.Errorf(errors.ValidationError, "Invalid page size [%d]", query.PageSize)
+	}
+	bookmark, e := base64.StdEncoding.DecodeString(query.Bookmark)
+	if e != nil {
+		return nil, errors.Wrapf(errors.ValidationError, e, "Invalid bookmark [%s]", query.Bookmark)
+	}
+	startKey := ""
+	if len(bookmark) > 0 {
+		//the smallest key that follows the last key of the previous page
+		startKey = string(bookmark) + minUnicodeRuneValue
+	}
+
+	index, fields := getQueryIndexAndFields(query)
+	result := &api.ConfigQueryResult{}
+	lastKey := ""
+	for {
+		//one more config than the page size is read to find out if there is a next page
+		indexKeys, nextStartKey, err := cmngr.getIndexKeysPage(index, fields, int32(query.PageSize+1), startKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, indexKey := range indexKeys {
+			ck, err := cmngr.configKeyFromIndexKey(indexKey)
+			if err != nil {
+				return nil, err
+			}
+			if !matchesQuery(query, ck) {
+				continue
+			}
+			if len(result.Configs) == query.PageSize {
+				//there are more results so return a bookmark for the next page
+				result.Bookmark = base64.StdEncoding.EncodeToString([]byte(lastKey))
+				return result, nil
+			}
+			config, err := cmngr.getConfig(ck)
+			if err != nil {
+				return nil, err
+			}
+			result.Configs = append(result.Configs, &api.ConfigKV{Key: ck, Value: config})
+			lastKey = indexKey
+		}
+		if nextStartKey == "" {
+			return result, nil
+		}
+		startKey = nextStartKey
+	}
+}
+
+//getIndexKeysPage returns a page of the index keys of the given index and indexed fields starting at the given key
+//along with the key at which the next page starts. The returned start key is empty if there are no more index keys.
+func (cmngr *configManagerImpl) getIndexKeysPage(index string, fields []string, pageSize int32, startKey string) ([]string, string, errors.Error) {
+	it, metadata, e := cmngr.stub.GetStateByPartialCompositeKeyWithPagination(index, fields, pageSize, startKey)
+	if e != nil {
+		return nil, "", errors.Wrapf(errors.SystemError, e, "Unexpected error querying configurations with index [%s]", index)
+	}
+	defer func() {
+		if iteratorErr := it.Close(); iteratorErr != nil {
+			logger.Warnf("Failed to close iterator : %s", iteratorErr)
+		}
+	}()
+
+	var indexKeys []string
+	for it.HasNext() {
+		compositeKey, e := it.Next()
+		if e != nil {
+			return nil, "", errors.WithMessage(errors.SystemError, e, "Failed to get next value from iterator")
+		}
+		indexKeys = append(indexKeys, compositeKey.Key)
+	}
+	if metadata == nil || metadata.FetchedRecordsCount < pageSize {
+		return indexKeys, "", nil
+	}
+	return indexKeys, metadata.Bookmark, nil
+}
+
+// Reindex rebuilds the index entries of the configurations of the given MSP, or of all MSPs if the MSP ID is empty.
+// Configurations that were saved before an index was introduced are only found through that index once they are
+// reindexed (or saved again). GetByAppName and GetByPeerID span all MSPs so every MSP has to be reindexed for them.
+// Configurations that were deleted before deletes removed their index entries still have an MSP index entry, so the
+// entries of keys that have no config are removed rather than rebuilt.
+func (cmngr *configManagerImpl) Reindex(mspID string) (*api.ReindexResult, errors.Error) {
+	var fields []string
+	if mspID != "" {
+		fields = []string{mspID}
+	}
+	keys, err := cmngr.getIndexedKeys(indexMspID, fields)
+	if err != nil {
+		return nil, err
+	}
+	result := &api.ReindexResult{}
+	for _, key := range keys {
+		config, err := cmngr.getConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(config) == 0 {
+			logger.Debugf("Removing stale index entries of deleted config for key %s", key.String())
+			if err := cmngr.removeIndexes(key); err != nil {
+				return nil, err
+			}
+			result.Removed = append(result.Removed, key)
+			continue
+		}
+		logger.Debugf("Reindexing config for key %s", key.String())
+		if err := cmngr.addIndexes(key); err != nil {
+			return nil, err
+		}
+		result.Reindexed = append(result.Reindexed, key)
+	}
+	return result, nil
+}
+
+//getQueryIndexAndFields returns the most selective index (and its fields) for the given query
+func getQueryIndexAndFields(query api.ConfigQuery) (string, []string) {
+	switch {
+	case query.PeerID != "":
+		return indexMspIDPeer, []string{query.MspID, query.PeerID}
+	case query.AppName != "" && query.ComponentName != "":
+		return indexMspIDApp, []string{query.MspID, query.AppName, query.ComponentName}
+	case query.AppName != "":
+		return indexMspIDApp, []string{query.MspID, query.AppName}
+	default:
+		return indexMspID, []string{query.MspID}
+	}
+}
+
+//matchesQuery returns true if the given key satisfies all of the filters of the query
+func matchesQuery(query api.ConfigQuery, key api.ConfigKey) bool {
+	return matchesField(query.PeerID, key.PeerID) &&
+		matchesField(query.AppName, key.AppName) &&
+		matchesField(query.ComponentName, key.ComponentName)
+}
+
 //getIndexAndFields index and fields for search
 func getIndexAndFields(key api.ConfigKey) (string, []string, errors.Error) {
 	fields, err := getIndexedFields(key)
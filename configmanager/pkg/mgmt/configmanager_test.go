@@ -7,14 +7,21 @@ SPDX-License-Identifier: Apache-2.0
 package mgmt
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"testing"
 
 	"strings"
 
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mocks"
+	utilErr "github.com/securekey/fabric-snaps/util/errors"
 )
 
 const (
@@ -242,6 +249,100 @@ func TestPutStateSuccess(t *testing.T) {
 	}
 }
 
+func TestConfigEvent(t *testing.T) {
+	stub := shim.NewMockStub("testConfigState", nil)
+	stub.MockTransactionStart("saveTxn")
+	configManager := NewConfigManager(stub)
+	key := api.ConfigKey{MspID: "msp.one", AppName: "app1", AppVersion: "1"}
+
+	if err := configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"app1","Version":"1","Config":"v1"}]}`)); err != nil {
+		t.Fatalf("Cannot save config %s", err)
+	}
+	stub.MockTransactionEnd("saveTxn")
+	assertConfigEvent(t, stub, api.ConfigChange{Key: key, NewHash: GenerateHash([]byte("v1"))})
+
+	stub.MockTransactionStart("updateTxn")
+	if err := configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"app1","Version":"1","Config":"v2"}]}`)); err != nil {
+		t.Fatalf("Cannot save config %s", err)
+	}
+	stub.MockTransactionEnd("updateTxn")
+	assertConfigEvent(t, stub, api.ConfigChange{Key: key, OldHash: GenerateHash([]byte("v1")), NewHash: GenerateHash([]byte("v2"))})
+
+	stub.MockTransactionStart("deleteTxn")
+	if err := configManager.Delete(key); err != nil {
+		t.Fatalf("Cannot delete config %s", err)
+	}
+	stub.MockTransactionEnd("deleteTxn")
+	assertConfigEvent(t, stub, api.ConfigChange{Key: key, OldHash: GenerateHash([]byte("v2"))})
+}
+
+func assertConfigEvent(t *testing.T, stub *shim.MockStub, expected ...api.ConfigChange) {
+	ccEvent := <-stub.ChaincodeEventsChannel
+	event := &api.ConfigEvent{}
+	if err := json.Unmarshal(ccEvent.Payload, event); err != nil {
+		t.Fatalf("Cannot unmarshal event payload %s", err)
+	}
+	if len(event.Changes) != len(expected) {
+		t.Fatalf("Expected changes %v but got %v", expected, event.Changes)
+	}
+	for i, change := range event.Changes {
+		if change != expected[i] {
+			t.Fatalf("Expected change %v but got %v", expected[i], change)
+		}
+	}
+}
+
+func TestSaveBatch(t *testing.T) {
+	stub := shim.NewMockStub("testConfigState", nil)
+	stub.MockTransactionStart("batchTx")
+	configManager := NewConfigManager(stub)
+
+	//the whole batch is rejected if any message is invalid
+	invalidBatch := `{"Messages":[{"MspID":"Org1MSP","Apps":[{"AppName":"app1","Version":"1","Config":"org1 config"}]},{"MspID":"Org2MSP","Apps":[{"AppName":"app1","Version":"1","Config":"{invalid","ConfigType":"JSON"}]}]}`
+	if err := configManager.Save([]byte(invalidBatch)); err == nil || !strings.Contains(err.Error(), "Org2MSP") {
+		t.Fatalf("Expected batch with invalid message to be rejected but got %v", err)
+	}
+	key1 := api.ConfigKey{MspID: "Org1MSP", AppName: "app1", AppVersion: "1"}
+	if configs, err := configManager.Get(key1); err != nil || len(configs) != 0 {
+		t.Fatalf("Expected no configs to be saved for rejected batch but got %v, %v", configs, err)
+	}
+	if err := configManager.Save([]byte(`{"Messages":[{"MspID":"Org1MSP","Apps":[{"AppName":"app1","Version":"1","Config":"a"}]},{"MspID":"Org1MSP","Apps":[{"AppName":"app2","Version":"1","Config":"b"}]}]}`)); err == nil {
+		t.Fatalf("Expected batch with duplicate MSP to be rejected")
+	}
+	if err := configManager.Save([]byte(`{"Messages":[]}`)); err == nil {
+		t.Fatalf("Expected empty batch to be rejected")
+	}
+
+	batch := `{"Messages":[{"MspID":"Org1MSP","Apps":[{"AppName":"app1","Version":"1","Config":"org1 config"}]},{"MspID":"Org2MSP","Peers":[{"PeerID":"peer0.org2","App":[{"AppName":"app1","Version":"1","Config":"org2 peer config"}]}]},{"MspID":"general","Apps":[{"AppName":"app1","Version":"1","Components":[{"Name":"comp1","Version":"1","Config":"general comp config"}]}]}]}`
+	if err := configManager.Save([]byte(batch)); err != nil {
+		t.Fatalf("Cannot save batch %s", err)
+	}
+	assertConfigValue(t, configManager, key1, "org1 config")
+	assertConfigValue(t, configManager, api.ConfigKey{MspID: "Org2MSP", PeerID: "peer0.org2", AppName: "app1", AppVersion: "1"}, "org2 peer config")
+	configs, err := configManager.Get(api.ConfigKey{MspID: "general", AppName: "app1", AppVersion: "1", ComponentName: "comp1", ComponentVersion: "1"})
+	if err != nil || len(configs) != 1 {
+		t.Fatalf("Expected general component config but got %v, %v", configs, err)
+	}
+
+	//a single event lists all of the keys of the batch
+	ccEvent := <-stub.ChaincodeEventsChannel
+	event := &api.ConfigEvent{}
+	if err := json.Unmarshal(ccEvent.Payload, event); err != nil {
+		t.Fatalf("Cannot unmarshal event payload %s", err)
+	}
+	if len(event.Changes) != 3 {
+		t.Fatalf("Expected 3 changes in event but got %v", event.Changes)
+	}
+	if len(stub.ChaincodeEventsChannel) != 0 {
+		t.Fatalf("Expected a single event for the batch")
+	}
+
+	configMap, err := ParseConfigData([]byte(batch), "batchTx")
+	if err != nil || len(configMap) != 3 {
+		t.Fatalf("Expected 3 configs in parsed batch but got %v, %v", configMap, err)
+	}
+}
+
 func TestGetFieldsForIndex(t *testing.T) {
 	key := api.ConfigKey{}
 	if _, err := getFieldsForIndex("abc", key); err == nil {
@@ -287,18 +388,19 @@ func TestAddIndexes(t *testing.T) {
 	}
 
 	key.AppName = "appName"
-	indexes = [...]string{"abc"}
+	validIndexes := indexes
+	indexes[0] = "abc"
 	if err := configManagerImpl.addIndexes(key); err == nil {
 		t.Fatalf("Expected error:'Cannot create config error adding index [abc]: unknown index [abc]")
 	}
 
-	indexes = [...]string{""}
+	indexes[0] = ""
 	if err := configManagerImpl.addIndexes(key); err == nil {
 		t.Fatalf("Expected error:'error adding index []: Index is empty")
 	}
 
-	//reset to valid index
-	indexes = [...]string{indexMspID}
+	//reset to valid indexes
+	indexes = validIndexes
 	key = api.ConfigKey{}
 	if err := configManagerImpl.addIndexes(key); err == nil {
 		t.Fatalf("Expected error:'Cannot create empty config key")
@@ -679,8 +781,9 @@ func TestDeleteComponentWithoutVersion(t *testing.T) {
 	stub.MockTransactionEnd("saveConfiguration")
 	stub.MockTransactionStart("a")
 
+	//the index entries of deleted configs are removed so no configs are found for the component
 	config, err = configManager.Get(key)
-	if len(config[0].Value) != 0 {
+	if len(config) != 0 {
 		t.Fatalf("Config should be deleted for key %v ", key)
 	}
 
@@ -727,6 +830,17 @@ func TestDeleteByMspID(t *testing.T) {
 	}
 	stub.MockTransactionEnd("saveConfiguration")
 
+	//no orphan index entries should be left behind
+	for _, index := range indexes {
+		it, err := stub.GetStateByPartialCompositeKey(index, []string{})
+		if err != nil {
+			t.Fatalf("Error getting index %s: %s", index, err)
+		}
+		if it.HasNext() {
+			t.Fatalf("Index entries should be deleted for index %s", index)
+		}
+		it.Close()
+	}
 }
 
 func TestDeleteWithNonExistingValidKey(t *testing.T) {
@@ -751,6 +865,573 @@ func TestDeleteWithInvalidKey(t *testing.T) {
 	}
 }
 
+func TestGetHistory(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validMsg)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	stub.MockTransactionEnd("startTxn")
+
+	stub.MockTransactionStart("updateTxn")
+	if err := configManager.Save([]byte(strings.Replace(validMsg, "config for appNameOne v1", "updated config for appNameOne v1", 1))); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	stub.MockTransactionEnd("updateTxn")
+
+	key, _ := CreateConfigKey(mspID, "peer.zero.example.com", "appNameOne", "1", "", "")
+	history, err := configManager.GetHistory(key)
+	if err != nil {
+		t.Fatalf("Cannot get history for key %s %s", key, err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("Expected two history entries for key %s but got %d", key, len(history))
+	}
+	if history[0].TxID != "startTxn" || string(history[0].Value) != "config for appNameOne v1" {
+		t.Fatalf("Unexpected first history entry %+v", history[0])
+	}
+	if history[1].TxID != "updateTxn" || string(history[1].Value) != "updated config for appNameOne v1" {
+		t.Fatalf("Unexpected second history entry %+v", history[1])
+	}
+
+	if history[0].Creator == nil || history[0].Creator.MspID != "Org1MSP" {
+		t.Fatalf("Expected the creator of startTxn to be recorded but got %+v", history[0].Creator)
+	}
+
+	entry, err := configManager.GetAsOf(key, "startTxn")
+	if err != nil {
+		t.Fatalf("Cannot get config as of startTxn for key %s %s", key, err)
+	}
+	if string(entry.Value) != "config for appNameOne v1" {
+		t.Fatalf("Expected original config but got %s", string(entry.Value))
+	}
+	entry, err = configManager.GetAsOf(key, "updateTxn")
+	if err != nil {
+		t.Fatalf("Cannot get config as of updateTxn for key %s %s", key, err)
+	}
+	if string(entry.Value) != "updated config for appNameOne v1" {
+		t.Fatalf("Expected updated config but got %s", string(entry.Value))
+	}
+
+	if _, err := configManager.GetAsOf(key, "bogusTxn"); err == nil {
+		t.Fatalf("Expected error 'No config found for key'")
+	}
+	if _, err := configManager.GetAsOf(key, ""); err == nil {
+		t.Fatalf("Expected error 'TxID is required'")
+	}
+	if _, err := configManager.GetHistory(api.ConfigKey{MspID: mspID}); err == nil {
+		t.Fatalf("Expected error 'Cannot create config key using empty PeerID and an empty AppName'")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validMsg)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	stub.MockTransactionEnd("startTxn")
+
+	//the timestamp of a transaction is set by the client so it doesn't reflect the commit order
+	stub.MockTransactionStart("updateTxn")
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: 1}
+	if err := configManager.Save([]byte(strings.Replace(validMsg, "config for appNameOne v", "updated config for appNameOne v", -1))); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	if err := configManager.Save([]byte(noPeerWithAppAndConfig)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	stub.MockTransactionEnd("updateTxn")
+
+	//roll back a single key
+	stub.MockTransactionStart("rollbackTxn")
+	key, _ := CreateConfigKey(mspID, "peer.zero.example.com", "appNameOne", "1", "", "")
+	if err := configManager.Rollback(key, "startTxn"); err != nil {
+		t.Fatalf("Cannot roll back key %s: %s", key, err)
+	}
+	stub.MockTransactionEnd("rollbackTxn")
+	assertConfigValue(t, configManager, key, "config for appNameOne v1")
+	key2, _ := CreateConfigKey(mspID, "peer.zero.example.com", "appNameOne", "2", "", "")
+	assertConfigValue(t, configManager, key2, "updated config for appNameOne v2")
+
+	//deleted keys are restored by a rollback of every key of the MSP
+	stub.MockTransactionStart("deleteTxn")
+	if err := configManager.Delete(key2); err != nil {
+		t.Fatalf("Cannot delete key %s: %s", key2, err)
+	}
+	stub.MockTransactionEnd("deleteTxn")
+	assertConfigValue(t, configManager, key2, "")
+
+	//roll back every key of the MSP - key created by updateTxn wasn't changed by startTxn so it is left as it is
+	publicKey, _ := CreateConfigKey(mspID, "", "publickey", "1", "", "")
+	publicKeyConfig, err := configManager.Get(publicKey)
+	if err != nil || len(publicKeyConfig) == 0 || len(publicKeyConfig[0].Value) == 0 {
+		t.Fatalf("Expected config for key %s: %v", publicKey, err)
+	}
+	stub.MockTransactionStart("rollbackAllTxn")
+	if err := configManager.Rollback(api.ConfigKey{MspID: mspID}, "startTxn"); err != nil {
+		t.Fatalf("Cannot roll back msp %s: %s", mspID, err)
+	}
+	stub.MockTransactionEnd("rollbackAllTxn")
+	assertConfigValue(t, configManager, key2, "config for appNameOne v2")
+	assertConfigValue(t, configManager, publicKey, string(publicKeyConfig[0].Value))
+
+	ccEvent := <-stub.ChaincodeEventsChannel
+	if ccEvent == nil {
+		t.Fatalf("No cc event was set for rollback")
+	}
+
+	stub.MockTransactionStart("invalidRollbackTxn")
+	if err := configManager.Rollback(key, "bogusTxn"); err == nil {
+		t.Fatalf("Expected error 'Transaction bogusTxn did not update any config'")
+	}
+	//transactions that were committed before transactions were recorded can be rolled back to
+	txRecordKey, _ := stub.CreateCompositeKey(txObjectType, []string{"startTxn"})
+	if err := stub.DelState(txRecordKey); err != nil {
+		t.Fatalf("Cannot delete transaction record: %s", err)
+	}
+	if err := configManager.Rollback(api.ConfigKey{MspID: mspID}, "startTxn"); err != nil {
+		t.Fatalf("Cannot roll back msp %s: %s", mspID, err)
+	}
+	if err := configManager.Rollback(key, ""); err == nil {
+		t.Fatalf("Expected error 'TxID is required'")
+	}
+	stub.MockTransactionEnd("invalidRollbackTxn")
+}
+
+func TestSaveWithPreconditions(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validWithAppComponents)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	if err := configManager.Save([]byte(noPeerWithAppAndConfig)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	stub.MockTransactionEnd("startTxn")
+
+	appHash := GenerateHash([]byte("{type:a, key:b}"))
+	appMsg := `{"MspID":"msp.one", "Apps": [{"AppName": "publickey", "Version": "1", "Config": "{type:c}", "ExpectedHash": "%s", "ExpectedTxID": "%s"}]}`
+	compMsg := `{"MspID":"msp.one","Apps":[{"AppName":"app1","Version":"1","Components":[{"Name":"comp1","Config":"{comp1 data ver 1 updated}","Version":"1","ExpectedTxID":"%s"}]}]}`
+
+	stub.MockTransactionStart("conflictTxn")
+	err := configManager.Save([]byte(fmt.Sprintf(appMsg, "bogusHash", "")))
+	if err == nil || err.ErrorCode() != utilErr.ConfigVersionConflict {
+		t.Fatalf("Expected version conflict for unexpected hash but got %v", err)
+	}
+	err = configManager.Save([]byte(fmt.Sprintf(appMsg, appHash, "bogusTxn")))
+	if err == nil || err.ErrorCode() != utilErr.ConfigVersionConflict {
+		t.Fatalf("Expected version conflict for unexpected TxID but got %v", err)
+	}
+	err = configManager.Save([]byte(fmt.Sprintf(compMsg, "bogusTxn")))
+	if err == nil || err.ErrorCode() != utilErr.ConfigVersionConflict {
+		t.Fatalf("Expected version conflict for unexpected component TxID but got %v", err)
+	}
+	stub.MockTransactionEnd("conflictTxn")
+
+	stub.MockTransactionStart("updateTxn")
+	if err := configManager.Save([]byte(fmt.Sprintf(appMsg, appHash, "startTxn"))); err != nil {
+		t.Fatalf("Save with matching preconditions failed: %s", err)
+	}
+	if err := configManager.Save([]byte(fmt.Sprintf(compMsg, "startTxn"))); err != nil {
+		t.Fatalf("Save with matching preconditions failed: %s", err)
+	}
+	stub.MockTransactionEnd("updateTxn")
+
+	key, _ := CreateConfigKey(mspID, "", "publickey", "1", "", "")
+	assertConfigValue(t, configManager, key, "{type:c}")
+	key, _ = CreateConfigKey(mspID, "", "app1", "1", "comp1", "1")
+	assertConfigValue(t, configManager, key, `{"Name":"comp1","Config":"{comp1 data ver 1 updated}","Version":"1","TxID":"updateTxn"}`)
+
+	//the second save of the same version is now stale
+	stub.MockTransactionStart("staleTxn")
+	err = configManager.Save([]byte(fmt.Sprintf(appMsg, appHash, "")))
+	if err == nil || err.ErrorCode() != utilErr.ConfigVersionConflict {
+		t.Fatalf("Expected version conflict for stale hash but got %v", err)
+	}
+	stub.MockTransactionEnd("staleTxn")
+}
+
+func TestSaveWithSchema(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+
+	schemaMsg := `{"MspID":"general","Apps":[{"AppName":"configschema","Version":"1","Components":[{"Name":"schemaApp","Version":"1","Config":"{\"type\":\"object\",\"properties\":{\"port\":{\"type\":\"integer\"}},\"required\":[\"port\"]}"}]}]}`
+	if err := configManager.Save([]byte(schemaMsg)); err != nil {
+		t.Fatalf("Cannot save schema %s", err)
+	}
+	err := configManager.Save([]byte(`{"MspID":"general","Apps":[{"AppName":"configschema","Version":"1","Components":[{"Name":"badSchema","Version":"1","Config":"{\"type\":5}"}]}]}`))
+	if err == nil || err.ErrorCode() != utilErr.InvalidAppConfig {
+		t.Fatalf("Expected invalid schema to be rejected but got %v", err)
+	}
+
+	appMsg := `{"MspID":"msp.one","Apps":[{"AppName":"schemaApp","Version":"1","ConfigType":"%s","Config":"%s"}]}`
+	if err := configManager.Save([]byte(fmt.Sprintf(appMsg, "YAML", "port: 8080"))); err != nil {
+		t.Fatalf("Save of valid YAML config failed: %s", err)
+	}
+	if err := configManager.Save([]byte(fmt.Sprintf(appMsg, "JSON", `{\"port\":8080}`))); err != nil {
+		t.Fatalf("Save of valid JSON config failed: %s", err)
+	}
+	err = configManager.Save([]byte(fmt.Sprintf(appMsg, "YAML", "port: abc")))
+	if err == nil || err.ErrorCode() != utilErr.InvalidAppConfig || !strings.Contains(err.Error(), "port") {
+		t.Fatalf("Expected config not matching schema to be rejected but got %v", err)
+	}
+	err = configManager.Save([]byte(fmt.Sprintf(appMsg, "JSON", "{port")))
+	if err == nil || err.ErrorCode() != utilErr.InvalidAppConfig {
+		t.Fatalf("Expected invalid JSON to be rejected but got %v", err)
+	}
+
+	//configs without a schema are only checked for syntax
+	err = configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"otherApp","Version":"1","ConfigType":"YAML","Config":"a: [b"}]}`))
+	if err == nil || err.ErrorCode() != utilErr.InvalidAppConfig {
+		t.Fatalf("Expected invalid YAML to be rejected but got %v", err)
+	}
+	if err := configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"otherApp","Version":"1","Config":"anything"}]}`)); err != nil {
+		t.Fatalf("Save of config without schema failed: %s", err)
+	}
+}
+
+func TestEncryptConfig(t *testing.T) {
+	privateKey, e := rsa.GenerateKey(rand.Reader, 2048)
+	if e != nil {
+		t.Fatalf("Error generating key %s", e)
+	}
+	unwrap := func(keyID string, wrappedKey []byte) ([]byte, error) {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	}
+
+	if _, err := EncryptConfig([]byte("secret"), nil, "keyID"); err == nil {
+		t.Fatalf("Expected error 'Public key is required'")
+	}
+	encrypted, err := EncryptConfig([]byte("secret"), &privateKey.PublicKey, "keyID")
+	if err != nil {
+		t.Fatalf("Error encrypting config %s", err)
+	}
+	if !IsEncrypted([]byte(encrypted)) || strings.Contains(encrypted, "secret") {
+		t.Fatalf("Expected encrypted config but got %s", encrypted)
+	}
+	decrypted, err := DecryptConfig([]byte(encrypted), unwrap)
+	if err != nil {
+		t.Fatalf("Error decrypting config %s", err)
+	}
+	if string(decrypted) != "secret" {
+		t.Fatalf("Expected decrypted config [secret] but got [%s]", decrypted)
+	}
+	if _, err := DecryptConfig([]byte("secret"), unwrap); err == nil {
+		t.Fatalf("Expected error 'Config is not encrypted'")
+	}
+	if _, err := DecryptConfig([]byte(EncryptedConfigPrefix+"%%%"), unwrap); err == nil {
+		t.Fatalf("Expected error decoding encrypted config")
+	}
+
+	//a config that is flagged as encrypted must be encrypted
+	configManager := NewConfigManager(mocks.NewMockStub("testChannel"))
+	if err := configManager.Save([]byte(fmt.Sprintf(`{"MspID":"msp.one","Apps":[{"AppName":"secretApp","Version":"1","Encrypted":true,"Config":"%s"}]}`, encrypted))); err != nil {
+		t.Fatalf("Cannot save encrypted config %s", err)
+	}
+	err = configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"secretApp","Version":"1","Encrypted":true,"Config":"secret"}]}`))
+	if err == nil || err.ErrorCode() != utilErr.InvalidAppConfig {
+		t.Fatalf("Expected plaintext config flagged as encrypted to be rejected but got %v", err)
+	}
+}
+
+func TestTemplatedConfig(t *testing.T) {
+	configManager := NewConfigManager(mocks.NewMockStub("testChannel"))
+	template := `{"MspID":"msp.one","Apps":[{"AppName":"templatedApp","Version":"1","Templated":true,"Config":"id=${peer.id} url=${serverURL}"}]}`
+
+	//serverURL is not defined
+	err := configManager.Save([]byte(template))
+	if err == nil || err.ErrorCode() != utilErr.ValidationError || !strings.Contains(err.Error(), "serverURL") {
+		t.Fatalf("Expected template with undefined variable to be rejected but got %v", err)
+	}
+
+	if err := configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"configvariables","Version":"1","Config":"{\"serverURL\":\"https://server:443\"}"}]}`)); err != nil {
+		t.Fatalf("Cannot save variables %s", err)
+	}
+	if err := configManager.Save([]byte(template)); err != nil {
+		t.Fatalf("Cannot save templated config %s", err)
+	}
+	key := api.ConfigKey{MspID: "msp.one", AppName: "templatedApp", AppVersion: "1"}
+	assertConfigValue(t, configManager, key, TemplatePrefix+"id=${peer.id} url=${serverURL}")
+
+	resolved, err := ResolveTemplate([]byte(TemplatePrefix+"id=${peer.id} url=${serverURL}"), map[string]string{PeerIDVariable: "peer0", "serverURL": "https://server:443"})
+	if err != nil {
+		t.Fatalf("Cannot resolve template %s", err)
+	}
+	if string(resolved) != "id=peer0 url=https://server:443" {
+		t.Fatalf("Unexpected resolved config [%s]", resolved)
+	}
+	if _, err := ResolveTemplate([]byte(TemplatePrefix+"id=${peer.id}"), nil); err == nil {
+		t.Fatalf("Expected error resolving template with undefined variable")
+	}
+
+	//a config cannot be both templated and encrypted
+	err = configManager.Save([]byte(`{"MspID":"msp.one","Apps":[{"AppName":"templatedApp","Version":"1","Templated":true,"Encrypted":true,"Config":"id=${peer.id}"}]}`))
+	if err == nil || err.ErrorCode() != utilErr.ValidationError {
+		t.Fatalf("Expected templated and encrypted config to be rejected but got %v", err)
+	}
+}
+
+func assertConfigValue(t *testing.T, configManager api.ConfigManager, key api.ConfigKey, expected string) {
+	configs, err := configManager.Get(key)
+	if err != nil {
+		t.Fatalf("Cannot get config for key %s: %s", key, err)
+	}
+	if len(configs) != 1 || string(configs[0].Value) != expected {
+		t.Fatalf("Expected config [%s] for key %s but got %v", expected, key, configs)
+	}
+}
+
+func TestGetByAppNameAndPeerID(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	for _, msg := range []string{validMsg, validMsgForMspTwo, validWithAppComponents} {
+		if err := configManager.Save([]byte(msg)); err != nil {
+			t.Fatalf("Cannot save state %s", err)
+		}
+	}
+
+	if _, err := configManager.GetByAppName(""); err == nil {
+		t.Fatalf("Expected error 'AppName is required'")
+	}
+	if _, err := configManager.GetByPeerID(""); err == nil {
+		t.Fatalf("Expected error 'PeerID is required'")
+	}
+
+	//appNameTwo is configured for two peers of msp.one and two peers of msp.two
+	configs, err := configManager.GetByAppName("appNameTwo")
+	if err != nil {
+		t.Fatalf("GetByAppName failed: %s", err)
+	}
+	if len(configs) != 4 {
+		t.Fatalf("Expected 4 configs for appNameTwo but got %d", len(configs))
+	}
+	configs, err = configManager.GetByAppName("app1")
+	if err != nil {
+		t.Fatalf("GetByAppName failed: %s", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 component configs for app1 but got %d", len(configs))
+	}
+
+	//peer.one.one.example.com is only configured in msp.two since validMsgOne was not saved
+	configs, err = configManager.GetByPeerID("peer.one.one.example.com")
+	if err != nil {
+		t.Fatalf("GetByPeerID failed: %s", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 configs for peer.one.one.example.com but got %d", len(configs))
+	}
+
+	//deleted configs are no longer returned
+	key, _ := CreateConfigKey("msp.two", "peer.one.one.example.com", "appNameTwo", "1", "", "")
+	if err := configManager.Delete(key); err != nil {
+		t.Fatalf("Cannot delete config for key %s: %s", key, err)
+	}
+	configs, err = configManager.GetByAppName("appNameTwo")
+	if err != nil {
+		t.Fatalf("GetByAppName failed: %s", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 configs for appNameTwo after delete but got %d", len(configs))
+	}
+	configs, err = configManager.GetByPeerID("peer.one.one.example.com")
+	if err != nil {
+		t.Fatalf("GetByPeerID failed: %s", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("Expected 2 configs for peer.one.one.example.com after delete but got %d", len(configs))
+	}
+}
+
+func TestQuery(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validMsg)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+	if err := configManager.Save([]byte(validWithAppComponents)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+
+	if _, err := configManager.Query(api.ConfigQuery{PageOptions: api.PageOptions{PageSize: 1}}); err == nil {
+		t.Fatalf("Expected error for query without MspID")
+	}
+	if _, err := configManager.Query(api.ConfigQuery{MspID: mspID}); err == nil {
+		t.Fatalf("Expected error for query without page size")
+	}
+	if _, err := configManager.Query(api.ConfigQuery{MspID: mspID, PageOptions: api.PageOptions{PageSize: 1, Bookmark: "%%%"}}); err == nil {
+		t.Fatalf("Expected error for invalid bookmark")
+	}
+
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID}, numOfRecords+3)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, PeerID: "peer.zero.example.com"}, 5)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "appNameOne"}, 2)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, AppName: "appNameTwo"}, 2)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, AppName: "app1", ComponentName: "comp1"}, 2)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, ComponentName: "comp2"}, 1)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: "msp.two"}, 0)
+}
+
+func TestReindex(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validMsg)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+
+	//configs that were saved before the other indexes were introduced only have an MSP index entry
+	removeIndexEntries(t, stub, indexes[1:]...)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, PeerID: "peer.zero.example.com"}, 0)
+
+	result, err := configManager.Reindex(mspID)
+	if err != nil {
+		t.Fatalf("Cannot reindex configs: %s", err)
+	}
+	if len(result.Reindexed) != numOfRecords || len(result.Removed) != 0 {
+		t.Fatalf("Expected %d reindexed configs but got %+v", numOfRecords, result)
+	}
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID}, numOfRecords)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, PeerID: "peer.zero.example.com"}, 5)
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID, AppName: "appNameTwo"}, 2)
+}
+
+func TestReindexAllMSPs(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	for _, msg := range []string{validMsg, validMsgForMspTwo} {
+		if err := configManager.Save([]byte(msg)); err != nil {
+			t.Fatalf("Cannot save state %s", err)
+		}
+	}
+	removeIndexEntries(t, stub, indexAppName, indexPeerID)
+
+	configs, err := configManager.GetByAppName("appNameTwo")
+	if err != nil {
+		t.Fatalf("GetByAppName failed: %s", err)
+	}
+	if len(configs) != 0 {
+		t.Fatalf("Expected no configs for appNameTwo before reindexing but got %d", len(configs))
+	}
+
+	result, err := configManager.Reindex("")
+	if err != nil {
+		t.Fatalf("Cannot reindex configs: %s", err)
+	}
+	//msp.two has six configs
+	if len(result.Reindexed) != numOfRecords+6 {
+		t.Fatalf("Expected %d reindexed configs but got %d", numOfRecords+6, len(result.Reindexed))
+	}
+	configs, err = configManager.GetByAppName("appNameTwo")
+	if err != nil {
+		t.Fatalf("GetByAppName failed: %s", err)
+	}
+	if len(configs) != 4 {
+		t.Fatalf("Expected 4 configs for appNameTwo but got %d", len(configs))
+	}
+	configs, err = configManager.GetByPeerID("peer.one.one.example.com")
+	if err != nil {
+		t.Fatalf("GetByPeerID failed: %s", err)
+	}
+	if len(configs) != 3 {
+		t.Fatalf("Expected 3 configs for peer.one.one.example.com but got %d", len(configs))
+	}
+}
+
+func TestReindexDeletedConfig(t *testing.T) {
+	stub := mocks.NewMockStub("testChannel")
+	configManager := NewConfigManager(stub)
+	if err := configManager.Save([]byte(validMsg)); err != nil {
+		t.Fatalf("Cannot save state %s", err)
+	}
+
+	//configs that were deleted before deletes removed their index entries only lost their config
+	key, _ := CreateConfigKey(mspID, "peer.zero.example.com", "appNameTwo", "1", "", "")
+	strKey, _ := ConfigKeyToString(key)
+	if err := stub.DelState(strKey); err != nil {
+		t.Fatalf("Cannot delete config: %s", err)
+	}
+
+	result, err := configManager.Reindex("")
+	if err != nil {
+		t.Fatalf("Cannot reindex configs: %s", err)
+	}
+	if len(result.Reindexed) != numOfRecords-1 {
+		t.Fatalf("Expected %d reindexed configs but got %d", numOfRecords-1, len(result.Reindexed))
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != key {
+		t.Fatalf("Expected the index entries of key %s to be removed but got %v", key, result.Removed)
+	}
+	configs, err := configManager.GetByPeerID("peer.zero.example.com")
+	if err != nil {
+		t.Fatalf("GetByPeerID failed: %s", err)
+	}
+	for _, config := range configs {
+		if config.Key == key {
+			t.Fatalf("Deleted config for key %s should not be returned", key)
+		}
+	}
+	assertQueryCount(t, configManager, api.ConfigQuery{MspID: mspID}, numOfRecords-1)
+}
+
+//removeIndexEntries removes all entries of the given indexes as if the configs were saved before the indexes existed
+func removeIndexEntries(t *testing.T, stub shim.ChaincodeStubInterface, indexNames ...string) {
+	var indexKeys []string
+	for _, index := range indexNames {
+		it, err := stub.GetStateByPartialCompositeKey(index, []string{})
+		if err != nil {
+			t.Fatalf("Cannot query index %s: %s", index, err)
+		}
+		for it.HasNext() {
+			kv, err := it.Next()
+			if err != nil {
+				t.Fatalf("Cannot iterate index %s: %s", index, err)
+			}
+			indexKeys = append(indexKeys, kv.Key)
+		}
+		it.Close()
+	}
+	for _, indexKey := range indexKeys {
+		if err := stub.DelState(indexKey); err != nil {
+			t.Fatalf("Cannot delete index entry: %s", err)
+		}
+	}
+}
+
+//assertQueryCount pages through the results of the query and checks the total number of (distinct) configs returned
+func assertQueryCount(t *testing.T, configManager api.ConfigManager, query api.ConfigQuery, expected int) {
+	for _, pageSize := range []int{1, 3, 100} {
+		query.PageSize = pageSize
+		query.Bookmark = ""
+		keys := make(map[api.ConfigKey]bool)
+		for {
+			result, err := configManager.Query(query)
+			if err != nil {
+				t.Fatalf("Query %+v failed: %s", query, err)
+			}
+			if len(result.Configs) > pageSize {
+				t.Fatalf("Expected at most %d configs in page but got %d", pageSize, len(result.Configs))
+			}
+			for _, config := range result.Configs {
+				if !matchesQuery(query, config.Key) {
+					t.Fatalf("Config key %s does not match query %+v", config.Key.String(), query)
+				}
+				if keys[config.Key] {
+					t.Fatalf("Config key %s returned twice", config.Key.String())
+				}
+				keys[config.Key] = true
+			}
+			if result.Bookmark == "" {
+				break
+			}
+			query.Bookmark = result.Bookmark
+		}
+		if len(keys) != expected {
+			t.Fatalf("Expected %d configs for query %+v but got %d", expected, query, len(keys))
+		}
+	}
+}
+
 func TestSearch(t *testing.T) {
 	stub := shim.NewMockStub("testConfigStateRefresh", nil)
 	stub.MockTransactionStart("saveConfiguration")
@@ -0,0 +1,115 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mgmt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+const (
+	//EncryptedConfigPrefix is the prefix of config values that hold an encrypted config
+	EncryptedConfigPrefix = "encrypted:"
+
+	contentKeySize = 32
+)
+
+//KeyUnwrapper decrypts the content key of an encrypted config using the private key with the given key ID
+type KeyUnwrapper func(keyID string, wrappedKey []byte) ([]byte, error)
+
+//IsEncrypted returns true if the given config value holds an encrypted config
+func IsEncrypted(config []byte) bool {
+	return strings.HasPrefix(string(config), EncryptedConfigPrefix)
+}
+
+//EncryptConfig encrypts the given config with a random AES-256-GCM content key which is
+//encrypted (RSA-OAEP with SHA-256) with the public key of the peer. The returned value
+//is the prefixed, base64 encoded EncryptedConfig envelope.
+func EncryptConfig(config []byte, publicKey *rsa.PublicKey, keyID string) (string, errors.Error) {
+	if publicKey == nil {
+		return "", errors.New(errors.MissingRequiredParameterError, "Public key is required to encrypt config")
+	}
+	contentKey := make([]byte, contentKeySize)
+	if _, err := io.ReadFull(rand.Reader, contentKey); err != nil {
+		return "", errors.Wrap(errors.CryptoError, err, "Failed to generate content key")
+	}
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, e := io.ReadFull(rand.Reader, nonce); e != nil {
+		return "", errors.Wrap(errors.CryptoError, e, "Failed to generate nonce")
+	}
+	wrappedKey, e := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, contentKey, nil)
+	if e != nil {
+		return "", errors.Wrap(errors.CryptoError, e, "Failed to encrypt content key")
+	}
+	envelope, e := json.Marshal(&api.EncryptedConfig{
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, config, nil),
+	})
+	if e != nil {
+		return "", errors.WithMessage(errors.SystemError, e, "Failed to marshal encrypted config")
+	}
+	return EncryptedConfigPrefix + base64.StdEncoding.EncodeToString(envelope), nil
+}
+
+//DecryptConfig decrypts the given encrypted config value. The content key is decrypted by the given unwrapper.
+func DecryptConfig(config []byte, unwrap KeyUnwrapper) ([]byte, errors.Error) {
+	if !IsEncrypted(config) {
+		return nil, errors.New(errors.InvalidConfigDataError, "Config is not encrypted")
+	}
+	envelopeBytes, e := base64.StdEncoding.DecodeString(string(config[len(EncryptedConfigPrefix):]))
+	if e != nil {
+		return nil, errors.Wrap(errors.InvalidConfigDataError, e, "Failed to decode encrypted config")
+	}
+	envelope := &api.EncryptedConfig{}
+	if e := json.Unmarshal(envelopeBytes, envelope); e != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, e, "Failed to unmarshal encrypted config")
+	}
+	contentKey, e := unwrap(envelope.KeyID, envelope.WrappedKey)
+	if e != nil {
+		return nil, errors.Wrapf(errors.CryptoError, e, "Failed to decrypt content key with key [%s]", envelope.KeyID)
+	}
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != gcm.NonceSize() {
+		return nil, errors.Errorf(errors.InvalidConfigDataError, "Invalid nonce size [%d]", len(envelope.Nonce))
+	}
+	plaintext, e := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	if e != nil {
+		return nil, errors.Wrap(errors.CryptoError, e, "Failed to decrypt config")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, errors.Error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(errors.CryptoError, err, "Failed to create cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(errors.CryptoError, err, "Failed to create GCM")
+	}
+	return gcm, nil
+}
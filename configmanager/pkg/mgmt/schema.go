@@ -0,0 +1,208 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mgmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/xeipuuv/gojsonschema"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//schemaValidator validates app configs against the JSON schemas stored under the general MSP
+type schemaValidator struct {
+	cmngr   *configManagerImpl
+	schemas map[api.ConfigKey]*gojsonschema.Schema
+}
+
+func newSchemaValidator(cmngr *configManagerImpl) *schemaValidator {
+	return &schemaValidator{cmngr: cmngr, schemas: make(map[api.ConfigKey]*gojsonschema.Schema)}
+}
+
+//validate checks the syntax of every config in the message and validates it against its app's schema (if any)
+func (v *schemaValidator) validate(configMsg *api.ConfigMessage) errors.Error {
+	for _, peer := range configMsg.Peers {
+		for _, app := range peer.App {
+			if app.Templated {
+				//templates are validated against the schema when they are resolved by the peer (see ValidateConfig)
+				continue
+			}
+			if err := v.validateConfig(app.AppName, app.Version, app.Config, app.ConfigType, app.Encrypted); err != nil {
+				return err
+			}
+		}
+	}
+	for _, app := range configMsg.Apps {
+		if len(app.Components) == 0 {
+			if app.Templated {
+				continue
+			}
+			if err := v.validateConfig(app.AppName, app.Version, app.Config, app.ConfigType, app.Encrypted); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, comp := range app.Components {
+			if configMsg.MspID == cfgsnapapi.GeneralMspID && app.AppName == cfgsnapapi.SchemaAppName {
+				if _, err := loadSchema(comp.Name, comp.Config); err != nil {
+					return err
+				}
+				continue
+			}
+			configType := comp.ConfigType
+			if configType == "" {
+				configType = app.ConfigType
+			}
+			if err := v.validateConfig(app.AppName+"/"+comp.Name, app.Version, comp.Config, configType, comp.Encrypted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//validateConfig validates a single config. The syntax is checked if a config type is given
+//and the config is validated against the schema registered for the app name and version.
+//Encrypted configs cannot be validated so only their format is checked.
+func (v *schemaValidator) validateConfig(appName, appVersion, config string, configType api.ConfigType, encrypted bool) errors.Error {
+	if encrypted && !IsEncrypted([]byte(config)) {
+		return errors.Errorf(errors.InvalidAppConfig, "Config for app [%s] version [%s] is flagged as encrypted but is not an encrypted config", appName, appVersion)
+	}
+	if IsEncrypted([]byte(config)) {
+		return nil
+	}
+	if configType != "" {
+		if _, err := parseConfigData(config, configType); err != nil {
+			return errors.Errorf(errors.InvalidAppConfig, "Config for app [%s] version [%s] is not valid %s: %s", appName, appVersion, configType, err)
+		}
+	}
+
+	schema, err := v.getSchema(appName, appVersion)
+	if err != nil {
+		return err
+	}
+	return ValidateConfig(schema, appName, appVersion, config, configType)
+}
+
+//ValidateConfig validates the given config of an app against the given schema (which may be nil, in which case the
+//config is valid). The config is parsed as YAML (a superset of JSON) if no config type is given.
+func ValidateConfig(schema *gojsonschema.Schema, appName, appVersion, config string, configType api.ConfigType) errors.Error {
+	if schema == nil {
+		return nil
+	}
+	if configType == "" {
+		configType = api.YAML
+	}
+	data, e := parseConfigData(config, configType)
+	if e != nil {
+		return errors.Errorf(errors.InvalidAppConfig, "Config for app [%s] version [%s] is not valid %s: %s", appName, appVersion, configType, e)
+	}
+	result, e := schema.Validate(gojsonschema.NewGoLoader(data))
+	if e != nil {
+		return errors.Wrapf(errors.InvalidAppConfig, e, "Failed to validate config for app [%s] version [%s]", appName, appVersion)
+	}
+	if !result.Valid() {
+		var fieldErrs []string
+		for _, desc := range result.Errors() {
+			fieldErrs = append(fieldErrs, fmt.Sprintf("%s: %s", desc.Field(), desc.Description()))
+		}
+		return errors.Errorf(errors.InvalidAppConfig, "Config for app [%s] version [%s] does not match its schema: %s", appName, appVersion, strings.Join(fieldErrs, ", "))
+	}
+	return nil
+}
+
+//getSchema returns the schema for the given app name and version or nil if no schema was registered
+func (v *schemaValidator) getSchema(appName, appVersion string) (*gojsonschema.Schema, errors.Error) {
+	key, err := SchemaKey(appName, appVersion)
+	if err != nil {
+		return nil, err
+	}
+	if schema, ok := v.schemas[key]; ok {
+		return schema, nil
+	}
+
+	value, err := v.cmngr.getConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := ParseSchema(appName, value)
+	if err != nil {
+		return nil, err
+	}
+	v.schemas[key] = schema
+	return schema, nil
+}
+
+//SchemaKey returns the key of the schema for the given app name and version
+func SchemaKey(appName, appVersion string) (api.ConfigKey, errors.Error) {
+	return CreateConfigKey(cfgsnapapi.GeneralMspID, "", cfgsnapapi.SchemaAppName, cfgsnapapi.SchemaAppVersion, appName, appVersion)
+}
+
+//ParseSchema parses the given stored schema component of an app. Nil is returned if the value is empty.
+func ParseSchema(appName string, value []byte) (*gojsonschema.Schema, errors.Error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	compConfig := api.ComponentConfig{}
+	if e := json.Unmarshal(value, &compConfig); e != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, e, "Failed to unmarshal config schema")
+	}
+	return loadSchema(appName, compConfig.Config)
+}
+
+//loadSchema parses the given JSON schema
+func loadSchema(appName, jsonSchema string) (*gojsonschema.Schema, errors.Error) {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(jsonSchema))
+	if err != nil {
+		return nil, errors.Wrapf(errors.InvalidAppConfig, err, "Invalid JSON schema for app [%s]", appName)
+	}
+	return schema, nil
+}
+
+//parseConfigData unmarshals the given config according to its type
+func parseConfigData(config string, configType api.ConfigType) (interface{}, error) {
+	var data interface{}
+	switch api.ConfigType(strings.ToUpper(string(configType))) {
+	case api.JSON:
+		if err := json.Unmarshal([]byte(config), &data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	case api.YAML:
+		if err := yaml.Unmarshal([]byte(config), &data); err != nil {
+			return nil, err
+		}
+		return convertYAML(data), nil
+	default:
+		return nil, fmt.Errorf("unsupported config type [%s]", configType)
+	}
+}
+
+//convertYAML converts the maps produced by the YAML parser into maps with string keys
+//so that the data can be validated as JSON
+func convertYAML(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			converted[fmt.Sprintf("%v", k)] = convertYAML(v)
+		}
+		return converted
+	case []interface{}:
+		for i, v := range value {
+			value[i] = convertYAML(v)
+		}
+		return value
+	default:
+		return value
+	}
+}
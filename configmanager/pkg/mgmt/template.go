@@ -0,0 +1,147 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package mgmt
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+const (
+	//TemplatePrefix is the prefix of stored config values that hold a templated config
+	TemplatePrefix = "template:"
+
+	//PeerIDVariable is resolved to the ID of the peer (peer.id in core.yaml)
+	PeerIDVariable = "peer.id"
+	//PeerMspIDVariable is resolved to the MSP ID of the peer (peer.localMspId in core.yaml)
+	PeerMspIDVariable = "peer.localMspId"
+	//PeerAddressVariable is resolved to the address of the peer (peer.address in core.yaml)
+	PeerAddressVariable = "peer.address"
+)
+
+//PeerVariables are the variables that are resolved from the peer's config
+var PeerVariables = []string{PeerIDVariable, PeerMspIDVariable, PeerAddressVariable}
+
+//placeholderRegex matches placeholders of the form ${name}
+var placeholderRegex = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+//IsTemplate returns true if the given config value holds a templated config
+func IsTemplate(config []byte) bool {
+	return strings.HasPrefix(string(config), TemplatePrefix)
+}
+
+//TemplateVariables returns the names of the variables that are referenced by the given template
+func TemplateVariables(template string) []string {
+	var names []string
+	for _, match := range placeholderRegex.FindAllStringSubmatch(template, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+//ResolveTemplate substitutes the placeholders of the given templated config value with the given variables.
+//An error is returned if any of the variables is not defined.
+func ResolveTemplate(config []byte, variables map[string]string) ([]byte, errors.Error) {
+	template := strings.TrimPrefix(string(config), TemplatePrefix)
+	if undefined := undefinedVariables(template, variables); len(undefined) > 0 {
+		return nil, errors.Errorf(errors.ValidationError, "Config references undefined variables: %s", strings.Join(undefined, ", "))
+	}
+	resolved := placeholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return variables[placeholderRegex.FindStringSubmatch(placeholder)[1]]
+	})
+	return []byte(resolved), nil
+}
+
+//ParseVariables unmarshals the variables config of an MSP, which is a JSON object of variable names to values
+func ParseVariables(config []byte) (map[string]string, errors.Error) {
+	variables := make(map[string]string)
+	if len(config) == 0 {
+		return variables, nil
+	}
+	if err := json.Unmarshal(config, &variables); err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Failed to unmarshal config variables")
+	}
+	return variables, nil
+}
+
+//undefinedVariables returns the (sorted, distinct) variables of the template that are not defined
+func undefinedVariables(template string, variables map[string]string) []string {
+	undefined := make(map[string]bool)
+	for _, name := range TemplateVariables(template) {
+		if _, ok := variables[name]; !ok {
+			undefined[name] = true
+		}
+	}
+	var names []string
+	for name := range undefined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//validateTemplates checks that every variable referenced by the templated configs of the message
+//is either a peer variable or is defined in the variables config of the MSP
+func (cmngr *configManagerImpl) validateTemplates(configMsg *api.ConfigMessage) errors.Error {
+	var templates []api.AppConfig
+	for _, peer := range configMsg.Peers {
+		for _, app := range peer.App {
+			if app.Templated {
+				templates = append(templates, app)
+			}
+		}
+	}
+	for _, app := range configMsg.Apps {
+		if app.Templated && len(app.Components) == 0 {
+			templates = append(templates, app)
+		}
+	}
+	if len(templates) == 0 {
+		return nil
+	}
+
+	variables, err := cmngr.getVariables(configMsg)
+	if err != nil {
+		return err
+	}
+	for _, name := range PeerVariables {
+		variables[name] = ""
+	}
+	for _, app := range templates {
+		if app.Encrypted {
+			return errors.Errorf(errors.ValidationError, "Config for app [%s] version [%s] cannot be both templated and encrypted", app.AppName, app.Version)
+		}
+		if undefined := undefinedVariables(app.Config, variables); len(undefined) > 0 {
+			return errors.Errorf(errors.ValidationError, "Config for app [%s] version [%s] references undefined variables: %s", app.AppName, app.Version, strings.Join(undefined, ", "))
+		}
+	}
+	return nil
+}
+
+//getVariables returns the variables of the MSP. Variables that are saved in the same message take precedence.
+func (cmngr *configManagerImpl) getVariables(configMsg *api.ConfigMessage) (map[string]string, errors.Error) {
+	for _, app := range configMsg.Apps {
+		if app.AppName == cfgsnapapi.VariablesAppName && app.Version == cfgsnapapi.VariablesAppVersion {
+			return ParseVariables([]byte(app.Config))
+		}
+	}
+	key, err := CreateConfigKey(configMsg.MspID, "", cfgsnapapi.VariablesAppName, cfgsnapapi.VariablesAppVersion, "", "")
+	if err != nil {
+		return nil, err
+	}
+	config, err := cmngr.getConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVariables(config)
+}
@@ -7,6 +7,8 @@ SPDX-License-Identifier: Apache-2.0
 package mgmt
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"strings"
 
 	"github.com/securekey/fabric-snaps/util/errors"
@@ -69,3 +71,9 @@ func StringToConfigKey(key string) (api.ConfigKey, errors.Error) {
 	ck.ComponentVersion = keyParts[5]
 	return ck, nil
 }
+
+//GenerateHash returns the base64 encoded SHA-256 hash of the given config bytes
+func GenerateHash(bytes []byte) string {
+	digest := sha256.Sum256(bytes)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
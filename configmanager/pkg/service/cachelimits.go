@@ -0,0 +1,246 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	metricsutil "github.com/securekey/fabric-snaps/metrics/pkg/util"
+)
+
+//cacheLimits are the limits of the cached configs of a channel
+type cacheLimits struct {
+	//maxBytes is the maximum number of bytes of cached configs (0 for no limit)
+	maxBytes int64
+	//ttl is the time after which a config that hasn't been read is evicted (0 for no limit)
+	ttl time.Duration
+}
+
+//evictionCandidate is a cached config that may be evicted
+type evictionCandidate struct {
+	cacheID  string
+	keyStr   string
+	size     int64
+	lastRead time.Time
+}
+
+//SetCacheLimits sets the maximum number of bytes of cached configs of the channel and the time after which
+//configs that haven't been read are evicted. Zero means no limit. The least recently read configs are
+//evicted first and are loaded back from the ledger when they're read.
+func (csi *ConfigServiceImpl) SetCacheLimits(channelID string, maxBytes int64, ttl time.Duration) {
+	csi.mtx.Lock()
+	csi.cacheLimits[channelID] = cacheLimits{maxBytes: maxBytes, ttl: ttl}
+	csi.mtx.Unlock()
+
+	csi.evict(channelID)
+}
+
+//initMetrics replaces the disabled metrics that the service is created with by metrics from the metrics provider,
+//which is initialized after the service is created
+func (csi *ConfigServiceImpl) initMetrics() {
+	csi.metricsOnce.Do(func() {
+		metrics := NewMetrics(metricsutil.GetMetricsInstance())
+		csi.mtx.Lock()
+		csi.metrics = metrics
+		csi.mtx.Unlock()
+	})
+}
+
+func (csi *ConfigServiceImpl) getMetrics() *Metrics {
+	csi.mtx.RLock()
+	defer csi.mtx.RUnlock()
+	return csi.metrics
+}
+
+//touch records that the config with the given key was read from the given cache
+func (csi *ConfigServiceImpl) touch(cacheID, keyStr string) {
+	csi.usageMtx.Lock()
+	defer csi.usageMtx.Unlock()
+	lastRead, ok := csi.lastRead[cacheID]
+	if !ok {
+		lastRead = make(map[string]time.Time)
+		csi.lastRead[cacheID] = lastRead
+	}
+	lastRead[keyStr] = time.Now()
+}
+
+//resetLastRead sets the last read time of every config in the given cache to now so that newly loaded
+//configs aren't evicted before they've had a chance to be read
+func (csi *ConfigServiceImpl) resetLastRead(cacheID string, cache cache) {
+	now := time.Now()
+	lastRead := make(map[string]time.Time, len(cache))
+	for keyStr := range cache {
+		lastRead[keyStr] = now
+	}
+	csi.usageMtx.Lock()
+	csi.lastRead[cacheID] = lastRead
+	csi.usageMtx.Unlock()
+}
+
+//isEvictable returns true if the config with the given key may be evicted. The aggregated versions of
+//a component and the variables of templated configs are never evicted since they cannot be loaded back
+//from a single ledger key.
+func isEvictable(keyStr string) bool {
+	key, err := mgmt.StringToConfigKey(keyStr)
+	if err != nil {
+		return false
+	}
+	if key.ComponentName != "" && key.ComponentVersion == "" {
+		return false
+	}
+	return key.AppName != cfgsnapapi.VariablesAppName
+}
+
+//evict evicts the least recently read configs of the channel until the cache is within its size limit,
+//along with any configs that haven't been read within the TTL. The cached configs are only scanned if the
+//running size of the channel's cache exceeds the limit or if a TTL is set.
+func (csi *ConfigServiceImpl) evict(channelID string) {
+	var numEvicted int
+	csi.mtx.Lock()
+	limits := csi.cacheLimits[channelID]
+	if limits.ttl > 0 || (limits.maxBytes > 0 && csi.cacheSizes[channelID] > limits.maxBytes) {
+		numEvicted = csi.evictCandidates(channelID, limits)
+	}
+	var totalSize int64
+	for _, s := range csi.cacheSizes {
+		totalSize += s
+	}
+	csi.mtx.Unlock()
+
+	metrics := csi.getMetrics()
+	if numEvicted > 0 {
+		logger.Debugf("Evicted %d items from cache for channel [%s]\n", numEvicted, channelID)
+		metrics.CacheEvictions.Add(float64(numEvicted))
+	}
+	metrics.CacheSize.Set(float64(totalSize))
+}
+
+//evictCandidates evicts the configs of the channel that exceed the limits and returns the number of evicted
+//configs. The size of the channel's cache is recomputed from the remaining configs. The caller must hold the
+//write lock.
+func (csi *ConfigServiceImpl) evictCandidates(channelID string, limits cacheLimits) int {
+	var size int64
+	var candidates []*evictionCandidate
+	csi.usageMtx.Lock()
+	for cacheID, cache := range csi.cacheMap {
+		if !strings.HasPrefix(cacheID, channelID+"_") {
+			continue
+		}
+		for keyStr, value := range cache {
+			size += int64(len(value))
+			if isEvictable(keyStr) {
+				candidates = append(candidates, &evictionCandidate{cacheID: cacheID, keyStr: keyStr, size: int64(len(value)), lastRead: csi.lastRead[cacheID][keyStr]})
+			}
+		}
+	}
+	csi.usageMtx.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastRead.Before(candidates[j].lastRead)
+	})
+
+	now := time.Now()
+	evicted := make(map[string][]string)
+	var numEvicted int
+	for _, c := range candidates {
+		expired := limits.ttl > 0 && now.Sub(c.lastRead) > limits.ttl
+		if !expired && (limits.maxBytes <= 0 || size <= limits.maxBytes) {
+			//the remaining candidates were read more recently
+			break
+		}
+		evicted[c.cacheID] = append(evicted[c.cacheID], c.keyStr)
+		size -= c.size
+		numEvicted++
+	}
+
+	for cacheID, keyStrs := range evicted {
+		currentCache := csi.cacheMap[cacheID]
+		cache := make(map[string][]byte, len(currentCache))
+		for key, value := range currentCache {
+			cache[key] = value
+		}
+		for _, keyStr := range keyStrs {
+			logger.Debugf("Evicting item for key [%s] from cache [%s]\n", keyStr, cacheID)
+			delete(cache, keyStr)
+		}
+		csi.cacheMap[cacheID] = cache
+	}
+	csi.cacheSizes[channelID] = size
+	return numEvicted
+}
+
+//setCache replaces the cache with the given ID and updates the running size of the cached configs of the channel.
+//The caller must hold the write lock.
+func (csi *ConfigServiceImpl) setCache(channelID, cacheID string, c cache) {
+	csi.cacheSizes[channelID] += c.size() - csi.cacheMap[cacheID].size()
+	csi.cacheMap[cacheID] = c
+}
+
+//size returns the number of bytes of the cached configs
+func (c cache) size() int64 {
+	var size int64
+	for _, value := range c {
+		size += int64(len(value))
+	}
+	return size
+}
+
+//reloadEvicted adds a config that was loaded from the ledger back to the cache if it was evicted from the cache.
+//The config is only added if the ledger value is the one that the cache is tracking.
+func (csi *ConfigServiceImpl) reloadEvicted(channelID string, key api.ConfigKey, keyStr string, ledgerValue, value []byte) {
+	if len(value) == 0 {
+		return
+	}
+	cacheID := channelID + "_" + key.MspID
+
+	csi.mtx.Lock()
+	currentCache := csi.cacheMap[cacheID]
+	hash, ok := csi.ledgerHashes[cacheID][keyStr]
+	if currentCache == nil || !ok || hash != mgmt.GenerateHash(ledgerValue) {
+		csi.mtx.Unlock()
+		return
+	}
+	if _, cached := currentCache[keyStr]; cached {
+		csi.mtx.Unlock()
+		return
+	}
+	cache := make(map[string][]byte, len(currentCache)+1)
+	for k, v := range currentCache {
+		cache[k] = v
+	}
+	cache[keyStr] = value
+	csi.setCache(channelID, cacheID, cache)
+	csi.mtx.Unlock()
+
+	logger.Debugf("Reloaded evicted item for key [%s] and channel [%s] into cache\n", keyStr, channelID)
+	csi.touch(cacheID, keyStr)
+	csi.evict(channelID)
+}
+
+//pruneHashes removes the dirty tracking of the configs that were deleted so that the hashes don't grow without bound
+func (csi *ConfigServiceImpl) pruneHashes(changes []api.ConfigChange) {
+	csi.mtx.Lock()
+	defer csi.mtx.Unlock()
+	for _, change := range changes {
+		if change.NewHash != "" {
+			continue
+		}
+		keyStr, err := mgmt.ConfigKeyToString(change.Key)
+		if err != nil {
+			continue
+		}
+		delete(csi.configHashes, keyStr)
+		for _, hashes := range csi.consumerHashes {
+			delete(hashes, keyStr)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package service
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+//GetCacheStatus returns the status of the cache of each MSP on the given channel, sorted by MSP ID
+func (csi *ConfigServiceImpl) GetCacheStatus(channelID string) []*api.CacheStatus {
+	csi.mtx.RLock()
+	defer csi.mtx.RUnlock()
+
+	var statuses []*api.CacheStatus
+	for cacheID := range csi.cacheMap {
+		if !strings.HasPrefix(cacheID, channelID+"_") {
+			continue
+		}
+		status := &api.CacheStatus{
+			ChannelID: channelID,
+			MspID:     strings.TrimPrefix(cacheID, channelID+"_"),
+			Hashes:    make(map[string]string),
+		}
+		if info, ok := csi.cacheInfo[cacheID]; ok {
+			status.CacheInfo = *info
+		}
+		for keyStr, hash := range csi.ledgerHashes[cacheID] {
+			status.Hashes[keyStr] = hash
+		}
+		status.KeyCount = len(status.Hashes)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].MspID < statuses[j].MspID
+	})
+	return statuses
+}
+
+//GetCacheDiff compares the cache of each MSP on the stub's channel with the ledger and returns the keys
+//whose cached hash differs from the hash of their ledger value, sorted by MSP ID and key
+func (csi *ConfigServiceImpl) GetCacheDiff(stub shim.ChaincodeStubInterface) ([]*api.CacheDiff, errors.Error) {
+	if stub == nil {
+		return nil, errors.New(errors.SystemError, "Stub is nil")
+	}
+	channelID := stub.GetChannelID()
+
+	var diffs []*api.CacheDiff
+	configManager := mgmt.NewConfigManager(stub)
+	for _, status := range csi.GetCacheStatus(channelID) {
+		configs, err := configManager.Get(api.ConfigKey{MspID: status.MspID})
+		if err != nil {
+			return nil, err
+		}
+		ledgerHashes := make(map[string]string, len(configs))
+		for _, config := range configs {
+			keyStr, err := mgmt.ConfigKeyToString(config.Key)
+			if err != nil {
+				return nil, err
+			}
+			ledgerHashes[keyStr] = mgmt.GenerateHash(config.Value)
+		}
+
+		var mspDiffs []*api.CacheDiff
+		for keyStr, ledgerHash := range ledgerHashes {
+			if cachedHash := status.Hashes[keyStr]; cachedHash != ledgerHash {
+				mspDiffs = append(mspDiffs, &api.CacheDiff{ChannelID: channelID, MspID: status.MspID, Key: keyStr, CachedHash: cachedHash, LedgerHash: ledgerHash})
+			}
+		}
+		for keyStr, cachedHash := range status.Hashes {
+			if _, ok := ledgerHashes[keyStr]; !ok {
+				mspDiffs = append(mspDiffs, &api.CacheDiff{ChannelID: channelID, MspID: status.MspID, Key: keyStr, CachedHash: cachedHash})
+			}
+		}
+		sort.Slice(mspDiffs, func(i, j int) bool {
+			return mspDiffs[i].Key < mspDiffs[j].Key
+		})
+		diffs = append(diffs, mspDiffs...)
+	}
+	return diffs, nil
+}
@@ -8,30 +8,48 @@ package service
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 
 	"github.com/spf13/viper"
 
 	"sync"
 	"time"
 
-	"math/rand"
+	"sort"
+	"strings"
 
 	"encoding/json"
 
-	"crypto/sha256"
-	"encoding/base64"
-
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/logging"
+	"github.com/hyperledger/fabric/common/metrics/disabled"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"github.com/hyperledger/fabric/core/peer"
 	"github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
 	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util/configcache"
 	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	//swProvider is the ID of the SW BCCSP provider
+	swProvider = "SW"
+
+	//mergedConsumerPrefix is the prefix of the consumer IDs under which GetMerged tracks the dirty flags of the layers
+	//of a config key, so that GetMerged doesn't affect the dirty flags of Get
+	mergedConsumerPrefix = "merged:"
 )
 
 var logger = logging.NewLogger("configsnap")
+var peerConfigCache = configcache.New("core", "core", "/etc/hyperledger/fabric")
 
 type cache map[string][]byte
 
@@ -40,6 +58,37 @@ type ConfigServiceImpl struct {
 	mtx          sync.RWMutex
 	cacheMap     map[string]cache
 	configHashes map[string]string
+	//consumerHashes contains the config hashes of each consumer of GetForConsumer
+	consumerHashes map[string]map[string]string
+	//ledgerHashes contains the hashes of the (encrypted) configs as stored in the ledger for each cache
+	ledgerHashes map[string]map[string]string
+	//encryptedKeys contains the keys of the configs that are encrypted in the ledger for each cache. Their
+	//decrypted configs stay inside the peer, so they aren't returned by GetFromCache.
+	encryptedKeys map[string]map[string]bool
+	//templates contains the (decrypted) templated configs of each cache by key. The cache contains their resolved
+	//configs, which are resolved again from the templates when the variables of the MSP or the schemas of their apps change.
+	templates    map[string]map[string][]byte
+	keyUnwrapper mgmt.KeyUnwrapper
+	peerConfig   func() (*viper.Viper, error)
+	//subscriptions are notified of changes to the cached configs
+	subscriptions *subscriptions
+	//cacheInfo contains the block height etc. of each cache
+	cacheInfo      map[string]*api.CacheInfo
+	snapshotSigner func() (snapshotSigner, error)
+	blockHeight    func(channelID string) (uint64, error)
+	stateReader    func(channelID string) (stateReader, errors.Error)
+	//cacheLimits contains the size limit and TTL of the cached configs of each channel
+	cacheLimits map[string]cacheLimits
+	//cacheSizes contains the number of bytes of cached configs of each channel
+	cacheSizes map[string]int64
+	//usageMtx guards lastRead, which contains the time that each cached config was last read
+	usageMtx    sync.Mutex
+	lastRead    map[string]map[string]time.Time
+	metrics     *Metrics
+	metricsOnce sync.Once
+	//schemaMtx guards schemas, which contains the parsed schemas of templated configs by the hash of the stored schema
+	schemaMtx sync.Mutex
+	schemas   map[string]*gojsonschema.Schema
 }
 
 var instance = newConfigService()
@@ -53,22 +102,43 @@ func newConfigService() *ConfigServiceImpl {
 	service := &ConfigServiceImpl{}
 	service.cacheMap = make(map[string]cache)
 	service.configHashes = make(map[string]string)
+	service.consumerHashes = make(map[string]map[string]string)
+	service.ledgerHashes = make(map[string]map[string]string)
+	service.encryptedKeys = make(map[string]map[string]bool)
+	service.templates = make(map[string]map[string][]byte)
+	service.subscriptions = newSubscriptions()
+	service.cacheInfo = make(map[string]*api.CacheInfo)
+	service.cacheLimits = make(map[string]cacheLimits)
+	service.cacheSizes = make(map[string]int64)
+	service.lastRead = make(map[string]map[string]time.Time)
+	service.schemas = make(map[string]*gojsonschema.Schema)
+	service.metrics = NewMetrics(&disabled.Provider{})
+	service.snapshotSigner = getSnapshotSigner
+	service.blockHeight = getBlockHeight
+	service.stateReader = newQueryExecutor
+	service.keyUnwrapper = service.unwrapKey
+	service.peerConfig = getPeerConfig
 	return service
 }
 
 //Initialize will be called from config snap
 func Initialize(stub shim.ChaincodeStubInterface, mspID string) *ConfigServiceImpl {
-	err := instance.Refresh(stub, mspID)
-	if err != nil {
-		logger.Warnf("Error while Refreshing the instance for mspID %s ; err= %s", mspID, err)
-	}
+	instance.initMetrics()
+	instance.initialize(stub, mspID)
+	return instance
+}
 
-	err = instance.Refresh(stub, cfgsnapapi.GeneralMspID)
-	if err != nil {
-		logger.Warnf("Error while Refreshing the instance for mspID %s ; err= %s", cfgsnapapi.GeneralMspID, err)
+//initialize loads the caches of the given MSP and the general MSP. A cache is loaded from its on-disk
+//snapshot if the snapshot is more recent than the ledger, otherwise it is refreshed from the ledger.
+func (csi *ConfigServiceImpl) initialize(stub shim.ChaincodeStubInterface, mspID string) {
+	for _, id := range []string{mspID, cfgsnapapi.GeneralMspID} {
+		if stub != nil && csi.loadSnapshot(stub.GetChannelID(), id) {
+			continue
+		}
+		if err := csi.Refresh(stub, id); err != nil {
+			logger.Warnf("Error while Refreshing the instance for mspID %s ; err= %s", id, err)
+		}
 	}
-
-	return instance
 }
 
 //Get items from cache
@@ -76,6 +146,38 @@ func (csi *ConfigServiceImpl) Get(channelID string, configKey api.ConfigKey) ([]
 	if csi == nil {
 		return nil, false, errors.New(errors.SystemError, "ConfigServiceImpl was not initialized")
 	}
+	val, keyStr, err := csi.get(channelID, configKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, csi.isConfigDirty(keyStr, val), nil
+}
+
+//GetForConsumer gets items from cache with the dirty flag tracked for the given consumer
+func (csi *ConfigServiceImpl) GetForConsumer(consumerID string, channelID string, configKey api.ConfigKey) ([]byte, bool, errors.Error) {
+	if csi == nil {
+		return nil, false, errors.New(errors.SystemError, "ConfigServiceImpl was not initialized")
+	}
+	if consumerID == "" {
+		return nil, false, errors.New(errors.MissingRequiredParameterError, "Consumer ID is required")
+	}
+	val, keyStr, err := csi.get(channelID, configKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, csi.isConfigDirtyForConsumer(consumerID, keyStr, val), nil
+}
+
+//ResetConsumer clears the dirty tracking of the given consumer
+func (csi *ConfigServiceImpl) ResetConsumer(consumerID string) {
+	csi.mtx.Lock()
+	defer csi.mtx.Unlock()
+	delete(csi.consumerHashes, consumerID)
+}
+
+//get returns the config for the given key from the cache, or from the ledger if it's not cached,
+//along with the string form of the key
+func (csi *ConfigServiceImpl) get(channelID string, configKey api.ConfigKey) ([]byte, string, errors.Error) {
 	if configKey.AppVersion == "" {
 		configKey.AppVersion = api.VERSION
 	}
@@ -83,24 +185,27 @@ func (csi *ConfigServiceImpl) Get(channelID string, configKey api.ConfigKey) ([]
 	channelCache := csi.getCache(channelID, configKey.MspID)
 	if channelCache == nil {
 		logger.Debugf("Config cache is not initialized for channel [%s]. Getting config from ledger.\n", channelID)
-		return csi.GetConfigFromLedger(channelID, configKey)
+		return csi.getConfigFromLedger(channelID, configKey)
 	}
 
 	keyStr, err := mgmt.ConfigKeyToString(configKey)
 	if err != nil {
-		return nil, false, err
+		return nil, "", err
 	}
 
 	val := channelCache[keyStr]
 	if len(val) == 0 {
 		logger.Debugf("Config cache does not contain config for key [%s] on channel [%s]. Getting config from ledger.\n", keyStr, channelID)
-		//not in cache get from ledger
-		return csi.GetConfigFromLedger(channelID, configKey)
+		csi.getMetrics().CacheMisses.Add(1)
+		//not in cache (or evicted) get from ledger
+		return csi.getConfigFromLedger(channelID, configKey)
 	}
-	return val, csi.isConfigDirty(keyStr, val), nil
+	csi.getMetrics().CacheHits.Add(1)
+	csi.touch(channelID+"_"+configKey.MspID, keyStr)
+	return val, keyStr, nil
 }
 
-//GetFromCache get items from cache
+//GetFromCache get items from cache. Encrypted configs are rejected since their decrypted configs must not leave the peer.
 func (csi *ConfigServiceImpl) GetFromCache(channelID string, configKey api.ConfigKey) ([]byte, errors.Error) {
 	if csi == nil {
 		return nil, errors.New(errors.SystemError, "ConfigServiceImpl was not initialized")
@@ -120,10 +225,21 @@ func (csi *ConfigServiceImpl) GetFromCache(channelID string, configKey api.Confi
 	}
 
 	val := channelCache[keyStr]
+	if csi.isEncrypted(channelID, configKey, keyStr, val) {
+		return nil, errors.Errorf(errors.ValidationError, "Config for key [%s] on channel [%s] is encrypted", keyStr, channelID)
+	}
 	if len(val) == 0 {
+		csi.getMetrics().CacheMisses.Add(1)
+		if csi.isEvicted(channelID, configKey.MspID, keyStr) {
+			logger.Debugf("Config for key [%s] on channel [%s] was evicted from the cache. Getting config from ledger.\n", keyStr, channelID)
+			val, _, err = csi.getConfigFromLedger(channelID, configKey)
+			return val, err
+		}
 		return nil, errors.Errorf(errors.SystemError, "Config cache does not contain config for key [%s] on channel [%s]", keyStr, channelID)
 	}
-	return channelCache[keyStr], nil
+	csi.getMetrics().CacheHits.Add(1)
+	csi.touch(channelID+"_"+configKey.MspID, keyStr)
+	return val, nil
 }
 
 //GetViper configuration as Viper
@@ -146,6 +262,86 @@ func (csi *ConfigServiceImpl) GetViper(channelID string, configKey api.ConfigKey
 	return v, dirty, err
 }
 
+//GetMerged merges the general, MSP and peer layers of the config into a single Viper
+func (csi *ConfigServiceImpl) GetMerged(channelID string, configKey api.ConfigKey, configType api.ConfigType) (*api.MergedConfig, bool, errors.Error) {
+	if configKey.AppVersion == "" {
+		configKey.AppVersion = api.VERSION
+	}
+	if configKey.ComponentName != "" && configKey.ComponentVersion == "" {
+		return nil, false, errors.Errorf(errors.InvalidConfigKey, "ComponentVersion is required to merge the config of component [%s]", configKey.ComponentName)
+	}
+
+	consumerID := mergedConsumerPrefix + channelID + "_" + configKey.String()
+	merged := &api.MergedConfig{Viper: viper.New(), Sources: make(map[string]api.ConfigKey)}
+	merged.SetConfigType(string(configType))
+	found := false
+	anyDirty := false
+	for _, layerKey := range getLayerKeys(configKey) {
+		configData, dirty, err := csi.getLayer(consumerID, channelID, layerKey)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(configData) == 0 {
+			logger.Debugf("No config found for layer [%s] on channel [%s]\n", layerKey.String(), channelID)
+			continue
+		}
+		found = true
+		anyDirty = anyDirty || dirty
+
+		layer := viper.New()
+		layer.SetConfigType(string(configType))
+		if e := layer.ReadConfig(bytes.NewBuffer(configData)); e != nil {
+			return nil, false, errors.WithMessage(errors.InitializeConfigError, e, fmt.Sprintf("Failed to read config for layer [%s]", layerKey.String()))
+		}
+		for _, key := range layer.AllKeys() {
+			merged.Sources[key] = layerKey
+		}
+		if e := merged.MergeConfig(bytes.NewBuffer(configData)); e != nil {
+			return nil, false, errors.WithMessage(errors.InitializeConfigError, e, fmt.Sprintf("Failed to merge config for layer [%s]", layerKey.String()))
+		}
+	}
+	if !found {
+		// No config found for the key. Return nil instead of an error so that the caller can differentiate between the two cases
+		return nil, false, nil
+	}
+
+	//remove the sources of keys that were replaced by a different type of value in a later layer
+	sources := make(map[string]api.ConfigKey)
+	for _, key := range merged.AllKeys() {
+		sources[key] = merged.Sources[key]
+	}
+	merged.Sources = sources
+	return merged, anyDirty, nil
+}
+
+//getLayerKeys returns the config keys of the general, MSP and peer layers (in that order) of the given key
+func getLayerKeys(configKey api.ConfigKey) []api.ConfigKey {
+	generalKey := configKey
+	generalKey.MspID = cfgsnapapi.GeneralMspID
+	generalKey.PeerID = ""
+	mspKey := configKey
+	mspKey.PeerID = ""
+	layerKeys := []api.ConfigKey{generalKey, mspKey}
+	if configKey.PeerID != "" && configKey.ComponentName == "" {
+		layerKeys = append(layerKeys, configKey)
+	}
+	return layerKeys
+}
+
+//getLayer returns the config of a single layer with its dirty flag tracked for the given consumer. For components the
+//config is extracted from the component.
+func (csi *ConfigServiceImpl) getLayer(consumerID string, channelID string, layerKey api.ConfigKey) ([]byte, bool, errors.Error) {
+	configData, dirty, err := csi.GetForConsumer(consumerID, channelID, layerKey)
+	if err != nil || len(configData) == 0 || layerKey.ComponentName == "" {
+		return configData, dirty, err
+	}
+	compConfig := api.ComponentConfig{}
+	if e := json.Unmarshal(configData, &compConfig); e != nil {
+		return nil, false, errors.Wrap(errors.UnmarshalError, e, "Error occurred while un-marshalling")
+	}
+	return []byte(compConfig.Config), dirty, nil
+}
+
 //Refresh adds new items into cache and refreshes existing ones
 func (csi *ConfigServiceImpl) Refresh(stub shim.ChaincodeStubInterface, mspID string) errors.Error {
 	logger.Debugf("***Refreshing mspid %s at %v\n", mspID, time.Unix(time.Now().Unix(), 0))
@@ -156,6 +352,9 @@ func (csi *ConfigServiceImpl) Refresh(stub shim.ChaincodeStubInterface, mspID st
 		return errors.New(errors.SystemError, "Stub is nil")
 	}
 
+	//the configs reflect at least the current height of the ledger
+	info := api.CacheInfo{BlockHeight: csi.getBlockHeight(stub.GetChannelID()), Timestamp: time.Now()}
+
 	configManager := mgmt.NewConfigManager(stub)
 	//get all by mspID
 	configKey := api.ConfigKey{MspID: mspID}
@@ -168,44 +367,113 @@ func (csi *ConfigServiceImpl) Refresh(stub shim.ChaincodeStubInterface, mspID st
 		return errors.Errorf(errors.SystemError, "Cannot create criteria for search by mspID %v", configMessages)
 	}
 
-	return csi.refreshCache(stub.GetChannelID(), configMessages, mspID)
+	if err := csi.refreshCache(stub.GetChannelID(), configMessages, mspID, info); err != nil {
+		return err
+	}
+	if err := csi.writeSnapshot(stub.GetChannelID(), mspID, info, configMessages); err != nil {
+		logger.Warnf("Failed to write cache snapshot for MSP [%s] on channel [%s]: %s", mspID, stub.GetChannelID(), err)
+	}
+	return nil
+}
+
+//getBlockHeight returns the height of the ledger of the channel or 0 if the height cannot be determined
+func (csi *ConfigServiceImpl) getBlockHeight(channelID string) uint64 {
+	height, err := csi.blockHeight(channelID)
+	if err != nil {
+		logger.Debugf("Cannot get block height of channel [%s]: %s\n", channelID, err)
+		return 0
+	}
+	return height
 }
 
 //GetConfigFromLedger - gets snaps configs from ledger
 func (csi *ConfigServiceImpl) GetConfigFromLedger(channelID string, configKey api.ConfigKey) ([]byte, bool, errors.Error) {
+	config, keyStr, err := csi.getConfigFromLedger(channelID, configKey)
+	if err != nil {
+		return nil, false, err
+	}
+	return config, csi.isConfigDirty(keyStr, config), nil
+}
+
+//GetConfigsFromLedger gets the configs for the given keys from the ledger in a single read.
+//The configs are returned in the order of the keys; the config of a key that doesn't exist is nil.
+func (csi *ConfigServiceImpl) GetConfigsFromLedger(channelID string, configKeys []api.ConfigKey) ([][]byte, errors.Error) {
+	configs, _, err := csi.getConfigsFromLedger(channelID, configKeys)
+	return configs, err
+}
+
+//getConfigFromLedger gets the config from the ledger along with the string form of the key
+func (csi *ConfigServiceImpl) getConfigFromLedger(channelID string, configKey api.ConfigKey) ([]byte, string, errors.Error) {
+	configs, keyStrs, err := csi.getConfigsFromLedger(channelID, []api.ConfigKey{configKey})
+	if err != nil {
+		return nil, "", err
+	}
+	return configs[0], keyStrs[0], nil
+}
 
-	logger.Debugf("Getting key [%#v] on channel [%s]", configKey, channelID)
-	lgr := peer.GetLedger(channelID)
+//getConfigsFromLedger gets the configs for the given keys from the ledger along with the string forms of the keys
+func (csi *ConfigServiceImpl) getConfigsFromLedger(channelID string, configKeys []api.ConfigKey) ([][]byte, []string, errors.Error) {
 
-	if lgr != nil {
-		logger.Debugf("****Ledger is set for channelID %s\n", channelID)
-		r := generateRandomAlphaOnlyString(12)
-		txsim, err := lgr.NewTxSimulator(r)
+	logger.Debugf("Getting keys [%#v] on channel [%s]", configKeys, channelID)
+
+	keyStrs := make([]string, len(configKeys))
+	for i, configKey := range configKeys {
+		keyStr, err := mgmt.ConfigKeyToString(configKey)
 		if err != nil {
-			errObj := errors.WithMessage(errors.SystemError, err, "Cannot create transaction simulator")
-			logger.Errorf("Get config from ledger failed: %s", errObj.GenerateLogMsg())
-			return nil, false, errObj
+			return nil, nil, err
 		}
-		defer txsim.Done()
+		keyStrs[i] = keyStr
+	}
 
-		keyStr, e := mgmt.ConfigKeyToString(configKey)
-		if e != nil {
-			return nil, false, e
+	reader, err := csi.stateReader(channelID)
+	if err != nil {
+		logger.Errorf("Get config from ledger failed: %s", err.GenerateLogMsg())
+		return nil, nil, err
+	}
+	ledgerValues, e := reader.GetStateMultipleKeys(configSnapNamespace, keyStrs)
+	reader.Done()
+	if e != nil {
+		errObj := errors.WithMessage(errors.SystemError, e, fmt.Sprintf("Error getting state for keys %s", keyStrs))
+		logger.Errorf("Get config from ledger failed: %s", errObj.GenerateLogMsg())
+		return nil, nil, errObj
+	}
+
+	configs := make([][]byte, len(configKeys))
+	for i, configKey := range configKeys {
+		config, err := csi.decrypt(configKey, ledgerValues[i])
+		if err != nil {
+			return nil, nil, err
 		}
-		config, err := txsim.GetState("configurationsnap", keyStr)
+		config, err = csi.resolveTemplate(channelID, configKey, config)
 		if err != nil {
-			errObj := errors.WithMessage(errors.SystemError, err, fmt.Sprintf("Error getting state for app %s %s", keyStr, err))
-			logger.Errorf("Get config from ledger failed: %s", errObj.GenerateLogMsg())
-			return nil, false, errObj
+			return nil, nil, err
 		}
-		return config, csi.isConfigDirty(keyStr, config), nil
+		csi.reloadEvicted(channelID, configKey, keyStrs[i], ledgerValues[i], config)
+		configs[i] = config
 	}
-	return nil, false, errors.Errorf(errors.SystemError, "Cannot obtain ledger for channel %s", channelID)
+	return configs, keyStrs, nil
 }
 
 //isConfigDirty checks if config retrieved for given key string is updated since its last retrieval.
 // it checks hash of config bytes previously to current one, if there is a mismatch then returns true
 func (csi *ConfigServiceImpl) isConfigDirty(keyStr string, config []byte) bool {
+	return csi.isDirty(csi.configHashes, keyStr, config)
+}
+
+//isConfigDirtyForConsumer checks if config retrieved for given key string is updated since its last retrieval by the given consumer
+func (csi *ConfigServiceImpl) isConfigDirtyForConsumer(consumerID string, keyStr string, config []byte) bool {
+	csi.mtx.Lock()
+	hashes, ok := csi.consumerHashes[consumerID]
+	if !ok {
+		hashes = make(map[string]string)
+		csi.consumerHashes[consumerID] = hashes
+	}
+	csi.mtx.Unlock()
+	return csi.isDirty(hashes, keyStr, config)
+}
+
+//isDirty checks the hash of the config against the hash in the given map of previously retrieved config hashes
+func (csi *ConfigServiceImpl) isDirty(hashes map[string]string, keyStr string, config []byte) bool {
 
 	if len(config) == 0 {
 		return false
@@ -215,7 +483,7 @@ func (csi *ConfigServiceImpl) isConfigDirty(keyStr string, config []byte) bool {
 	currentHash := csi.generateHash(config)
 
 	csi.mtx.RLock()
-	hash, ok := csi.configHashes[keyStr]
+	hash, ok := hashes[keyStr]
 	if ok {
 		dirtyFlag = !(currentHash == hash)
 	} else {
@@ -226,7 +494,7 @@ func (csi *ConfigServiceImpl) isConfigDirty(keyStr string, config []byte) bool {
 	if dirtyFlag {
 		//if there is config update then update hash values in map
 		csi.mtx.Lock()
-		csi.configHashes[keyStr] = currentHash
+		hashes[keyStr] = currentHash
 		csi.mtx.Unlock()
 	}
 
@@ -235,11 +503,10 @@ func (csi *ConfigServiceImpl) isConfigDirty(keyStr string, config []byte) bool {
 
 // generateHash generates hash for give bytes
 func (csi *ConfigServiceImpl) generateHash(bytes []byte) string {
-	digest := sha256.Sum256(bytes)
-	return base64.StdEncoding.EncodeToString(digest[:])
+	return mgmt.GenerateHash(bytes)
 }
 
-func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*api.ConfigKV, mspID string) errors.Error {
+func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*api.ConfigKV, mspID string, info api.CacheInfo) errors.Error {
 	if csi == nil {
 		return errors.New(errors.SystemError, "ConfigServiceImpl was not initialized")
 	}
@@ -247,6 +514,9 @@ func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*a
 	logger.Debugf("Updating cache for channel %s\n", channelID)
 
 	cache := make(map[string][]byte)
+	hashes := make(map[string]string)
+	encrypted := make(map[string]bool)
+	templates := make(map[string][]byte)
 	compCache := make(map[string][]*api.ComponentConfig)
 
 	for _, val := range configMessages {
@@ -255,7 +525,18 @@ func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*a
 			return err
 		}
 		logger.Debugf("Adding item for key [%s] and channel [%s] to cache\n", keyStr, channelID)
-		cache[keyStr] = val.Value
+		value, err := csi.decrypt(val.Key, val.Value)
+		if err != nil {
+			return err
+		}
+		cache[keyStr] = value
+		hashes[keyStr] = mgmt.GenerateHash(val.Value)
+		if isEncryptedConfig(val.Key, val.Value) {
+			encrypted[keyStr] = true
+		}
+		if isTemplate(val.Key, value) {
+			templates[keyStr] = value
+		}
 		if val.Key.ComponentName != "" {
 			key := val.Key
 			key.ComponentVersion = ""
@@ -264,7 +545,7 @@ func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*a
 				return err
 			}
 			compConfig := api.ComponentConfig{}
-			err := json.Unmarshal(val.Value, &compConfig)
+			err := json.Unmarshal(value, &compConfig)
 			if err != nil {
 				return errors.Wrap(errors.UnmarshalError, err, "Error occurred while un-marshalling")
 			}
@@ -281,27 +562,739 @@ func (csi *ConfigServiceImpl) refreshCache(channelID string, configMessages []*a
 		}
 		cache[key] = compsBytes
 	}
+	variables, err := getVariables(mspID, cache)
+	if err != nil {
+		return err
+	}
+	for keyStr, value := range csi.resolveTemplates(channelID, templates, variables) {
+		setResolved(cache, keyStr, value)
+	}
+
 	csi.mtx.Lock()
-	defer csi.mtx.Unlock()
-	instance.cacheMap[channelID+"_"+mspID] = cache
+	oldCache := csi.cacheMap[channelID+"_"+mspID]
+	oldHashes := csi.ledgerHashes[channelID+"_"+mspID]
+	csi.setCache(channelID, channelID+"_"+mspID, cache)
+	csi.ledgerHashes[channelID+"_"+mspID] = hashes
+	csi.encryptedKeys[channelID+"_"+mspID] = encrypted
+	csi.templates[channelID+"_"+mspID] = templates
+	csi.cacheInfo[channelID+"_"+mspID] = &info
+	csi.mtx.Unlock()
+
+	csi.resetLastRead(channelID+"_"+mspID, cache)
+	csi.evict(channelID)
+	changes := getChanges(oldHashes, hashes)
+	csi.pruneHashes(changes)
+	changes = append(changes, getResolvedChanges(oldCache, cache, oldHashes, hashes, templates)...)
+	changes = append(changes, csi.resolveForSchemas(channelID, mspID, changes)...)
+	csi.notify(channelID, changes)
 
 	logger.Debugf("Updated cache for channel %s\n", channelID)
 	return nil
 }
 
+//Update updates the cache with just the configs that were changed according to the given config event.
+//If the cache cannot be updated incrementally (e.g. because an earlier event was missed) then an error
+//is returned and the cache should be refreshed with Refresh.
+func (csi *ConfigServiceImpl) Update(stub shim.ChaincodeStubInterface, event *api.ConfigEvent) errors.Error {
+	if csi == nil {
+		return errors.New(errors.SystemError, "ConfigServiceImpl was not initialized")
+	}
+	if stub == nil {
+		return errors.New(errors.SystemError, "Stub is nil")
+	}
+
+	changesByMspID := make(map[string][]api.ConfigChange)
+	for _, change := range event.Changes {
+		changesByMspID[change.Key.MspID] = append(changesByMspID[change.Key.MspID], change)
+	}
+	for mspID, changes := range changesByMspID {
+		if err := csi.updateCache(stub, mspID, changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//configUpdate is a changed config that was read from the ledger and is to be applied to a cache
+type configUpdate struct {
+	change api.ConfigChange
+	keyStr string
+	//ledgerValue is the config as stored in the ledger (nil if it was deleted)
+	ledgerValue []byte
+	//value is the decrypted config, which is resolved if it is templated. It is nil if the template cannot be resolved.
+	value []byte
+	//template is the decrypted config if it is templated
+	template []byte
+}
+
+//updateCache applies the given changes to a copy of the cache of the MSP and replaces the cache with the copy.
+//The changed configs are read from the ledger and decrypted before the write lock is taken, so that reads of
+//the caches aren't blocked by ledger reads. The snapshot of the cache is updated afterwards.
+func (csi *ConfigServiceImpl) updateCache(stub shim.ChaincodeStubInterface, mspID string, changes []api.ConfigChange) errors.Error {
+	channelID := stub.GetChannelID()
+	cacheID := channelID + "_" + mspID
+	height := csi.getBlockHeight(channelID)
+
+	csi.mtx.RLock()
+	currentHashes, initialized := csi.ledgerHashes[cacheID], csi.cacheMap[cacheID] != nil
+	csi.mtx.RUnlock()
+	if !initialized {
+		logger.Debugf("Config cache is not initialized for MSP [%s] on channel [%s]. Nothing to update.\n", mspID, channelID)
+		return nil
+	}
+
+	updates, err := csi.readChanges(stub, currentHashes, changes)
+	if err != nil {
+		return err
+	}
+	if err := csi.resolveUpdates(channelID, mspID, updates); err != nil {
+		return err
+	}
+
+	//the changes are checked again against the cache under the write lock since it may have been replaced meanwhile
+	csi.mtx.Lock()
+	currentHashes, hashes, err := csi.applyChanges(channelID, mspID, updates, height)
+	csi.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	if hashes == nil {
+		logger.Debugf("Config cache is not initialized for MSP [%s] on channel [%s]. Nothing to update.\n", mspID, channelID)
+		return nil
+	}
+
+	csi.evict(channelID)
+	cacheChanges := getChanges(currentHashes, hashes)
+	csi.pruneHashes(cacheChanges)
+	if isVariablesChanged(mspID, cacheChanges) {
+		cacheChanges = append(cacheChanges, csi.reresolve(channelID, mspID, func(api.ConfigKey) bool { return true })...)
+	}
+	cacheChanges = append(cacheChanges, csi.resolveForSchemas(channelID, mspID, cacheChanges)...)
+	csi.notify(channelID, cacheChanges)
+	if err := csi.updateSnapshot(stub, mspID, currentHashes, updates); err != nil {
+		logger.Warnf("Failed to write cache snapshot for MSP [%s] on channel [%s]: %s", mspID, channelID, err)
+	}
+
+	logger.Debugf("Updated %d keys in cache for MSP [%s] on channel [%s]\n", len(changes), mspID, channelID)
+	return nil
+}
+
+//readChanges reads and decrypts the configs of the given changes that aren't reflected by the given ledger hashes
+//of a cache. An error is returned if the cache is out of date.
+func (csi *ConfigServiceImpl) readChanges(stub shim.ChaincodeStubInterface, hashes map[string]string, changes []api.ConfigChange) ([]*configUpdate, errors.Error) {
+	var updates []*configUpdate
+	for _, change := range changes {
+		keyStr, err := checkChange(stub.GetChannelID(), hashes, change)
+		if err != nil {
+			return nil, err
+		}
+		if keyStr == "" {
+			//already up to date
+			continue
+		}
+
+		value, e := stub.GetState(keyStr)
+		if e != nil {
+			return nil, errors.Wrap(errors.SystemError, e, "GetState failed")
+		}
+		update := &configUpdate{change: change, keyStr: keyStr, ledgerValue: value}
+		if len(value) > 0 {
+			update.value, err = csi.decrypt(change.Key, value)
+			if err != nil {
+				return nil, err
+			}
+			if isTemplate(change.Key, update.value) {
+				update.template = update.value
+			}
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+//checkChange returns the string form of the key of the given change if the change is to be applied to a cache with the
+//given ledger hashes, or an empty string if the cache already reflects the change. An error is returned if the cache
+//is out of date.
+func checkChange(channelID string, hashes map[string]string, change api.ConfigChange) (string, errors.Error) {
+	keyStr, err := mgmt.ConfigKeyToString(change.Key)
+	if err != nil {
+		return "", err
+	}
+	if hashes[keyStr] == change.NewHash {
+		return "", nil
+	}
+	if hashes[keyStr] != change.OldHash {
+		return "", errors.Errorf(errors.SystemError, "Cached config for key [%s] on channel [%s] is out of date", keyStr, channelID)
+	}
+	return keyStr, nil
+}
+
+//resolveUpdates resolves the templated configs of the given updates of the cache of the MSP, with the variables
+//of the updates if the variables of the MSP are updated
+func (csi *ConfigServiceImpl) resolveUpdates(channelID, mspID string, updates []*configUpdate) errors.Error {
+	variablesKeyStr, err := getVariablesKeyStr(mspID)
+	if err != nil {
+		return err
+	}
+	variables := csi.getCache(channelID, mspID)[variablesKeyStr]
+	templates := make(map[string][]byte)
+	updatesByKey := make(map[string]*configUpdate, len(updates))
+	for _, update := range updates {
+		updatesByKey[update.keyStr] = update
+		if update.keyStr == variablesKeyStr {
+			variables = update.value
+		}
+		if update.template != nil {
+			templates[update.keyStr] = update.template
+		}
+	}
+	for keyStr, value := range csi.resolveTemplates(channelID, templates, variables) {
+		updatesByKey[keyStr].value = value
+	}
+	return nil
+}
+
+//applyChanges replaces the cache of the MSP with a copy to which the given updates are applied and returns the
+//previous and the new ledger hashes of the cache. Nil hashes are returned if the cache isn't initialized. The caller
+//must hold the write lock.
+func (csi *ConfigServiceImpl) applyChanges(channelID, mspID string, updates []*configUpdate, height uint64) (map[string]string, map[string]string, errors.Error) {
+	cacheID := channelID + "_" + mspID
+	currentCache := csi.cacheMap[cacheID]
+	currentHashes := csi.ledgerHashes[cacheID]
+	currentEncrypted := csi.encryptedKeys[cacheID]
+	currentTemplates := csi.templates[cacheID]
+	if currentCache == nil {
+		return nil, nil, nil
+	}
+
+	cache := make(map[string][]byte, len(currentCache))
+	for key, value := range currentCache {
+		cache[key] = value
+	}
+	hashes := make(map[string]string, len(currentHashes))
+	for key, hash := range currentHashes {
+		hashes[key] = hash
+	}
+	encrypted := make(map[string]bool, len(currentEncrypted))
+	for key := range currentEncrypted {
+		encrypted[key] = true
+	}
+	templates := make(map[string][]byte, len(currentTemplates))
+	for key, template := range currentTemplates {
+		templates[key] = template
+	}
+
+	for _, update := range updates {
+		keyStr, err := checkChange(channelID, hashes, update.change)
+		if err != nil {
+			return nil, nil, err
+		}
+		if keyStr == "" {
+			//applied by a concurrent update
+			continue
+		}
+
+		delete(encrypted, keyStr)
+		delete(templates, keyStr)
+		if len(update.ledgerValue) == 0 {
+			logger.Debugf("Removing item for key [%s] from cache [%s]\n", keyStr, cacheID)
+			delete(cache, keyStr)
+			delete(hashes, keyStr)
+		} else {
+			logger.Debugf("Updating item for key [%s] in cache [%s]\n", keyStr, cacheID)
+			setResolved(cache, keyStr, update.value)
+			hashes[keyStr] = mgmt.GenerateHash(update.ledgerValue)
+			if isEncryptedConfig(update.change.Key, update.ledgerValue) {
+				encrypted[keyStr] = true
+			}
+			if update.template != nil {
+				templates[keyStr] = update.template
+			}
+			csi.touch(cacheID, keyStr)
+		}
+
+		if update.change.Key.ComponentName != "" {
+			if err := updateComponents(cache, update.change.Key, update.value); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	csi.setCache(channelID, cacheID, cache)
+	csi.ledgerHashes[cacheID] = hashes
+	csi.encryptedKeys[cacheID] = encrypted
+	csi.templates[cacheID] = templates
+	if info, ok := csi.cacheInfo[cacheID]; ok && height > info.BlockHeight {
+		info.BlockHeight = height
+		info.Timestamp = time.Now()
+	}
+	return currentHashes, hashes, nil
+}
+
+//updateComponents updates the cached list of the versions of a component with the given version of the component.
+//A nil value removes the version from the list. The list is updated in place (rather than rebuilt from the cached
+//versions) since versions may have been evicted from the cache.
+func updateComponents(cache cache, key api.ConfigKey, value []byte) errors.Error {
+	compKey := key
+	compKey.ComponentVersion = ""
+	compKeyStr, err := mgmt.ConfigKeyToString(compKey)
+	if err != nil {
+		return err
+	}
+
+	var comps []*api.ComponentConfig
+	if compsBytes := cache[compKeyStr]; len(compsBytes) > 0 {
+		if e := json.Unmarshal(compsBytes, &comps); e != nil {
+			return errors.Wrap(errors.UnmarshalError, e, "Error occurred while un-marshalling")
+		}
+	}
+	updated := make([]*api.ComponentConfig, 0, len(comps)+1)
+	for _, comp := range comps {
+		if comp.Version != key.ComponentVersion {
+			updated = append(updated, comp)
+		}
+	}
+	if len(value) > 0 {
+		compConfig := &api.ComponentConfig{}
+		if e := json.Unmarshal(value, compConfig); e != nil {
+			return errors.Wrap(errors.UnmarshalError, e, "Error occurred while un-marshalling")
+		}
+		updated = append(updated, compConfig)
+	}
+	if len(updated) == 0 {
+		delete(cache, compKeyStr)
+		return nil
+	}
+
+	sort.Slice(updated, func(i, j int) bool {
+		return updated[i].Version < updated[j].Version
+	})
+	compsBytes, e := json.Marshal(updated)
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal component")
+	}
+	cache[compKeyStr] = compsBytes
+	return nil
+}
+
+//decrypt returns the given config value with its config decrypted if it is encrypted
+func (csi *ConfigServiceImpl) decrypt(key api.ConfigKey, value []byte) ([]byte, errors.Error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	if key.ComponentName == "" {
+		if !mgmt.IsEncrypted(value) {
+			return value, nil
+		}
+		return mgmt.DecryptConfig(value, csi.keyUnwrapper)
+	}
+
+	compConfig := api.ComponentConfig{}
+	if err := json.Unmarshal(value, &compConfig); err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error occurred while un-marshalling")
+	}
+	if !mgmt.IsEncrypted([]byte(compConfig.Config)) {
+		return value, nil
+	}
+	config, err := mgmt.DecryptConfig([]byte(compConfig.Config), csi.keyUnwrapper)
+	if err != nil {
+		return nil, err
+	}
+	compConfig.Config = string(config)
+	compConfig.Encrypted = false
+	compBytes, e := json.Marshal(&compConfig)
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to marshal component")
+	}
+	return compBytes, nil
+}
+
+//isEncryptedConfig returns true if the given config value, as stored in the ledger, holds an encrypted config
+func isEncryptedConfig(key api.ConfigKey, value []byte) bool {
+	if key.ComponentName == "" {
+		return mgmt.IsEncrypted(value)
+	}
+	compConfig := api.ComponentConfig{}
+	if err := json.Unmarshal(value, &compConfig); err != nil {
+		return false
+	}
+	return mgmt.IsEncrypted([]byte(compConfig.Config))
+}
+
+//isEncrypted returns true if the config with the given key is encrypted in the ledger. The cached list of the versions
+//of a component is encrypted if any of the versions is encrypted.
+func (csi *ConfigServiceImpl) isEncrypted(channelID string, key api.ConfigKey, keyStr string, value []byte) bool {
+	csi.mtx.RLock()
+	encryptedKeys := csi.encryptedKeys[channelID+"_"+key.MspID]
+	csi.mtx.RUnlock()
+	if encryptedKeys[keyStr] {
+		return true
+	}
+	if key.ComponentName == "" || key.ComponentVersion != "" || len(value) == 0 {
+		return false
+	}
+
+	var comps []*api.ComponentConfig
+	if err := json.Unmarshal(value, &comps); err != nil {
+		//can't tell, so don't reveal it
+		return true
+	}
+	for _, comp := range comps {
+		compKey := key
+		compKey.ComponentVersion = comp.Version
+		compKeyStr, err := mgmt.ConfigKeyToString(compKey)
+		if err != nil || encryptedKeys[compKeyStr] {
+			return true
+		}
+	}
+	return false
+}
+
+//isTemplate returns true if the given (decrypted) config is templated
+func isTemplate(key api.ConfigKey, value []byte) bool {
+	return key.ComponentName == "" && mgmt.IsTemplate(value)
+}
+
+//getVariablesKeyStr returns the string form of the key of the variables of templated configs of the MSP
+func getVariablesKeyStr(mspID string) (string, errors.Error) {
+	return mgmt.ConfigKeyToString(api.ConfigKey{MspID: mspID, AppName: cfgsnapapi.VariablesAppName, AppVersion: cfgsnapapi.VariablesAppVersion})
+}
+
+//getVariables returns the variables config of the MSP from the given cache of the MSP
+func getVariables(mspID string, cache cache) ([]byte, errors.Error) {
+	keyStr, err := getVariablesKeyStr(mspID)
+	if err != nil {
+		return nil, err
+	}
+	return cache[keyStr], nil
+}
+
+//setResolved sets the resolved config of a key in the given cache. A config that cannot be resolved (nil) is left out
+//of the cache so that it is read from the ledger, which returns the error.
+func setResolved(cache cache, keyStr string, value []byte) {
+	if value == nil {
+		delete(cache, keyStr)
+		return
+	}
+	cache[keyStr] = value
+}
+
+//getResolvedChanges returns the changes of the templated configs whose resolved configs were changed by a refresh
+//although their templates weren't, i.e. because the variables of their MSP changed. Both hashes of such a change
+//are the hash of the stored template.
+func getResolvedChanges(oldCache, newCache cache, oldHashes, newHashes map[string]string, templates map[string][]byte) []api.ConfigChange {
+	var changes []api.ConfigChange
+	for keyStr := range templates {
+		hash := newHashes[keyStr]
+		if hash == "" || oldHashes[keyStr] != hash {
+			//the template changed
+			continue
+		}
+		oldValue, wasCached := oldCache[keyStr]
+		newValue, isCached := newCache[keyStr]
+		if !wasCached || !isCached || bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		key, err := mgmt.StringToConfigKey(keyStr)
+		if err != nil {
+			logger.Warnf("Invalid key [%s] in cache: %s", keyStr, err)
+			continue
+		}
+		changes = append(changes, api.ConfigChange{Key: key, OldHash: hash, NewHash: hash})
+	}
+	return changes
+}
+
+//isVariablesChanged returns true if the given changes of the configs of the MSP include its variables
+func isVariablesChanged(mspID string, changes []api.ConfigChange) bool {
+	variablesKey := api.ConfigKey{MspID: mspID, AppName: cfgsnapapi.VariablesAppName, AppVersion: cfgsnapapi.VariablesAppVersion}
+	for _, change := range changes {
+		if change.Key == variablesKey {
+			return true
+		}
+	}
+	return false
+}
+
+//resolveForSchemas resolves again the cached templated configs of every MSP on the channel whose schemas are among
+//the given changes of the configs of the MSP. Schemas are configs of the general MSP.
+func (csi *ConfigServiceImpl) resolveForSchemas(channelID, mspID string, changes []api.ConfigChange) []api.ConfigChange {
+	if mspID != cfgsnapapi.GeneralMspID {
+		return nil
+	}
+	apps := make(map[api.ConfigKey]bool)
+	for _, change := range changes {
+		if change.Key.AppName == cfgsnapapi.SchemaAppName && change.Key.ComponentName != "" && change.Key.ComponentVersion != "" {
+			apps[api.ConfigKey{AppName: change.Key.ComponentName, AppVersion: change.Key.ComponentVersion}] = true
+		}
+	}
+	if len(apps) == 0 {
+		return nil
+	}
+
+	var resolvedChanges []api.ConfigChange
+	for _, id := range csi.getMspIDs(channelID) {
+		resolvedChanges = append(resolvedChanges, csi.reresolve(channelID, id, func(key api.ConfigKey) bool {
+			return apps[api.ConfigKey{AppName: key.AppName, AppVersion: key.AppVersion}]
+		})...)
+	}
+	return resolvedChanges
+}
+
+//reresolve resolves again the cached templated configs of the MSP that match the given filter, e.g. because their
+//variables or schemas changed, and returns the changes of their resolved configs. Both hashes of such a change are
+//the hash of the stored template, which didn't change.
+func (csi *ConfigServiceImpl) reresolve(channelID, mspID string, filter func(api.ConfigKey) bool) []api.ConfigChange {
+	cacheID := channelID + "_" + mspID
+	variablesKeyStr, err := getVariablesKeyStr(mspID)
+	if err != nil {
+		logger.Warnf("Invalid variables key for MSP [%s]: %s", mspID, err)
+		return nil
+	}
+
+	csi.mtx.RLock()
+	variables := csi.cacheMap[cacheID][variablesKeyStr]
+	templates := make(map[string][]byte)
+	for keyStr, template := range csi.templates[cacheID] {
+		key, err := mgmt.StringToConfigKey(keyStr)
+		if _, cached := csi.cacheMap[cacheID][keyStr]; cached && err == nil && filter(key) {
+			templates[keyStr] = template
+		}
+	}
+	csi.mtx.RUnlock()
+	if len(templates) == 0 {
+		return nil
+	}
+
+	resolved := csi.resolveTemplates(channelID, templates, variables)
+
+	csi.mtx.Lock()
+	defer csi.mtx.Unlock()
+	currentCache := csi.cacheMap[cacheID]
+	cache := make(map[string][]byte, len(currentCache))
+	for key, value := range currentCache {
+		cache[key] = value
+	}
+	var changes []api.ConfigChange
+	for keyStr, value := range resolved {
+		oldValue, cached := cache[keyStr]
+		if !cached || !bytes.Equal(csi.templates[cacheID][keyStr], templates[keyStr]) {
+			//evicted, or changed by a concurrent update
+			continue
+		}
+		setResolved(cache, keyStr, value)
+		if bytes.Equal(oldValue, value) {
+			continue
+		}
+		key, err := mgmt.StringToConfigKey(keyStr)
+		if err != nil {
+			continue
+		}
+		hash := csi.ledgerHashes[cacheID][keyStr]
+		changes = append(changes, api.ConfigChange{Key: key, OldHash: hash, NewHash: hash})
+	}
+	csi.setCache(channelID, cacheID, cache)
+	return changes
+}
+
+//getMspIDs returns the IDs of the MSPs whose configs are cached on the channel
+func (csi *ConfigServiceImpl) getMspIDs(channelID string) []string {
+	csi.mtx.RLock()
+	defer csi.mtx.RUnlock()
+	var mspIDs []string
+	for cacheID := range csi.cacheMap {
+		if strings.HasPrefix(cacheID, channelID+"_") {
+			mspIDs = append(mspIDs, strings.TrimPrefix(cacheID, channelID+"_"))
+		}
+	}
+	return mspIDs
+}
+
+//resolveTemplates resolves the given templated configs of an MSP with the given variables config of the MSP. The
+//resolved configs are returned by key; the config of a template that cannot be resolved is nil.
+func (csi *ConfigServiceImpl) resolveTemplates(channelID string, templates map[string][]byte, variablesConfig []byte) map[string][]byte {
+	resolved := make(map[string][]byte, len(templates))
+	for keyStr, template := range templates {
+		key, err := mgmt.StringToConfigKey(keyStr)
+		if err == nil {
+			resolved[keyStr], err = csi.resolve(channelID, key, template, variablesConfig)
+		}
+		if err != nil {
+			logger.Warnf("Failed to resolve templated config for key [%s] on channel [%s]: %s", keyStr, channelID, err)
+			resolved[keyStr] = nil
+		}
+	}
+	return resolved
+}
+
+//resolveTemplate returns the given config value with its placeholders resolved if it is a templated config.
+//The variables of the config key's MSP are looked up in the cache, or in the ledger if they aren't cached.
+func (csi *ConfigServiceImpl) resolveTemplate(channelID string, key api.ConfigKey, value []byte) ([]byte, errors.Error) {
+	if !isTemplate(key, value) {
+		return value, nil
+	}
+
+	variablesKey := api.ConfigKey{MspID: key.MspID, AppName: cfgsnapapi.VariablesAppName, AppVersion: cfgsnapapi.VariablesAppVersion}
+	variablesConfig, err := csi.lookup(channelID, variablesKey)
+	if err != nil {
+		return nil, err
+	}
+	return csi.resolve(channelID, key, value, variablesConfig)
+}
+
+//resolve resolves the placeholders of the given templated config from the peer's config and from the given variables
+//config. The resolved config is validated against the schema of its app.
+func (csi *ConfigServiceImpl) resolve(channelID string, key api.ConfigKey, template []byte, variablesConfig []byte) ([]byte, errors.Error) {
+	variables, err := mgmt.ParseVariables(variablesConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	peerConfig, e := csi.peerConfig()
+	if e != nil {
+		return nil, errors.WithMessage(errors.GetConfigError, e, "Failed to get peer config")
+	}
+	for _, name := range mgmt.PeerVariables {
+		variables[name] = peerConfig.GetString(name)
+	}
+
+	resolved, err := mgmt.ResolveTemplate(template, variables)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := csi.getSchema(channelID, key.AppName, key.AppVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err := mgmt.ValidateConfig(schema, key.AppName, key.AppVersion, string(resolved), ""); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+//getSchema returns the schema for the given app name and version or nil if no schema was registered. Parsed
+//schemas are kept by the hash of the stored schema.
+func (csi *ConfigServiceImpl) getSchema(channelID, appName, appVersion string) (*gojsonschema.Schema, errors.Error) {
+	schemaKey, err := mgmt.SchemaKey(appName, appVersion)
+	if err != nil {
+		return nil, err
+	}
+	value, err := csi.lookup(channelID, schemaKey)
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+
+	hash := mgmt.GenerateHash(value)
+	csi.schemaMtx.Lock()
+	defer csi.schemaMtx.Unlock()
+	if schema, ok := csi.schemas[hash]; ok {
+		return schema, nil
+	}
+	schema, err := mgmt.ParseSchema(appName, value)
+	if err != nil {
+		return nil, err
+	}
+	csi.schemas[hash] = schema
+	return schema, nil
+}
+
+//lookup returns the config for the given key (e.g. the variables or a schema of templated configs) from the cache. The
+//config is read from the ledger if the configs of the key's MSP aren't cached or if the config was evicted from the cache.
+func (csi *ConfigServiceImpl) lookup(channelID string, key api.ConfigKey) ([]byte, errors.Error) {
+	keyStr, err := mgmt.ConfigKeyToString(key)
+	if err != nil {
+		return nil, err
+	}
+	channelCache := csi.getCache(channelID, key.MspID)
+	if channelCache != nil {
+		if value := channelCache[keyStr]; len(value) > 0 || !csi.isEvicted(channelID, key.MspID, keyStr) {
+			return value, nil
+		}
+	}
+	value, _, err := csi.getConfigFromLedger(channelID, key)
+	return value, err
+}
+
+//getPeerConfig returns the peer's config (core.yaml)
+func getPeerConfig() (*viper.Viper, error) {
+	return peerConfigCache.Get("")
+}
+
+//unwrapKey decrypts the content key of an encrypted config with the peer's RSA private key whose SKI is the key ID.
+//The BCCSP providers can't decrypt with RSA keys, so the private key is loaded from the key store of the SW provider
+//and configs can only be decrypted on peers whose BCCSP provider is SW.
+func (csi *ConfigServiceImpl) unwrapKey(keyID string, wrappedKey []byte) ([]byte, error) {
+	peerConfig, err := csi.peerConfig()
+	if err != nil {
+		return nil, errors.WithMessage(errors.GetConfigError, err, "Failed to get peer config")
+	}
+	if provider := peerConfig.GetString("peer.BCCSP.Default"); provider != swProvider {
+		return nil, errors.Errorf(errors.CryptoError, "Decrypting configs is not supported by BCCSP provider [%s]", provider)
+	}
+	privateKey, codedErr := loadRSAPrivateKey(getSWKeyStorePath(peerConfig), keyID)
+	if codedErr != nil {
+		return nil, codedErr
+	}
+	contentKey, e := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	if e != nil {
+		return nil, errors.Wrapf(errors.CryptoError, e, "Failed to decrypt content key with key [%s]", keyID)
+	}
+	return contentKey, nil
+}
+
+//getSWKeyStorePath returns the key store path of the SW BCCSP, which defaults to the keystore directory under the
+//MSP config path. Relative paths are relative to the directory of the peer config.
+func getSWKeyStorePath(peerConfig *viper.Viper) string {
+	keyStorePath := peerConfig.GetString("peer.BCCSP.SW.FileKeyStore.KeyStore")
+	if keyStorePath == "" {
+		keyStorePath = filepath.Join(peerConfig.GetString("peer.mspConfigPath"), "keystore")
+	}
+	if !filepath.IsAbs(keyStorePath) && peerConfig.ConfigFileUsed() != "" {
+		keyStorePath = filepath.Join(filepath.Dir(peerConfig.ConfigFileUsed()), keyStorePath)
+	}
+	return keyStorePath
+}
+
+//loadRSAPrivateKey loads the RSA private key with the given (hex encoded) SKI from the key store of the SW BCCSP,
+//which stores the private key of SKI <ski> in file <ski>_sk
+func loadRSAPrivateKey(keyStorePath, keyID string) (*rsa.PrivateKey, errors.Error) {
+	ski, e := hex.DecodeString(keyID)
+	if e != nil {
+		return nil, errors.Wrapf(errors.CryptoError, e, "Key ID [%s] is not a hex encoded SKI", keyID)
+	}
+	keyPath := filepath.Join(keyStorePath, hex.EncodeToString(ski)+"_sk")
+	keyPEM, e := ioutil.ReadFile(keyPath)
+	if e != nil {
+		return nil, errors.Wrapf(errors.CryptoError, e, "Failed to read private key [%s]", keyPath)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.Errorf(errors.CryptoError, "Private key [%s] is not PEM encoded", keyPath)
+	}
+	if privateKey, e := x509.ParsePKCS1PrivateKey(block.Bytes); e == nil {
+		return privateKey, nil
+	}
+	key, e := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if e != nil {
+		return nil, errors.Wrapf(errors.CryptoError, e, "Failed to parse private key [%s]", keyPath)
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf(errors.CryptoError, "Private key [%s] is not an RSA key", keyPath)
+	}
+	return privateKey, nil
+}
+
 func (csi *ConfigServiceImpl) getCache(channelID, mspID string) cache {
 	csi.mtx.RLock()
 	defer csi.mtx.RUnlock()
 	return csi.cacheMap[channelID+"_"+mspID]
 }
 
-// generateRandomAlphaOnlyString generates an alphabetical random string with length n.
-func generateRandomAlphaOnlyString(n int) string {
-	// make a really long string
-	letters := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	return string(b)
+//isEvicted returns true if the config with the given key is in the ledger but was evicted from the cache
+func (csi *ConfigServiceImpl) isEvicted(channelID, mspID, keyStr string) bool {
+	csi.mtx.RLock()
+	defer csi.mtx.RUnlock()
+	_, ok := csi.ledgerHashes[channelID+"_"+mspID][keyStr]
+	return ok
 }
@@ -8,15 +8,30 @@ package service
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"testing"
+	"time"
 
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
 	"github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
 	"github.com/securekey/fabric-snaps/metrics/pkg/util"
 	mockstub "github.com/securekey/fabric-snaps/mocks/mockstub"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -255,7 +270,7 @@ func TestRefreshCache(t *testing.T) {
 	configKV := api.ConfigKV{Key: key, Value: []byte("someValue")}
 	configMessages := []*api.ConfigKV{&configKV}
 
-	err := cacheInstance.refreshCache(stub.GetChannelID(), configMessages, mspID)
+	err := cacheInstance.refreshCache(stub.GetChannelID(), configMessages, mspID, api.CacheInfo{})
 	if err != nil {
 		t.Fatalf("Error 'refreshing cache %s", err)
 	}
@@ -268,7 +283,7 @@ func TestRefreshCache(t *testing.T) {
 	configKV = api.ConfigKV{Key: key, Value: []byte("someValue")}
 	configMessages = []*api.ConfigKV{&configKV}
 
-	err = cacheInstance.refreshCache(stub.GetChannelID(), configMessages, mspID)
+	err = cacheInstance.refreshCache(stub.GetChannelID(), configMessages, mspID, api.CacheInfo{})
 	if err != nil {
 		t.Fatalf("Error 'refreshing cache %s", err)
 	}
@@ -387,6 +402,800 @@ func TestIsDirty(t *testing.T) {
 
 }
 
+func TestGetForConsumer(t *testing.T) {
+	stub := getMockStub()
+	msg := `{"MspID":"msp.one","Apps":[{"AppName":"consumerApp","Version":"1","Config":"v1"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	cacheInstance := Initialize(stub, mspID)
+	key := api.ConfigKey{MspID: mspID, AppName: "consumerApp", AppVersion: "1"}
+
+	_, _, err := cacheInstance.GetForConsumer("", channelID, key)
+	assert.Error(t, err)
+
+	//each consumer sees the update
+	for _, consumerID := range []string{"consumer1", "consumer2"} {
+		value, dirty, err := cacheInstance.GetForConsumer(consumerID, channelID, key)
+		if err != nil {
+			t.Fatalf("GetForConsumer returned error %s", err)
+		}
+		assert.Equal(t, "v1", string(value))
+		assert.True(t, dirty, "supposed to be dirty for %s", consumerID)
+	}
+	_, dirty, err := cacheInstance.GetForConsumer("consumer1", channelID, key)
+	if err != nil {
+		t.Fatalf("GetForConsumer returned error %s", err)
+	}
+	assert.False(t, dirty, "not supposed to be dirty")
+
+	//shared dirty tracking is independent of the consumers
+	_, dirty, err = cacheInstance.Get(channelID, key)
+	if err != nil {
+		t.Fatalf("Get returned error %s", err)
+	}
+	assert.True(t, dirty, "supposed to be dirty")
+
+	cacheInstance.ResetConsumer("consumer1")
+	_, dirty, err = cacheInstance.GetForConsumer("consumer1", channelID, key)
+	if err != nil {
+		t.Fatalf("GetForConsumer returned error %s", err)
+	}
+	assert.True(t, dirty, "supposed to be dirty after reset")
+	_, dirty, err = cacheInstance.GetForConsumer("consumer2", channelID, key)
+	if err != nil {
+		t.Fatalf("GetForConsumer returned error %s", err)
+	}
+	assert.False(t, dirty, "not supposed to be dirty")
+}
+
+func TestGetMerged(t *testing.T) {
+	stub := getMockStub()
+	msgs := []string{
+		`{"MspID":"general","Apps":[{"AppName":"mergeApp","Version":"1","Config":"a: general\nb:\n  c: general\n  d: general"}]}`,
+		`{"MspID":"msp.one","Apps":[{"AppName":"mergeApp","Version":"1","Config":"b:\n  c: msp\ne: msp"}]}`,
+		`{"MspID":"msp.one","Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"mergeApp","Version":"1","Config":"b:\n  d: peer"}]}]}`,
+	}
+	for _, msg := range msgs {
+		if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+			t.Fatalf("Cannot upload %s", err)
+		}
+	}
+	cacheInstance := Initialize(stub, mspID)
+
+	key := api.ConfigKey{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "mergeApp", AppVersion: "1"}
+	merged, dirty, err := cacheInstance.GetMerged(stub.GetChannelID(), key, api.YAML)
+	if err != nil {
+		t.Fatalf("GetMerged return error %s", err)
+	}
+	assert.True(t, dirty)
+	assert.Equal(t, "general", merged.GetString("a"))
+	assert.Equal(t, "msp", merged.GetString("b.c"))
+	assert.Equal(t, "peer", merged.GetString("b.d"))
+	assert.Equal(t, "msp", merged.GetString("e"))
+
+	assert.Equal(t, "general", merged.Sources["a"].MspID)
+	assert.Equal(t, mspID, merged.Sources["b.c"].MspID)
+	assert.Equal(t, "", merged.Sources["b.c"].PeerID)
+	assert.Equal(t, "peer.zero.example.com", merged.Sources["b.d"].PeerID)
+	assert.Len(t, merged.Sources, 4)
+
+	//the merged config is not dirty if none of the layers changed
+	_, dirty, err = cacheInstance.GetMerged(stub.GetChannelID(), key, api.YAML)
+	if err != nil {
+		t.Fatalf("GetMerged return error %s", err)
+	}
+	assert.False(t, dirty)
+
+	//GetMerged doesn't consume the dirty flags of Get
+	_, dirty, err = cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	assert.True(t, dirty)
+
+	_, _, err = cacheInstance.GetMerged(stub.GetChannelID(), api.ConfigKey{MspID: mspID, AppName: "mergeApp", AppVersion: "1", ComponentName: "comp1"}, api.YAML)
+	assert.Error(t, err, "expected error for component without version")
+}
+
+func TestEncryptedConfig(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key %s", err)
+	}
+	defer func(unwrapper mgmt.KeyUnwrapper) { instance.keyUnwrapper = unwrapper }(instance.keyUnwrapper)
+	instance.keyUnwrapper = func(keyID string, wrappedKey []byte) ([]byte, error) {
+		if keyID != "testKey" {
+			return nil, fmt.Errorf("unknown key [%s]", keyID)
+		}
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	}
+
+	appConfig, codedErr := mgmt.EncryptConfig([]byte("secret app config"), &privateKey.PublicKey, "testKey")
+	if codedErr != nil {
+		t.Fatalf("Error encrypting config %s", codedErr)
+	}
+	compConfig, codedErr := mgmt.EncryptConfig([]byte("secret comp config"), &privateKey.PublicKey, "testKey")
+	if codedErr != nil {
+		t.Fatalf("Error encrypting config %s", codedErr)
+	}
+	msg := fmt.Sprintf(`{"MspID":"msp.one","Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"secretApp","Version":"1","Encrypted":true,"Config":"%s"}]}],"Apps":[{"AppName":"secretApp","Version":"1","Components":[{"Name":"comp1","Version":"1","Encrypted":true,"Config":"%s"}]}]}`, appConfig, compConfig)
+
+	stub := getMockStub()
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	cacheInstance := Initialize(stub, mspID)
+
+	value, _, err := cacheInstance.Get(stub.GetChannelID(), api.ConfigKey{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "secretApp", AppVersion: "1"})
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	assert.Equal(t, "secret app config", string(value))
+
+	value, _, err = cacheInstance.Get(stub.GetChannelID(), api.ConfigKey{MspID: mspID, AppName: "secretApp", AppVersion: "1", ComponentName: "comp1", ComponentVersion: "1"})
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	comp := api.ComponentConfig{}
+	if err := json.Unmarshal(value, &comp); err != nil {
+		t.Fatalf("Error unmarshalling component %s", err)
+	}
+	assert.Equal(t, "secret comp config", comp.Config)
+	assert.False(t, comp.Encrypted)
+
+	//decrypted configs aren't returned from the cache
+	for _, key := range []api.ConfigKey{
+		{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "secretApp", AppVersion: "1"},
+		{MspID: mspID, AppName: "secretApp", AppVersion: "1", ComponentName: "comp1", ComponentVersion: "1"},
+		{MspID: mspID, AppName: "secretApp", AppVersion: "1", ComponentName: "comp1"},
+	} {
+		_, err = cacheInstance.GetFromCache(stub.GetChannelID(), key)
+		assert.Error(t, err, "expected error for encrypted config %+v", key)
+	}
+
+	//configs encrypted with an unknown key cannot be cached
+	instance.keyUnwrapper = func(keyID string, wrappedKey []byte) ([]byte, error) {
+		return nil, fmt.Errorf("unknown key [%s]", keyID)
+	}
+	assert.Error(t, cacheInstance.Refresh(stub, mspID))
+}
+
+func TestUnwrapKey(t *testing.T) {
+	keyStorePath, err := ioutil.TempDir("", "configkeystore")
+	if err != nil {
+		t.Fatalf("Error creating key store %s", err)
+	}
+	defer os.RemoveAll(keyStorePath)
+	csp, err := sw.NewDefaultSecurityLevel(keyStorePath)
+	if err != nil {
+		t.Fatalf("Error creating SW BCCSP %s", err)
+	}
+	k, err := csp.KeyGen(&bccsp.RSA2048KeyGenOpts{Temporary: false})
+	if err != nil {
+		t.Fatalf("Error generating key %s", err)
+	}
+	pubKey, err := k.PublicKey()
+	if err != nil {
+		t.Fatalf("Error getting public key %s", err)
+	}
+	pubKeyBytes, err := pubKey.Bytes()
+	if err != nil {
+		t.Fatalf("Error marshalling public key %s", err)
+	}
+	rsaPubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
+	if err != nil {
+		t.Fatalf("Error parsing public key %s", err)
+	}
+
+	provider := "SW"
+	svc := newConfigService()
+	svc.peerConfig = func() (*viper.Viper, error) {
+		v := viper.New()
+		v.Set("peer.BCCSP.Default", provider)
+		v.Set("peer.BCCSP.SW.FileKeyStore.KeyStore", keyStorePath)
+		return v, nil
+	}
+
+	keyID := hex.EncodeToString(k.SKI())
+	encrypted, codedErr := mgmt.EncryptConfig([]byte("secret config"), rsaPubKey.(*rsa.PublicKey), keyID)
+	if codedErr != nil {
+		t.Fatalf("Error encrypting config %s", codedErr)
+	}
+	decrypted, codedErr := mgmt.DecryptConfig([]byte(encrypted), svc.keyUnwrapper)
+	if codedErr != nil {
+		t.Fatalf("Error decrypting config %s", codedErr)
+	}
+	assert.Equal(t, "secret config", string(decrypted))
+
+	//the key is not in the key store
+	otherKeyID := hex.EncodeToString([]byte("other key"))
+	otherEncrypted, codedErr := mgmt.EncryptConfig([]byte("secret config"), rsaPubKey.(*rsa.PublicKey), otherKeyID)
+	if codedErr != nil {
+		t.Fatalf("Error encrypting config %s", codedErr)
+	}
+	_, codedErr = mgmt.DecryptConfig([]byte(otherEncrypted), svc.keyUnwrapper)
+	assert.Error(t, codedErr, "expected error for unknown key")
+
+	//only the key store of the SW provider is supported
+	provider = "PKCS11"
+	_, codedErr = mgmt.DecryptConfig([]byte(encrypted), svc.keyUnwrapper)
+	assert.Error(t, codedErr, "expected error for PKCS11 provider")
+}
+
+func TestTemplatedConfig(t *testing.T) {
+	defer func(peerConfig func() (*viper.Viper, error)) { instance.peerConfig = peerConfig }(instance.peerConfig)
+	instance.peerConfig = func() (*viper.Viper, error) {
+		v := viper.New()
+		v.Set("peer.id", "peer.zero.example.com")
+		v.Set("peer.localMspId", mspID)
+		v.Set("peer.address", "peer0:7051")
+		return v, nil
+	}
+
+	msg := `{"MspID":"msp.one","Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"templatedApp","Version":"1","Templated":true,"Config":"id=${peer.id} msp=${peer.localMspId} address=${peer.address} url=${serverURL}"}]}],"Apps":[{"AppName":"configvariables","Version":"1","Config":"{\"serverURL\":\"https://server:443\"}"}]}`
+	stub := getMockStub()
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	cacheInstance := Initialize(stub, mspID)
+
+	key := api.ConfigKey{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "templatedApp", AppVersion: "1"}
+	value, dirty, err := cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	expected := "id=peer.zero.example.com msp=msp.one address=peer0:7051 url=https://server:443"
+	assert.Equal(t, expected, string(value))
+	assert.True(t, dirty)
+
+	value, err = cacheInstance.GetFromCache(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("GetFromCache return error %s", err)
+	}
+	assert.Equal(t, expected, string(value))
+
+	//templates that reference undefined variables cannot be saved
+	msg = `{"MspID":"msp.one","Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"templatedApp","Version":"1","Templated":true,"Config":"url=${undefinedVar}"}]}]}`
+	_, err = uplaodConfigToHL(t, stub, msg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "undefinedVar")
+}
+
+func TestTemplatedConfigSchema(t *testing.T) {
+	defer func(peerConfig func() (*viper.Viper, error)) { instance.peerConfig = peerConfig }(instance.peerConfig)
+	instance.peerConfig = func() (*viper.Viper, error) {
+		v := viper.New()
+		v.Set("peer.id", "peer.zero.example.com")
+		return v, nil
+	}
+
+	msgs := []string{
+		`{"MspID":"general","Apps":[{"AppName":"configschema","Version":"1","Components":[{"Name":"schemaTemplatedApp","Version":"1","Config":"{\"type\":\"object\",\"properties\":{\"port\":{\"type\":\"integer\"}},\"required\":[\"port\"]}"}]}]}`,
+		`{"MspID":"msp.one","Apps":[{"AppName":"configvariables","Version":"1","Config":"{\"port\":\"7051\",\"serverURL\":\"https://server:443\"}"}],"Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"schemaTemplatedApp","Version":"1","Templated":true,"Config":"port: ${port}\nid: ${peer.id}"},{"AppName":"schemaTemplatedApp","Version":"2","Templated":true,"Config":"port: ${serverURL}"}]}]}`,
+	}
+	stub := getMockStub()
+	for _, msg := range msgs {
+		if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+			t.Fatalf("Cannot upload %s", err)
+		}
+	}
+	cacheInstance := Initialize(stub, mspID)
+
+	key := api.ConfigKey{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "schemaTemplatedApp", AppVersion: "1"}
+	value, _, err := cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	assert.Equal(t, "port: 7051\nid: peer.zero.example.com", string(value))
+
+	//the resolved config is validated against the schema of the app (version 2 has no schema)
+	msg := `{"MspID":"general","Apps":[{"AppName":"configschema","Version":"1","Components":[{"Name":"schemaTemplatedApp","Version":"2","Config":"{\"type\":\"object\",\"properties\":{\"port\":{\"type\":\"integer\"}}}"}]}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	cacheInstance = Initialize(stub, mspID)
+	key.AppVersion = "2"
+	_, _, err = cacheInstance.Get(stub.GetChannelID(), key)
+	assert.Error(t, err, "expected error for resolved config that doesn't match its schema")
+}
+
+func TestTemplatedConfigFromLedger(t *testing.T) {
+	csi := newConfigService()
+	csi.peerConfig = func() (*viper.Viper, error) {
+		return viper.New(), nil
+	}
+	key := api.ConfigKey{MspID: "msp.two", AppName: "ledgerTemplatedApp", AppVersion: "1"}
+	keyStr, err := mgmt.ConfigKeyToString(key)
+	if err != nil {
+		t.Fatalf("ConfigKeyToString returned error %s", err)
+	}
+	variablesKeyStr, err := mgmt.ConfigKeyToString(api.ConfigKey{MspID: "msp.two", AppName: "configvariables", AppVersion: "1"})
+	if err != nil {
+		t.Fatalf("ConfigKeyToString returned error %s", err)
+	}
+	csi.stateReader = func(channelID string) (stateReader, errors.Error) {
+		return &mockStateReader{state: map[string][]byte{
+			keyStr:          []byte(mgmt.TemplatePrefix + "url=${serverURL}"),
+			variablesKeyStr: []byte(`{"serverURL":"https://server:443"}`),
+		}}, nil
+	}
+
+	//the configs of msp.two aren't cached so the variables are read from the ledger
+	config, _, err := csi.GetConfigFromLedger(channelID, key)
+	if err != nil {
+		t.Fatalf("GetConfigFromLedger returned error %s", err)
+	}
+	assert.Equal(t, "url=https://server:443", string(config))
+}
+
+func TestTemplatedConfigVariablesUpdate(t *testing.T) {
+	key := api.ConfigKey{MspID: mspID, PeerID: "peer.zero.example.com", AppName: "updatedTemplateApp", AppVersion: "1"}
+	changes, unsubscribe := instance.Subscribe(channelID, key)
+	defer unsubscribe()
+
+	stub := getMockStub()
+	msg := `{"MspID":"msp.one","Peers":[{"PeerID":"peer.zero.example.com","App":[{"AppName":"updatedTemplateApp","Version":"1","Templated":true,"Config":"url=${serverURL}"}]}],"Apps":[{"AppName":"configvariables","Version":"1","Config":"{\"serverURL\":\"https://server:443\"}"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	<-stub.ChaincodeEventsChannel
+	cacheInstance := Initialize(stub, mspID)
+	<-changes
+
+	value, _, err := cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	assert.Equal(t, "url=https://server:443", string(value))
+
+	//the templated config is resolved again when the variables change
+	msg = `{"MspID":"msp.one","Apps":[{"AppName":"configvariables","Version":"1","Config":"{\"serverURL\":\"https://newserver:443\"}"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	if err := cacheInstance.Update(stub, getConfigEvent(t, stub)); err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+	value, dirty, err := cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get return error %s", err)
+	}
+	assert.Equal(t, "url=https://newserver:443", string(value))
+	assert.True(t, dirty)
+
+	change := <-changes
+	assert.Equal(t, key, change.Key)
+	assert.Equal(t, change.OldHash, change.NewHash)
+}
+
+func TestUpdate(t *testing.T) {
+	stub := getMockStub()
+	msg := `{"MspID":"msp.one","Apps":[{"AppName":"updateApp","Version":"1","Config":"v1"},{"AppName":"updateCompApp","Version":"1","Components":[{"Name":"comp1","Version":"1","Config":"comp v1"}]}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	<-stub.ChaincodeEventsChannel
+	cacheInstance := Initialize(stub, mspID)
+
+	msg = `{"MspID":"msp.one","Apps":[{"AppName":"updateApp","Version":"1","Config":"v2"},{"AppName":"updateCompApp","Version":"1","Components":[{"Name":"comp1","Version":"2","Config":"comp v2"}]}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	event := getConfigEvent(t, stub)
+	if err := cacheInstance.Update(stub, event); err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+
+	value, err := cacheInstance.GetFromCache(stub.GetChannelID(), api.ConfigKey{MspID: mspID, AppName: "updateApp", AppVersion: "1"})
+	if err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	assert.Equal(t, "v2", string(value))
+	value, err = cacheInstance.GetFromCache(stub.GetChannelID(), api.ConfigKey{MspID: mspID, AppName: "updateCompApp", AppVersion: "1", ComponentName: "comp1"})
+	if err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	var comps []*api.ComponentConfig
+	if err := json.Unmarshal(value, &comps); err != nil {
+		t.Fatalf("Cannot unmarshal components %s", err)
+	}
+	assert.Equal(t, 2, len(comps))
+
+	//deleted configs are removed from the cache
+	if err := mgmt.NewConfigManager(stub).Delete(api.ConfigKey{MspID: mspID, AppName: "updateCompApp", AppVersion: "1", ComponentName: "comp1"}); err != nil {
+		t.Fatalf("Delete returned error %s", err)
+	}
+	if err := cacheInstance.Update(stub, getConfigEvent(t, stub)); err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+	_, err = cacheInstance.GetFromCache(stub.GetChannelID(), api.ConfigKey{MspID: mspID, AppName: "updateCompApp", AppVersion: "1", ComponentName: "comp1", ComponentVersion: "2"})
+	assert.Error(t, err, "expected error for deleted config")
+
+	//the cache is out of date if an event was missed
+	msg = `{"MspID":"msp.one","Apps":[{"AppName":"updateApp","Version":"1","Config":"v3"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	<-stub.ChaincodeEventsChannel
+	msg = `{"MspID":"msp.one","Apps":[{"AppName":"updateApp","Version":"1","Config":"v4"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	assert.Error(t, cacheInstance.Update(stub, getConfigEvent(t, stub)))
+}
+
+func TestUpdateDoesNotBlockGet(t *testing.T) {
+	stub := getMockStub()
+	if _, err := uplaodConfigToHL(t, stub, `{"MspID":"msp.one","Apps":[{"AppName":"blockingApp","Version":"1","Config":"v1"}]}`); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	<-stub.ChaincodeEventsChannel
+	cacheInstance := Initialize(stub, mspID)
+
+	if _, err := uplaodConfigToHL(t, stub, `{"MspID":"msp.one","Apps":[{"AppName":"blockingApp","Version":"1","Config":"v2"}]}`); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	event := getConfigEvent(t, stub)
+
+	blocking := &blockingStub{MockStub: stub, reading: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan errors.Error, 1)
+	go func() {
+		done <- cacheInstance.Update(blocking, event)
+	}()
+	<-blocking.reading
+
+	//the cache can be read while the changed config is read from the ledger
+	key := api.ConfigKey{MspID: mspID, AppName: "blockingApp", AppVersion: "1"}
+	value, _, err := cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get returned error %s", err)
+	}
+	assert.Equal(t, "v1", string(value))
+
+	close(blocking.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+	value, _, err = cacheInstance.Get(stub.GetChannelID(), key)
+	if err != nil {
+		t.Fatalf("Get returned error %s", err)
+	}
+	assert.Equal(t, "v2", string(value))
+}
+
+func TestSubscribe(t *testing.T) {
+	changes, unsubscribe := instance.Subscribe(channelID, api.ConfigKey{MspID: mspID, AppName: "subscribedApp"})
+	otherChanges, unsubscribeOther := instance.Subscribe("otherChannel", api.ConfigKey{})
+	defer unsubscribeOther()
+
+	stub := getMockStub()
+	msg := `{"MspID":"msp.one","Apps":[{"AppName":"subscribedApp","Version":"1","Config":"v1"},{"AppName":"otherApp","Version":"1","Config":"v1"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	<-stub.ChaincodeEventsChannel
+	cacheInstance := Initialize(stub, mspID)
+
+	key := api.ConfigKey{MspID: mspID, AppName: "subscribedApp", AppVersion: "1"}
+	assert.Equal(t, api.ConfigChange{Key: key, NewHash: mgmt.GenerateHash([]byte("v1"))}, <-changes)
+
+	//a full refresh without changes does not notify subscribers
+	if err := cacheInstance.Refresh(stub, mspID); err != nil {
+		t.Fatalf("Refresh returned error %s", err)
+	}
+	msg = `{"MspID":"msp.one","Apps":[{"AppName":"subscribedApp","Version":"1","Config":"v2"},{"AppName":"otherApp","Version":"1","Config":"v2"}]}`
+	if _, err := uplaodConfigToHL(t, stub, msg); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	if err := cacheInstance.Update(stub, getConfigEvent(t, stub)); err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+	assert.Equal(t, api.ConfigChange{Key: key, OldHash: mgmt.GenerateHash([]byte("v1")), NewHash: mgmt.GenerateHash([]byte("v2"))}, <-changes)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, 0, len(otherChanges))
+
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "configsnapshots")
+	if err != nil {
+		t.Fatalf("Cannot create temp dir %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	height := uint64(10)
+	newService := func() *ConfigServiceImpl {
+		svc := newConfigService()
+		svc.peerConfig = func() (*viper.Viper, error) {
+			v := viper.New()
+			v.Set("peer.fileSystemPath", dir)
+			return v, nil
+		}
+		svc.snapshotSigner = func() (snapshotSigner, error) { return &mockSigner{key: []byte("signing key")}, nil }
+		svc.blockHeight = func(string) (uint64, error) { return height, nil }
+		return svc
+	}
+	key := api.ConfigKey{MspID: mspID, AppName: "snapshotApp", AppVersion: "1"}
+
+	stub := getMockStub()
+	if _, err := uplaodConfigToHL(t, stub, `{"MspID":"msp.one","Apps":[{"AppName":"snapshotApp","Version":"1","Config":"v1"}]}`); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	svc := newService()
+	svc.initialize(stub, mspID)
+	info, ok := svc.GetCacheInfo(channelID, mspID)
+	if !ok {
+		t.Fatalf("Expected cache info")
+	}
+	assert.Equal(t, uint64(10), info.BlockHeight)
+	assert.False(t, info.FromSnapshot)
+
+	//the cache is loaded from the snapshot if the ledger is behind the snapshot
+	height = 5
+	svc = newService()
+	svc.initialize(getMockStub(), mspID)
+	value, err := svc.GetFromCache(channelID, key)
+	if err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	assert.Equal(t, "v1", string(value))
+	info, ok = svc.GetCacheInfo(channelID, mspID)
+	if !ok {
+		t.Fatalf("Expected cache info")
+	}
+	assert.Equal(t, uint64(10), info.BlockHeight)
+	assert.True(t, info.FromSnapshot)
+
+	//the snapshot is not used if the ledger has caught up
+	height = 10
+	svc = newService()
+	svc.initialize(getMockStub(), mspID)
+	_, err = svc.GetFromCache(channelID, key)
+	assert.Error(t, err)
+
+	//a snapshot that was tampered with is ignored
+	height = 5
+	file := getSnapshotFile(filepath.Join(dir, snapshotDirName), channelID, mspID)
+	signedBytes, e := ioutil.ReadFile(file)
+	if e != nil {
+		t.Fatalf("Cannot read snapshot %s", e)
+	}
+	signed := &api.SignedCacheSnapshot{}
+	if e := json.Unmarshal(signedBytes, signed); e != nil {
+		t.Fatalf("Cannot unmarshal snapshot %s", e)
+	}
+	signed.Snapshot = bytes.Replace(signed.Snapshot, []byte(`"BlockHeight":10`), []byte(`"BlockHeight":11`), 1)
+	signedBytes, e = json.Marshal(signed)
+	if e != nil {
+		t.Fatalf("Cannot marshal snapshot %s", e)
+	}
+	if e := ioutil.WriteFile(file, signedBytes, 0600); e != nil {
+		t.Fatalf("Cannot write snapshot %s", e)
+	}
+	svc = newService()
+	svc.initialize(getMockStub(), mspID)
+	_, err = svc.GetFromCache(channelID, key)
+	assert.Error(t, err)
+
+	//the snapshot is updated with the configs and the block height applied by an update
+	height = 10
+	svc = newService()
+	svc.initialize(stub, mspID)
+	<-stub.ChaincodeEventsChannel
+	if _, err := uplaodConfigToHL(t, stub, `{"MspID":"msp.one","Apps":[{"AppName":"snapshotApp","Version":"1","Config":"v2"}]}`); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	height = 12
+	if err := svc.Update(stub, getConfigEvent(t, stub)); err != nil {
+		t.Fatalf("Update returned error %s", err)
+	}
+	snapshot, err := svc.readSnapshot(channelID, mspID)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Cannot read snapshot %v", err)
+	}
+	assert.Equal(t, uint64(12), snapshot.BlockHeight)
+	assert.Equal(t, 1, len(snapshot.Configs))
+	assert.Equal(t, key, snapshot.Configs[0].Key)
+	assert.Equal(t, "v2", string(snapshot.Configs[0].Value))
+}
+
+func TestCacheLimits(t *testing.T) {
+	csi := newConfigService()
+	channel := "limitsChannel"
+	keyA := api.ConfigKey{MspID: mspID, AppName: "appA", AppVersion: "1"}
+	keyB := api.ConfigKey{MspID: mspID, AppName: "appB", AppVersion: "1"}
+	compKey := api.ConfigKey{MspID: mspID, AppName: "compApp", AppVersion: "1", ComponentName: "comp1", ComponentVersion: "1"}
+	compBytes, err := json.Marshal(&api.ComponentConfig{Name: "comp1", Version: "1", Config: "comp config"})
+	if err != nil {
+		t.Fatalf("Cannot marshal component %s", err)
+	}
+	configs := []*api.ConfigKV{{Key: keyA, Value: []byte("0123456789")}, {Key: keyB, Value: []byte("9876543210")}, {Key: compKey, Value: compBytes}}
+	if err := csi.refreshCache(channel, configs, mspID, api.CacheInfo{}); err != nil {
+		t.Fatalf("refreshCache returned error %s", err)
+	}
+
+	//the least recently read config is evicted when the cache is too big
+	time.Sleep(time.Millisecond)
+	if _, err := csi.GetFromCache(channel, keyA); err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	if _, err := csi.GetFromCache(channel, compKey); err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	maxBytes := csi.cacheSizes[channel] - 1
+	csi.SetCacheLimits(channel, maxBytes, 0)
+	_, err = csi.GetFromCache(channel, keyA)
+	assert.NoError(t, err)
+	keyStrB, err := mgmt.ConfigKeyToString(keyB)
+	if err != nil {
+		t.Fatalf("ConfigKeyToString returned error %s", err)
+	}
+	assert.True(t, csi.isEvicted(channel, mspID, keyStrB))
+	//the ledger isn't available so the evicted config cannot be loaded back
+	_, err = csi.GetFromCache(channel, keyB)
+	assert.Error(t, err)
+
+	//an evicted config that's loaded from the ledger is added back to the cache
+	time.Sleep(time.Millisecond)
+	csi.reloadEvicted(channel, keyB, keyStrB, []byte("9876543210"), []byte("9876543210"))
+	value, err := csi.GetFromCache(channel, keyB)
+	assert.NoError(t, err)
+	assert.Equal(t, "9876543210", string(value))
+	assert.True(t, csi.cacheSizes[channel] <= maxBytes)
+
+	//configs that haven't been read within the TTL are evicted but the component versions aren't
+	time.Sleep(time.Millisecond)
+	csi.SetCacheLimits(channel, 0, time.Nanosecond)
+	_, err = csi.GetFromCache(channel, keyB)
+	assert.Error(t, err)
+	value, err = csi.GetFromCache(channel, api.ConfigKey{MspID: mspID, AppName: "compApp", AppVersion: "1", ComponentName: "comp1"})
+	assert.NoError(t, err)
+	var comps []*api.ComponentConfig
+	if err := json.Unmarshal(value, &comps); err != nil {
+		t.Fatalf("Cannot unmarshal components %s", err)
+	}
+	assert.Equal(t, 1, len(comps))
+}
+
+func TestCacheSize(t *testing.T) {
+	csi := newConfigService()
+	channel := "sizeChannel"
+	keyA := api.ConfigKey{MspID: mspID, AppName: "appA", AppVersion: "1"}
+	keyB := api.ConfigKey{MspID: mspID, AppName: "appB", AppVersion: "1"}
+	configs := []*api.ConfigKV{{Key: keyA, Value: []byte("0123456789")}, {Key: keyB, Value: []byte("9876543210")}}
+	if err := csi.refreshCache(channel, configs, mspID, api.CacheInfo{}); err != nil {
+		t.Fatalf("refreshCache returned error %s", err)
+	}
+	size := csi.cacheSizes[channel]
+	assert.Equal(t, csi.getCache(channel, mspID).size(), size)
+
+	//nothing is evicted within the limit
+	csi.SetCacheLimits(channel, size, 0)
+	assert.Equal(t, size, csi.cacheSizes[channel])
+	assert.Equal(t, 2, len(csi.getCache(channel, mspID)))
+
+	time.Sleep(time.Millisecond)
+	if _, err := csi.GetFromCache(channel, keyA); err != nil {
+		t.Fatalf("GetFromCache returned error %s", err)
+	}
+	csi.SetCacheLimits(channel, size-1, 0)
+	assert.True(t, csi.cacheSizes[channel] <= size-1)
+	assert.Equal(t, csi.getCache(channel, mspID).size(), csi.cacheSizes[channel])
+
+	//the running size includes the configs that are loaded back from the ledger
+	csi.SetCacheLimits(channel, 0, 0)
+	keyStrB, err := mgmt.ConfigKeyToString(keyB)
+	if err != nil {
+		t.Fatalf("ConfigKeyToString returned error %s", err)
+	}
+	evictedSize := csi.cacheSizes[channel]
+	csi.reloadEvicted(channel, keyB, keyStrB, []byte("9876543210"), []byte("9876543210"))
+	assert.Equal(t, evictedSize+10, csi.cacheSizes[channel])
+	assert.Equal(t, csi.getCache(channel, mspID).size(), csi.cacheSizes[channel])
+}
+
+func TestGetConfigsFromLedger(t *testing.T) {
+	csi := newConfigService()
+	keyA := api.ConfigKey{MspID: mspID, AppName: "ledgerAppA", AppVersion: "1"}
+	keyB := api.ConfigKey{MspID: mspID, AppName: "ledgerAppB", AppVersion: "1"}
+	keyStrA, err := mgmt.ConfigKeyToString(keyA)
+	if err != nil {
+		t.Fatalf("ConfigKeyToString returned error %s", err)
+	}
+	reader := &mockStateReader{state: map[string][]byte{keyStrA: []byte("configA")}}
+	csi.stateReader = func(channelID string) (stateReader, errors.Error) {
+		return reader, nil
+	}
+
+	configs, err := csi.GetConfigsFromLedger(channelID, []api.ConfigKey{keyA, keyB})
+	if err != nil {
+		t.Fatalf("GetConfigsFromLedger returned error %s", err)
+	}
+	assert.Equal(t, [][]byte{[]byte("configA"), nil}, configs)
+	assert.Equal(t, 1, reader.reads)
+	assert.True(t, reader.done)
+
+	config, dirty, err := csi.GetConfigFromLedger(channelID, keyA)
+	if err != nil {
+		t.Fatalf("GetConfigFromLedger returned error %s", err)
+	}
+	assert.Equal(t, "configA", string(config))
+	assert.True(t, dirty)
+
+	reader.err = fmt.Errorf("ledger error")
+	_, err = csi.GetConfigsFromLedger(channelID, []api.ConfigKey{keyA, keyB})
+	assert.Error(t, err)
+}
+
+type mockStateReader struct {
+	state map[string][]byte
+	err   error
+	reads int
+	done  bool
+}
+
+func (r *mockStateReader) GetStateMultipleKeys(namespace string, keys []string) ([][]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.reads++
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = r.state[key]
+	}
+	return values, nil
+}
+
+func (r *mockStateReader) Done() {
+	r.done = true
+}
+
+type mockSigner struct {
+	key []byte
+}
+
+func (s *mockSigner) Sign(msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+func (s *mockSigner) Verify(msg []byte, sig []byte) error {
+	expected, _ := s.Sign(msg)
+	if !hmac.Equal(expected, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func getConfigEvent(t *testing.T, stub *mockstub.MockStub) *api.ConfigEvent {
+	ccEvent := <-stub.ChaincodeEventsChannel
+	event := &api.ConfigEvent{}
+	if err := json.Unmarshal(ccEvent.Payload, event); err != nil {
+		t.Fatalf("Cannot unmarshal event payload %s", err)
+	}
+	return event
+}
+
+//blockingStub blocks reads of the state until it is released
+type blockingStub struct {
+	*mockstub.MockStub
+	once    sync.Once
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStub) GetState(key string) ([]byte, error) {
+	s.once.Do(func() { close(s.reading) })
+	<-s.release
+	return s.MockStub.GetState(key)
+}
+
 //uplaodConfigToHL to upload key&config to repository
 func uplaodConfigToHL(t *testing.T, stub *mockstub.MockStub, message string) ([]*api.ConfigKV, error) {
 	configManager := mgmt.NewConfigManager(stub)
@@ -0,0 +1,37 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package service
+
+import (
+	"github.com/hyperledger/fabric/core/peer"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+//configSnapNamespace is the namespace of the configs in the ledger
+const configSnapNamespace = "configurationsnap"
+
+//stateReader reads state from the ledger. It is satisfied by the ledger's query executor.
+type stateReader interface {
+	GetStateMultipleKeys(namespace string, keys []string) ([][]byte, error)
+	Done()
+}
+
+//newQueryExecutor returns a read-only query executor on the ledger of the given channel.
+//Unlike a transaction simulator, a query executor doesn't need a transaction ID. It takes the same
+//read lock on commits as a transaction simulator, which is held until Done is called, so it must
+//be released as soon as the keys are read.
+func newQueryExecutor(channelID string) (stateReader, errors.Error) {
+	lgr := peer.GetLedger(channelID)
+	if lgr == nil {
+		return nil, errors.Errorf(errors.SystemError, "Cannot obtain ledger for channel %s", channelID)
+	}
+	qe, err := lgr.NewQueryExecutor()
+	if err != nil {
+		return nil, errors.WithMessage(errors.SystemError, err, "Cannot create query executor")
+	}
+	return qe, nil
+}
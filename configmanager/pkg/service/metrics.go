@@ -17,16 +17,48 @@ var (
 		Name:      "refresh_duration",
 		Help:      "The config refresh duration.",
 	}
+	cacheHits = fabricmetrics.CounterOpts{
+		Namespace: "snap",
+		Subsystem: "config_service",
+		Name:      "cache_hits",
+		Help:      "The number of configs that were found in the cache.",
+	}
+	cacheMisses = fabricmetrics.CounterOpts{
+		Namespace: "snap",
+		Subsystem: "config_service",
+		Name:      "cache_misses",
+		Help:      "The number of configs that were not found in the cache.",
+	}
+	cacheEvictions = fabricmetrics.CounterOpts{
+		Namespace: "snap",
+		Subsystem: "config_service",
+		Name:      "cache_evictions",
+		Help:      "The number of configs that were evicted from the cache.",
+	}
+	cacheSize = fabricmetrics.GaugeOpts{
+		Namespace: "snap",
+		Subsystem: "config_service",
+		Name:      "cache_size",
+		Help:      "The number of bytes of cached configs.",
+	}
 )
 
 //Metrics contain graphs
 type Metrics struct {
-	RefreshTimer fabricmetrics.Histogram
+	RefreshTimer   fabricmetrics.Histogram
+	CacheHits      fabricmetrics.Counter
+	CacheMisses    fabricmetrics.Counter
+	CacheEvictions fabricmetrics.Counter
+	CacheSize      fabricmetrics.Gauge
 }
 
 //NewMetrics create new instance of metrics
 func NewMetrics(p fabricmetrics.Provider) *Metrics {
 	return &Metrics{
-		RefreshTimer: p.NewHistogram(refreshTimer),
+		RefreshTimer:   p.NewHistogram(refreshTimer),
+		CacheHits:      p.NewCounter(cacheHits),
+		CacheMisses:    p.NewCounter(cacheMisses),
+		CacheEvictions: p.NewCounter(cacheEvictions),
+		CacheSize:      p.NewGauge(cacheSize),
 	}
 }
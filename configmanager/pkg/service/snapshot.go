@@ -0,0 +1,255 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/peer"
+	mspmgmt "github.com/hyperledger/fabric/msp/mgmt"
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+//snapshotDirName is the directory (under the peer's file system path) where cache snapshots are stored
+const snapshotDirName = "configsnapshots"
+
+//snapshotSigner signs and verifies cache snapshots
+type snapshotSigner interface {
+	Sign(msg []byte) ([]byte, error)
+	Verify(msg []byte, sig []byte) error
+}
+
+//getSnapshotSigner returns the signing identity of the peer
+func getSnapshotSigner() (snapshotSigner, error) {
+	signingIdentity, err := mspmgmt.GetLocalMSP().GetDefaultSigningIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return signingIdentity, nil
+}
+
+//getBlockHeight returns the height of the ledger of the given channel
+func getBlockHeight(channelID string) (uint64, error) {
+	lgr := peer.GetLedger(channelID)
+	if lgr == nil {
+		return 0, errors.Errorf(errors.SystemError, "Cannot obtain ledger for channel %s", channelID)
+	}
+	info, err := lgr.GetBlockchainInfo()
+	if err != nil {
+		return 0, err
+	}
+	return info.Height, nil
+}
+
+//getSnapshotDir returns the directory where cache snapshots are stored or an empty string if
+//the peer's file system path isn't configured, in which case snapshots are disabled
+func (csi *ConfigServiceImpl) getSnapshotDir() string {
+	peerConfig, err := csi.peerConfig()
+	if err != nil {
+		logger.Warnf("Failed to get peer config. Cache snapshots are disabled: %s", err)
+		return ""
+	}
+	fileSystemPath := peerConfig.GetString("peer.fileSystemPath")
+	if fileSystemPath == "" {
+		return ""
+	}
+	return filepath.Join(fileSystemPath, snapshotDirName)
+}
+
+func getSnapshotFile(dir, channelID, mspID string) string {
+	return filepath.Join(dir, channelID+"_"+mspID+".json")
+}
+
+//writeSnapshot writes a signed snapshot of the given configs of the MSP to disk
+func (csi *ConfigServiceImpl) writeSnapshot(channelID, mspID string, info api.CacheInfo, configs []*api.ConfigKV) errors.Error {
+	dir := csi.getSnapshotDir()
+	if dir == "" {
+		return nil
+	}
+
+	snapshotBytes, err := json.Marshal(&api.CacheSnapshot{ChannelID: channelID, MspID: mspID, CacheInfo: info, Configs: configs})
+	if err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to marshal cache snapshot")
+	}
+	signer, err := csi.snapshotSigner()
+	if err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to get signer for cache snapshot")
+	}
+	signature, err := signer.Sign(snapshotBytes)
+	if err != nil {
+		return errors.Wrap(errors.CryptoError, err, "Failed to sign cache snapshot")
+	}
+	signedBytes, err := json.Marshal(&api.SignedCacheSnapshot{Snapshot: snapshotBytes, Signature: signature})
+	if err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to marshal signed cache snapshot")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(errors.SystemError, err, "Failed to create snapshot directory %s", dir)
+	}
+	//write to a temporary file first so that a partially written snapshot is never loaded
+	file := getSnapshotFile(dir, channelID, mspID)
+	if err := ioutil.WriteFile(file+".tmp", signedBytes, 0600); err != nil {
+		return errors.Wrapf(errors.SystemError, err, "Failed to write cache snapshot %s", file)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return errors.Wrapf(errors.SystemError, err, "Failed to write cache snapshot %s", file)
+	}
+	logger.Debugf("Wrote cache snapshot for MSP [%s] on channel [%s] at block height %d\n", mspID, channelID, info.BlockHeight)
+	return nil
+}
+
+//updateSnapshot writes the snapshot of the cached configs of the MSP, stamped with the block height of the cache, after
+//the given updates were applied to the cache with the given (previous) ledger hashes. The configs of the previous
+//snapshot are updated if the snapshot matches the previous hashes, otherwise the configs are read from the ledger.
+func (csi *ConfigServiceImpl) updateSnapshot(stub shim.ChaincodeStubInterface, mspID string, hashes map[string]string, updates []*configUpdate) errors.Error {
+	channelID := stub.GetChannelID()
+	if csi.getSnapshotDir() == "" {
+		return nil
+	}
+	info, ok := csi.GetCacheInfo(channelID, mspID)
+	if !ok {
+		return nil
+	}
+	info.FromSnapshot = false
+
+	configs := csi.applyToSnapshot(channelID, mspID, hashes, updates)
+	if configs == nil {
+		var err errors.Error
+		configs, err = mgmt.NewConfigManager(stub).Get(api.ConfigKey{MspID: mspID})
+		if err != nil {
+			return err
+		}
+	}
+	return csi.writeSnapshot(channelID, mspID, *info, configs)
+}
+
+//applyToSnapshot returns the configs of the snapshot of the MSP with the given updates applied, or nil if there is
+//no snapshot or if its configs don't match the given ledger hashes of the cache before the updates
+func (csi *ConfigServiceImpl) applyToSnapshot(channelID, mspID string, hashes map[string]string, updates []*configUpdate) []*api.ConfigKV {
+	snapshot, err := csi.readSnapshot(channelID, mspID)
+	if err != nil {
+		logger.Debugf("Ignoring cache snapshot for MSP [%s] on channel [%s]: %s\n", mspID, channelID, err)
+		return nil
+	}
+	if snapshot == nil || len(snapshot.Configs) != len(hashes) {
+		return nil
+	}
+
+	configs := make(map[string]*api.ConfigKV, len(snapshot.Configs))
+	for _, config := range snapshot.Configs {
+		keyStr, err := mgmt.ConfigKeyToString(config.Key)
+		if err != nil || hashes[keyStr] != mgmt.GenerateHash(config.Value) {
+			return nil
+		}
+		configs[keyStr] = config
+	}
+	for _, update := range updates {
+		if len(update.ledgerValue) == 0 {
+			delete(configs, update.keyStr)
+		} else {
+			configs[update.keyStr] = &api.ConfigKV{Key: update.change.Key, Value: update.ledgerValue}
+		}
+	}
+
+	keyStrs := make([]string, 0, len(configs))
+	for keyStr := range configs {
+		keyStrs = append(keyStrs, keyStr)
+	}
+	sort.Strings(keyStrs)
+	result := make([]*api.ConfigKV, len(keyStrs))
+	for i, keyStr := range keyStrs {
+		result[i] = configs[keyStr]
+	}
+	return result
+}
+
+//readSnapshot reads and verifies the snapshot of the cached configs of the MSP.
+//Nil is returned if there is no snapshot.
+func (csi *ConfigServiceImpl) readSnapshot(channelID, mspID string) (*api.CacheSnapshot, errors.Error) {
+	dir := csi.getSnapshotDir()
+	if dir == "" {
+		return nil, nil
+	}
+	file := getSnapshotFile(dir, channelID, mspID)
+	signedBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(errors.SystemError, err, "Failed to read cache snapshot %s", file)
+	}
+
+	signed := &api.SignedCacheSnapshot{}
+	if err := json.Unmarshal(signedBytes, signed); err != nil {
+		return nil, errors.Wrapf(errors.UnmarshalError, err, "Failed to unmarshal cache snapshot %s", file)
+	}
+	signer, err := csi.snapshotSigner()
+	if err != nil {
+		return nil, errors.WithMessage(errors.SystemError, err, "Failed to get signer for cache snapshot")
+	}
+	if err := signer.Verify(signed.Snapshot, signed.Signature); err != nil {
+		return nil, errors.Wrapf(errors.CryptoError, err, "Invalid signature for cache snapshot %s", file)
+	}
+	snapshot := &api.CacheSnapshot{}
+	if err := json.Unmarshal(signed.Snapshot, snapshot); err != nil {
+		return nil, errors.Wrapf(errors.UnmarshalError, err, "Failed to unmarshal cache snapshot %s", file)
+	}
+	if snapshot.ChannelID != channelID || snapshot.MspID != mspID {
+		return nil, errors.Errorf(errors.InvalidConfigDataError, "Cache snapshot %s is for MSP [%s] on channel [%s]", file, snapshot.MspID, snapshot.ChannelID)
+	}
+	return snapshot, nil
+}
+
+//loadSnapshot loads the cache of the MSP from its snapshot if the snapshot is more recent than the ledger,
+//e.g. because the ledger is still catching up. True is returned if the cache was loaded.
+func (csi *ConfigServiceImpl) loadSnapshot(channelID, mspID string) bool {
+	snapshot, err := csi.readSnapshot(channelID, mspID)
+	if err != nil {
+		logger.Warnf("Ignoring cache snapshot for MSP [%s] on channel [%s]: %s", mspID, channelID, err)
+		return false
+	}
+	if snapshot == nil {
+		return false
+	}
+	height, e := csi.blockHeight(channelID)
+	if e != nil {
+		logger.Warnf("Failed to get block height of channel [%s]: %s", channelID, e)
+	}
+	if e == nil && height >= snapshot.BlockHeight {
+		logger.Debugf("Ledger at height %d is not behind the cache snapshot for MSP [%s] on channel [%s] at height %d\n", height, mspID, channelID, snapshot.BlockHeight)
+		return false
+	}
+
+	info := snapshot.CacheInfo
+	info.FromSnapshot = true
+	if err := csi.refreshCache(channelID, snapshot.Configs, mspID, info); err != nil {
+		logger.Warnf("Failed to load cache snapshot for MSP [%s] on channel [%s]: %s", mspID, channelID, err)
+		return false
+	}
+	logger.Infof("Loaded cache snapshot for MSP [%s] on channel [%s] at block height %d", mspID, channelID, snapshot.BlockHeight)
+	return true
+}
+
+//GetCacheInfo returns information about the cached configs of the given MSP on the given channel
+func (csi *ConfigServiceImpl) GetCacheInfo(channelID string, mspID string) (*api.CacheInfo, bool) {
+	csi.mtx.RLock()
+	defer csi.mtx.RUnlock()
+	info, ok := csi.cacheInfo[channelID+"_"+mspID]
+	if !ok {
+		return nil, false
+	}
+	infoCopy := *info
+	return &infoCopy, true
+}
@@ -0,0 +1,110 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package service
+
+import (
+	"sync"
+
+	"github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+)
+
+//subscriptionBufferSize is the number of changes that are buffered for a subscriber
+const subscriptionBufferSize = 100
+
+//subscription is a subscription to changes of the cached configs that match a filter
+type subscription struct {
+	channelID string
+	filter    api.ConfigKey
+	changes   chan api.ConfigChange
+	once      sync.Once
+}
+
+//subscriptions contains the subscriptions to config changes
+type subscriptions struct {
+	mtx  sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subs: make(map[*subscription]struct{})}
+}
+
+//Subscribe returns a channel which receives a ConfigChange whenever the cached config of a key that matches
+//the given filter is added, updated or removed on the given channel. Empty fields of the filter match any value.
+//The returned function unsubscribes and closes the channel.
+func (csi *ConfigServiceImpl) Subscribe(channelID string, filter api.ConfigKey) (<-chan api.ConfigChange, func()) {
+	sub := &subscription{
+		channelID: channelID,
+		filter:    filter,
+		changes:   make(chan api.ConfigChange, subscriptionBufferSize),
+	}
+
+	csi.subscriptions.mtx.Lock()
+	csi.subscriptions.subs[sub] = struct{}{}
+	csi.subscriptions.mtx.Unlock()
+
+	unsubscribe := func() {
+		sub.once.Do(func() {
+			csi.subscriptions.mtx.Lock()
+			delete(csi.subscriptions.subs, sub)
+			close(sub.changes)
+			csi.subscriptions.mtx.Unlock()
+		})
+	}
+	return sub.changes, unsubscribe
+}
+
+//notify sends the given changes to the subscribers whose filters match
+func (csi *ConfigServiceImpl) notify(channelID string, changes []api.ConfigChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	csi.subscriptions.mtx.RLock()
+	defer csi.subscriptions.mtx.RUnlock()
+
+	for sub := range csi.subscriptions.subs {
+		if sub.channelID != channelID {
+			continue
+		}
+		for _, change := range changes {
+			if !mgmt.MatchesKey(sub.filter, change.Key) {
+				continue
+			}
+			select {
+			case sub.changes <- change:
+			default:
+				logger.Warnf("Dropping config change for key [%s] on channel [%s] since the subscriber is not keeping up", change.Key.String(), channelID)
+			}
+		}
+	}
+}
+
+//getChanges returns the changes between the given hashes of the configs of a cache
+func getChanges(oldHashes, newHashes map[string]string) []api.ConfigChange {
+	var changes []api.ConfigChange
+	addChange := func(keyStr, oldHash, newHash string) {
+		key, err := mgmt.StringToConfigKey(keyStr)
+		if err != nil {
+			logger.Warnf("Invalid key [%s] in cache: %s", keyStr, err)
+			return
+		}
+		changes = append(changes, api.ConfigChange{Key: key, OldHash: oldHash, NewHash: newHash})
+	}
+	for keyStr, newHash := range newHashes {
+		if oldHash := oldHashes[keyStr]; oldHash != newHash {
+			addChange(keyStr, oldHash, newHash)
+		}
+	}
+	for keyStr, oldHash := range oldHashes {
+		if _, ok := newHashes[keyStr]; !ok {
+			addChange(keyStr, oldHash, "")
+		}
+	}
+	return changes
+}
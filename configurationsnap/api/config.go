@@ -6,6 +6,8 @@ SPDX-License-Identifier: Apache-2.0
 
 package api
 
+import "time"
+
 const (
 	// ConfigCCEventName is the name of the chaincode event that is published to
 	// indicate that the configuration has changed
@@ -13,6 +15,37 @@ const (
 
 	//GeneralMspID is the msp id of generic config
 	GeneralMspID = "general"
+
+	//SchemaAppName is the app under the general MSP whose components hold the JSON schemas of app configs.
+	//The component name is the name of the app (or app/component) and the component version is the app version.
+	SchemaAppName = "configschema"
+
+	//SchemaAppVersion is the version of the schema app
+	SchemaAppVersion = "1"
+
+	//ConfigEncryptionKeyAppName is the app under the general MSP whose config is the PublicKeyForConfigEncryption.
+	//It has to be set up by an administrator: generate a non-ephemeral RSA key pair with the generateKeyPair function
+	//of the configuration snap on a peer whose BCCSP provider is SW, copy the private key file (<SKI>_sk) into the
+	//key store of every peer that reads encrypted configs and save the public key and SKI under this app.
+	//Encrypted configs can only be read on peers whose BCCSP provider is SW since the other providers can't decrypt
+	//with RSA keys.
+	ConfigEncryptionKeyAppName = "configencryptionkey"
+
+	//ConfigEncryptionKeyAppVersion is the version of the config encryption key app
+	ConfigEncryptionKeyAppVersion = "1"
+
+	//VariablesAppName is the app (of an MSP) whose config is a JSON object of the variables used in templated configs
+	VariablesAppName = "configvariables"
+
+	//VariablesAppVersion is the version of the variables app
+	VariablesAppVersion = "1"
+
+	//ClientIdentitiesAppName is the app (of a peer) whose config is a JSON object of the client identities that were
+	//imported into the configuration snap, keyed by the name of the identity (see ClientIdentity)
+	ClientIdentitiesAppName = "clientidentities"
+
+	//ClientIdentitiesAppVersion is the version of the client identities app
+	ClientIdentitiesAppVersion = "1"
 )
 
 // PublicKeyForLogging is public key and key id combination used for private logging
@@ -23,3 +56,45 @@ type PublicKeyForLogging struct {
 	// KeyID is the key ID used for private logging
 	KeyID string `json:"keyid,omitempty"`
 }
+
+// PublicKeyForConfigEncryption is public key and key id combination used to encrypt configs
+type PublicKeyForConfigEncryption struct {
+	// PublicKey is the PEM encoded RSA public key used to encrypt configs
+	PublicKey string `json:"publickey,omitempty"`
+
+	// KeyID is the (hex encoded) SKI of the RSA private key in the key store of the peers' SW BCCSP
+	KeyID string `json:"keyid,omitempty"`
+}
+
+// KeyInfo is the metadata of a non-ephemeral key that was generated by the configuration snap
+type KeyInfo struct {
+	// SKI is the hex encoded subject key identifier of the key
+	SKI string `json:"ski"`
+
+	// KeyType is the type of the key (e.g. ECDSA, RSA2048)
+	KeyType string `json:"keyType"`
+
+	// Label is the label that was given to the key when it was generated
+	Label string `json:"label,omitempty"`
+
+	// Created is the time when the key was generated
+	Created time.Time `json:"created"`
+
+	// CreatorMspID is the MSP of the client that generated the key
+	CreatorMspID string `json:"creatorMspID,omitempty"`
+
+	// ChannelID is the channel on which the key was generated
+	ChannelID string `json:"channelID"`
+}
+
+// ClientIdentity binds a certificate to a key of the peer that was generated by the configuration snap
+type ClientIdentity struct {
+	// SKI is the hex encoded subject key identifier of the key
+	SKI string `json:"ski"`
+
+	// Cert is the PEM encoded certificate followed by the intermediate certificates of its chain
+	Cert string `json:"cert"`
+
+	// NotAfter is the time when the certificate expires
+	NotAfter time.Time `json:"notAfter"`
+}
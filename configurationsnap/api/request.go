@@ -0,0 +1,122 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package api
+
+import (
+	"encoding/json"
+
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+)
+
+//RequestVersion is the version of the Request and Response of the configuration snap functions
+const RequestVersion = "1"
+
+//Request is the versioned JSON request of a configuration snap function. It is passed as the only arg of the
+//function (after the function name). Each function uses only the fields it needs.
+type Request struct {
+	//Version (mandatory) is the version of the request (RequestVersion)
+	Version string `json:"version"`
+
+	//Key is the config key of get, getFromCache, getHistory, getAsOf, delete, rollback and reindex (optional)
+	Key *mgmtapi.ConfigKey `json:"key,omitempty"`
+	//PageOptions (optional) are the page options of get. If provided then a page of the configs matching the key is returned.
+	PageOptions *mgmtapi.PageOptions `json:"pageOptions,omitempty"`
+	//TxID is the transaction of getAsOf and rollback
+	TxID string `json:"txID,omitempty"`
+
+	//Config is the config message (or batch of config messages) of save
+	Config json.RawMessage `json:"config,omitempty"`
+
+	//MspIDs are the MSPs whose caches are refreshed by refresh
+	MspIDs []string `json:"mspIDs,omitempty"`
+	//Event (optional) is the config event of refresh, in which case only the changed configs are refreshed
+	Event *mgmtapi.ConfigEvent `json:"event,omitempty"`
+
+	//Mode (optional) is the mode of cacheStatus
+	Mode string `json:"mode,omitempty"`
+
+	//KeyType is the type of the key generated by generateKeyPair and generateCSR (e.g. ECDSA, RSA2048)
+	KeyType string `json:"keyType,omitempty"`
+	//Ephemeral is true if the key generated by generateKeyPair and generateCSR is not stored. Non-ephemeral keys of the
+	//PKCS11 provider can only be generated if the snap is built with the pkcs11 tag.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	//SignatureAlgorithm is the signature algorithm of the CSR (e.g. ECDSAWithSHA256)
+	SignatureAlgorithm string `json:"signatureAlgorithm,omitempty"`
+	//CommonName is the common name of the subject of the CSR
+	CommonName string `json:"commonName,omitempty"`
+	//Label (optional) is the label of the non-ephemeral key generated by generateKeyPair and generateCSR
+	Label string `json:"label,omitempty"`
+
+	//Subject (optional) overrides subject fields of the CSR that are otherwise taken from the csr config
+	Subject *CSRSubject `json:"subject,omitempty"`
+	//URIs (optional) are URI subject alternative names of the CSR (in addition to the configured ones)
+	URIs []string `json:"uris,omitempty"`
+	//KeyUsage (optional) are the key usages of the CSR (e.g. digitalSignature, keyEncipherment)
+	KeyUsage []string `json:"keyUsage,omitempty"`
+	//ExtKeyUsage (optional) are the extended key usages of the CSR (e.g. serverAuth, clientAuth)
+	ExtKeyUsage []string `json:"extKeyUsage,omitempty"`
+	//Extensions (optional) are extra extensions of the CSR
+	Extensions []CSRExtension `json:"extensions,omitempty"`
+
+	//SKI is the hex encoded subject key identifier of the key of getPublicKey, deleteKey, sign and verify.
+	//It is optional for importCertificate, in which case it must match the key of the certificate if provided.
+	SKI string `json:"ski,omitempty"`
+	//DigestAlgorithm (optional) is the digest algorithm of sign and verify (SHA256, SHA384, SHA3_256 or SHA3_384).
+	//The default is SHA256.
+	DigestAlgorithm string `json:"digestAlgorithm,omitempty"`
+	//Data is the data that is signed by sign or whose signature is verified by verify
+	Data []byte `json:"data,omitempty"`
+	//Signature is the signature that is verified by verify
+	Signature []byte `json:"signature,omitempty"`
+
+	//Name is the name of the client identity of importCertificate
+	Name string `json:"name,omitempty"`
+	//PeerID is the peer whose client identity is set by importCertificate. The key of the certificate is local to the
+	//peer that generated it, so only that peer checks the key. Other endorsers of the request only verify the certificate.
+	PeerID string `json:"peerID,omitempty"`
+	//MspID (optional) is the MSP of the peer of importCertificate. The default is the MSP of the endorsing peer, so it
+	//must be set if the request is endorsed by peers of other MSPs.
+	MspID string `json:"mspID,omitempty"`
+	//Certificate is the PEM encoded certificate of importCertificate, optionally followed by the intermediate
+	//certificates of its chain
+	Certificate string `json:"certificate,omitempty"`
+}
+
+//CSRSubject contains the subject fields of a CSR that can be set per request. Empty fields are taken from the
+//csr config.
+type CSRSubject struct {
+	Country       string `json:"country,omitempty"`
+	StateProvince string `json:"stateProvince,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Org           string `json:"org,omitempty"`
+	OrgUnit       string `json:"orgUnit,omitempty"`
+}
+
+//CSRExtension is an extra extension of a CSR
+type CSRExtension struct {
+	//ID is the OID of the extension in dotted notation (e.g. 1.2.3.4)
+	ID string `json:"id"`
+	//Critical is true if the extension is critical
+	Critical bool `json:"critical,omitempty"`
+	//Value is the DER encoded value of the extension
+	Value []byte `json:"value"`
+}
+
+//Response is the typed response envelope of a configuration snap function that was invoked with a Request.
+//The envelope is the payload of the chaincode response, both on success and on failure.
+type Response struct {
+	//Version is the version of the response (RequestVersion)
+	Version string `json:"version"`
+	//Payload is the result of the function
+	Payload []byte `json:"payload,omitempty"`
+	//ErrorCode is the code of the error if the function failed
+	ErrorCode string `json:"errorCode,omitempty"`
+	//ErrorID identifies the error in the snap's log if the function failed
+	ErrorID string `json:"errorID,omitempty"`
+	//Message is the error message if the function failed
+	Message string `json:"message,omitempty"`
+}
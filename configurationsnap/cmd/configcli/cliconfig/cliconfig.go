@@ -99,6 +99,12 @@ const (
 	componentVerFlag        = "componentver"
 	componentVerDescription = "The component version"
 
+	pageSizeFlag        = "pagesize"
+	pageSizeDescription = "The maximum number of configs returned by a query. If specified then the results are paged"
+
+	bookmarkFlag        = "bookmark"
+	bookmarkDescription = "The bookmark returned with the previous page of query results"
+
 	noPromptFlag        = "noprompt"
 	noPromptDescription = "If specified then update and delete operations will not prompt for confirmation"
 	defaultNoPrompt     = false
@@ -139,6 +145,8 @@ type options struct {
 	appVer           string
 	componentName    string
 	componentVer     string
+	pageSize         int
+	bookmark         string
 	noPrompt         bool
 	keyType          string
 	ephemeralFlag    string
@@ -349,6 +357,26 @@ func InitComponentVer(flags *pflag.FlagSet) {
 	flags.StringVar(&opts.componentVer, componentVerFlag, "", componentVerDescription)
 }
 
+// PageSize returns the page size (used in the config query command)
+func (c *CLIConfig) PageSize() int {
+	return opts.pageSize
+}
+
+// InitPageSize initializes the page size from the provided arguments
+func InitPageSize(flags *pflag.FlagSet) {
+	flags.IntVar(&opts.pageSize, pageSizeFlag, 0, pageSizeDescription)
+}
+
+// Bookmark returns the bookmark of the page to query (used in the config query command)
+func (c *CLIConfig) Bookmark() string {
+	return opts.bookmark
+}
+
+// InitBookmark initializes the bookmark from the provided arguments
+func InitBookmark(flags *pflag.FlagSet) {
+	flags.StringVar(&opts.bookmark, bookmarkFlag, "", bookmarkDescription)
+}
+
 // KeyType returns an KeyType name (used in the config generteCSR command)
 func (c *CLIConfig) KeyType() string {
 	return opts.keyType
@@ -61,10 +61,35 @@ func Print(configBytes []byte) {
 			cliconfig.Config().Logger().Errorf("Got error while unmarshalling config: %s", err)
 			return
 		}
-		for _, config := range configs {
-			fmt.Printf("\n%s\n", lineSep)
-			fmt.Printf("----- MSPID: %s, Peer: %s, App: %s:,AppVersion: %s:,Component: %s:,ComponentVersion: %s: [%s]", config.Key.MspID, config.Key.PeerID, config.Key.AppName, config.Key.AppVersion, config.Key.ComponentName, config.Key.ComponentVersion, config.Value)
-			fmt.Printf("\n%s\n", lineSep)
-		}
+		printConfigs(configs)
+	}
+}
+
+// PrintPage prints the given query result bytes, which is a marshaled ConfigQueryResult
+func PrintPage(resultBytes []byte) {
+	if AsOutputFormat(cliconfig.Config().OutputFormat()) == RawOutput {
+		fmt.Printf("\n%s\n%s\n", lineSep, resultBytes)
+		return
+	}
+	result := mgmtapi.ConfigQueryResult{}
+	if err := json.Unmarshal(resultBytes, &result); err != nil {
+		cliconfig.Config().Logger().Errorf("Got error while unmarshalling query result: %s", err)
+		return
+	}
+	configs := make([]mgmtapi.ConfigKV, len(result.Configs))
+	for i, config := range result.Configs {
+		configs[i] = *config
+	}
+	printConfigs(configs)
+	if result.Bookmark != "" {
+		fmt.Printf("\n----- Bookmark for next page: %s\n", result.Bookmark)
+	}
+}
+
+func printConfigs(configs []mgmtapi.ConfigKV) {
+	for _, config := range configs {
+		fmt.Printf("\n%s\n", lineSep)
+		fmt.Printf("----- MSPID: %s, Peer: %s, App: %s:,AppVersion: %s:,Component: %s:,ComponentVersion: %s: [%s]", config.Key.MspID, config.Key.PeerID, config.Key.AppName, config.Key.AppVersion, config.Key.ComponentName, config.Key.ComponentVersion, config.Value)
+		fmt.Printf("\n%s\n", lineSep)
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 
 	"github.com/pkg/errors"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/action"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/cliconfig"
 	"github.com/spf13/cobra"
@@ -30,6 +31,10 @@ The Config Key may be specified as a JSON string (using the --configkey option)
 be specified using the options: --mspid, --peerid, --appname, --appver, --componentname and --componentver
 
 If PeerID and AppName are not specified then all of the org's configuration is returned.
+
+Large result sets may be paged using the --pagesize option. In this case the MspID, PeerID, AppName and
+ComponentName of the Config Key are used as filters and a bookmark is displayed if there are more results.
+The next page is retrieved by passing the bookmark using the --bookmark option.
 `
 
 const examples = `
@@ -63,6 +68,12 @@ const examples = `
 - Query a single peer for all configuration for Org1MSP:
     $ ./configcli query --clientconfig ../../../bddtests/fixtures/clientconfig/config.yaml --cid mychannel --peerurl grpcs://localhost:7051 --mspid Org1MSP
 
+- Query a single peer for the configuration of an app, ten configs at a time:
+    $ ./configcli query --clientconfig ../../../bddtests/fixtures/clientconfig/config.yaml --cid mychannel --peerurl grpcs://localhost:7051 --mspid Org1MSP --appname myapp --pagesize 10
+
+- Query the next page using the bookmark displayed with the previous page:
+    $ ./configcli query --clientconfig ../../../bddtests/fixtures/clientconfig/config.yaml --cid mychannel --peerurl grpcs://localhost:7051 --mspid Org1MSP --appname myapp --pagesize 10 --bookmark <bookmark>
+
 - Query a single peer using a config key:
     $ ./configcli query --clientconfig ../../../bddtests/fixtures/clientconfig/config.yaml --cid mychannel --peerurl grpcs://localhost:7051 --configkey '{"MspID":"Org1MSP","PeerID":"peer0.org1.example.com","AppName":"app1","Version":"1"}'
 `
@@ -105,6 +116,8 @@ func newCmd(baseAction action.Action) *cobra.Command {
 	cliconfig.InitComponentName(flags)
 	cliconfig.InitComponentVer(flags)
 	cliconfig.InitOutputFormat(flags)
+	cliconfig.InitPageSize(flags)
+	cliconfig.InitBookmark(flags)
 
 	return cmd
 }
@@ -134,6 +147,10 @@ func (a *queryAction) query() error {
 
 	cliconfig.Config().Logger().Debugf("Using config key: [%s]\n", configKeyBytes)
 
+	if cliconfig.Config().PageSize() > 0 {
+		return a.queryPage(configKeyBytes)
+	}
+
 	response, err := a.Query(cliconfig.ConfigSnapID, "get", [][]byte{configKeyBytes})
 	if err != nil {
 		return err
@@ -143,3 +160,22 @@ func (a *queryAction) query() error {
 
 	return nil
 }
+
+func (a *queryAction) queryPage(configKeyBytes []byte) error {
+	pageOptionsBytes, err := json.Marshal(&mgmtapi.PageOptions{
+		PageSize: cliconfig.Config().PageSize(),
+		Bookmark: cliconfig.Config().Bookmark(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling page options")
+	}
+
+	response, err := a.Query(cliconfig.ConfigSnapID, "get", [][]byte{configKeyBytes, pageOptionsBytes})
+	if err != nil {
+		return err
+	}
+
+	PrintPage(response)
+
+	return nil
+}
@@ -7,9 +7,11 @@ SPDX-License-Identifier: Apache-2.0
 package querycmd
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/pkg/errors"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/action"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/cliconfig"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/configkeyutil"
@@ -18,6 +20,7 @@ import (
 const (
 	clientConfigPath = "../testdata/clientconfig/config.yaml"
 	configKV         = `[{"Key":{"MspID":"Org1MSP","PeerID":"peer0.org1.example.com","AppName":"myapp","Version":"1"},"Value":"ZW1iZWRkZWQgY29uZmln"}]`
+	configPage       = `{"Configs":[{"Key":{"MspID":"Org1MSP","PeerID":"peer0.org1.example.com","AppName":"myapp","Version":"1"},"Value":"ZW1iZWRkZWQgY29uZmln"}],"Bookmark":"Ym9va21hcms="}`
 )
 
 func TestInvalidClientConfig(t *testing.T) {
@@ -54,6 +57,12 @@ func TestValidConfigKeyOptions(t *testing.T) {
 
 }
 
+func TestQueryPage(t *testing.T) {
+	execute(t, false, []byte(configPage), "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--appname", "myapp", "--pagesize", "1")
+	execute(t, false, []byte(configPage), "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--appname", "myapp", "--pagesize", "1", "--bookmark", "Ym9va21hcms=", "--format", "raw")
+	execute(t, true, []byte(configPage), "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--pagesize", "abc")
+}
+
 func execute(t *testing.T, expectError bool, response []byte, args ...string) {
 	cmd := newCmd(newMockAction(response))
 	action.InitGlobalFlags(cmd.PersistentFlags())
@@ -87,6 +96,15 @@ func newMockAction(response []byte) *action.MockAction {
 			if key.MspID == "" {
 				return nil, errors.New("MSP ID must be provided in config key")
 			}
+			if len(args) > 1 {
+				pageOptions := &mgmtapi.PageOptions{}
+				if err := json.Unmarshal(args[1], pageOptions); err != nil {
+					return nil, errors.Wrap(err, "got error unmarshalling page options arg")
+				}
+				if pageOptions.PageSize <= 0 {
+					return nil, errors.New("page size must be provided in page options")
+				}
+			}
 			return response, nil
 		},
 	}
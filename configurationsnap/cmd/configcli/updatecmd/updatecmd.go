@@ -7,7 +7,10 @@ SPDX-License-Identifier: Apache-2.0
 package updatecmd
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -19,6 +22,8 @@ import (
 
 	"github.com/pkg/errors"
 	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/action"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/cliconfig"
 	"github.com/spf13/cobra"
@@ -97,6 +102,24 @@ The format of the configuration for peer-less config is listed below:
 }
 The configuration may be embedded direcly in the "Config" element or the Config element may reference a file containing the configuration.
 
+An app or component may optionally specify "ExpectedHash" (the base64 encoded SHA-256 hash of the currently stored config)
+and/or "ExpectedTxID" (the ID of the transaction that stored the current config). If the stored config has changed
+since then the entire update is rejected, so concurrent updates do not silently overwrite each other.
+
+An app or component with "Encrypted" set to true is encrypted before it is sent, using the public key that is saved
+under MSP "general", app "configencryptionkey", version "1". The config is decrypted by the peer with the matching RSA
+private key, which must be in the key store of the peer's SW BCCSP. Peers whose BCCSP provider is not SW (e.g. PKCS11)
+cannot read encrypted configs.
+
+An app with "Templated" set to true has a config with ${name} placeholders that are resolved by each peer when the
+config is read. A placeholder may reference peer.id, peer.localMspId or peer.address (from the peer's core.yaml) or a
+variable defined in the MSP's "configvariables" app (version "1"), whose config is a JSON object of variable names to values.
+The update is rejected if a placeholder references an undefined variable.
+
+An app or component may also specify a "ConfigType" (YAML or JSON) in which case the syntax of the config is checked on save.
+If a JSON schema is registered for the app (under MSP "general", app "configschema", version "1", with the app name
+as the component name and the app version as the component version) then the config is validated against the schema.
+
 `
 
 const examples = `
@@ -195,6 +218,10 @@ func (a *updateAction) update() error {
 		return errors.Wrap(err, "invalid config message")
 	}
 
+	if err := a.encryptConfigs(configMsg); err != nil {
+		return err
+	}
+
 	configBytes, err := json.Marshal(configMsg)
 	if err != nil {
 		return errors.Wrapf(err, "error marshalling configuration")
@@ -258,9 +285,14 @@ func updateAppConfigInfo(configMsg *mgmtapi.ConfigMessage, baseFilePath string,
 
 	for _, appConfig := range configMsg.Apps {
 		newAppConfig := mgmtapi.AppConfig{
-			AppName: appConfig.AppName,
-			Version: appConfig.Version,
-			Config:  appConfig.Config,
+			AppName:      appConfig.AppName,
+			Version:      appConfig.Version,
+			Config:       appConfig.Config,
+			ExpectedHash: appConfig.ExpectedHash,
+			ExpectedTxID: appConfig.ExpectedTxID,
+			ConfigType:   appConfig.ConfigType,
+			Encrypted:    appConfig.Encrypted,
+			Templated:    appConfig.Templated,
 		}
 		// Substitute all of the file refs with the actual contents of the file
 		if strings.HasPrefix(appConfig.Config, "file://") {
@@ -290,6 +322,96 @@ func updateAppConfigInfo(configMsg *mgmtapi.ConfigMessage, baseFilePath string,
 	return newConfigMsg, nil
 }
 
+// encryptConfigs encrypts the app and component configs that are flagged as encrypted
+func (a *updateAction) encryptConfigs(configMsg *mgmtapi.ConfigMessage) error {
+	var encryptionKey *cfgsnapapi.PublicKeyForConfigEncryption
+	var publicKey *rsa.PublicKey
+	encrypt := func(config *string) error {
+		if mgmt.IsEncrypted([]byte(*config)) {
+			return nil
+		}
+		if encryptionKey == nil {
+			var err error
+			encryptionKey, publicKey, err = a.getEncryptionKey()
+			if err != nil {
+				return err
+			}
+		}
+		encrypted, err := mgmt.EncryptConfig([]byte(*config), publicKey, encryptionKey.KeyID)
+		if err != nil {
+			return errors.Wrap(err, "error encrypting config")
+		}
+		*config = encrypted
+		return nil
+	}
+
+	for i := range configMsg.Peers {
+		for j := range configMsg.Peers[i].App {
+			if configMsg.Peers[i].App[j].Encrypted {
+				if err := encrypt(&configMsg.Peers[i].App[j].Config); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for i := range configMsg.Apps {
+		app := &configMsg.Apps[i]
+		if app.Encrypted && len(app.Components) == 0 {
+			if err := encrypt(&app.Config); err != nil {
+				return err
+			}
+		}
+		for j := range app.Components {
+			if app.Components[j].Encrypted {
+				if err := encrypt(&app.Components[j].Config); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// getEncryptionKey retrieves the public key that is used to encrypt configs
+func (a *updateAction) getEncryptionKey() (*cfgsnapapi.PublicKeyForConfigEncryption, *rsa.PublicKey, error) {
+	keyBytes, err := json.Marshal(&mgmtapi.ConfigKey{
+		MspID:      cfgsnapapi.GeneralMspID,
+		AppName:    cfgsnapapi.ConfigEncryptionKeyAppName,
+		AppVersion: cfgsnapapi.ConfigEncryptionKeyAppVersion,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error marshalling config key")
+	}
+	response, err := a.Query(cliconfig.ConfigSnapID, "get", [][]byte{keyBytes})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error retrieving config encryption key")
+	}
+	var configs []*mgmtapi.ConfigKV
+	if err := json.Unmarshal(response, &configs); err != nil {
+		return nil, nil, errors.Wrap(err, "error unmarshalling config encryption key")
+	}
+	if len(configs) == 0 || len(configs[0].Value) == 0 {
+		return nil, nil, errors.New("config encryption key not found")
+	}
+	encryptionKey := &cfgsnapapi.PublicKeyForConfigEncryption{}
+	if err := json.Unmarshal(configs[0].Value, encryptionKey); err != nil {
+		return nil, nil, errors.Wrap(err, "error unmarshalling config encryption key")
+	}
+	block, _ := pem.Decode([]byte(encryptionKey.PublicKey))
+	if block == nil {
+		return nil, nil, errors.New("config encryption key is not PEM encoded")
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error parsing config encryption key")
+	}
+	rsaPubKey, ok := pubKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("config encryption key is not an RSA key")
+	}
+	return encryptionKey, rsaPubKey, nil
+}
+
 func readFile(filePath string) (string, error) {
 	cliconfig.Config().Logger().Debugf("Reading file [%s]\n", filePath)
 	file, err := os.Open(filepath.Clean(filePath)) //nolint: gas
@@ -7,9 +7,18 @@ SPDX-License-Identifier: Apache-2.0
 package updatecmd
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"testing"
 
 	"github.com/pkg/errors"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/action"
 	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configcli/cliconfig"
 )
@@ -18,6 +27,13 @@ const (
 	clientConfigPath = "../testdata/clientconfig/config.yaml"
 )
 
+var (
+	//encryptionKeyResponse is returned by the mock for queries of the config encryption key
+	encryptionKeyResponse []byte
+	//savedConfig is the last config message that was saved using the mock
+	savedConfig *mgmtapi.ConfigMessage
+)
+
 func TestInvalidClientConfig(t *testing.T) {
 	execute(t, true, "--clientconfig", "invalidconfig.yaml")
 }
@@ -54,6 +70,59 @@ func TestValidConfigFile(t *testing.T) {
 	execute(t, false, "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--configfile", "../sampleconfig/org1-config.json", "--noprompt")
 }
 
+func TestEncryptedConfig(t *testing.T) {
+	configString := `{"MspID":"Org1MSP","Peers":[{"PeerID":"peer0.org1.example.com","App":[{"AppName":"myapp","Version":"1","Encrypted":true,"Config":"secret config"}]}],"Apps":[{"AppName":"secretapp","Version":"1","Components":[{"Name":"comp1","Version":"1","Encrypted":true,"Config":"secret comp config"},{"Name":"comp2","Version":"1","Config":"plain comp config"}]}]}`
+
+	//the encryption key has not been published
+	encryptionKeyResponse = []byte("[]")
+	execute(t, true, "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--config", configString, "--noprompt")
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err)
+	}
+	pubKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("error marshalling public key: %s", err)
+	}
+	keyConfig, err := json.Marshal(&cfgsnapapi.PublicKeyForConfigEncryption{
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes})),
+		KeyID:     "keyID",
+	})
+	if err != nil {
+		t.Fatalf("error marshalling encryption key: %s", err)
+	}
+	encryptionKeyResponse, err = json.Marshal([]*mgmtapi.ConfigKV{{Value: keyConfig}})
+	if err != nil {
+		t.Fatalf("error marshalling encryption key response: %s", err)
+	}
+	defer func() { encryptionKeyResponse = nil }()
+
+	execute(t, false, "--clientconfig", clientConfigPath, "--cid", "mychannel", "--mspid", "Org1MSP", "--config", configString, "--noprompt")
+
+	unwrap := func(keyID string, wrappedKey []byte) ([]byte, error) {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	}
+	assertDecrypted(t, savedConfig.Peers[0].App[0].Config, "secret config", unwrap)
+	assertDecrypted(t, savedConfig.Apps[0].Components[0].Config, "secret comp config", unwrap)
+	if savedConfig.Apps[0].Components[1].Config != "plain comp config" {
+		t.Fatalf("expecting unencrypted config but got %s", savedConfig.Apps[0].Components[1].Config)
+	}
+}
+
+func assertDecrypted(t *testing.T, config, expected string, unwrap mgmt.KeyUnwrapper) {
+	if !mgmt.IsEncrypted([]byte(config)) {
+		t.Fatalf("expecting encrypted config but got %s", config)
+	}
+	decrypted, err := mgmt.DecryptConfig([]byte(config), unwrap)
+	if err != nil {
+		t.Fatalf("error decrypting config: %s", err)
+	}
+	if string(decrypted) != expected {
+		t.Fatalf("expecting decrypted config [%s] but got [%s]", expected, decrypted)
+	}
+}
+
 func execute(t *testing.T, expectError bool, args ...string) {
 	cmd := newCmd(newMockAction())
 	action.InitGlobalFlags(cmd.PersistentFlags())
@@ -84,6 +153,9 @@ func newMockAction() *action.MockAction {
 				if err := configMessage.IsValid(); err != nil {
 					return nil, errors.Wrap(err, "invalid config message")
 				}
+				savedConfig = configMessage
+			} else if fctn == "get" && encryptionKeyResponse != nil {
+				return encryptionKeyResponse, nil
 			} else {
 				return nil, errors.Errorf("expecting function [save] or [refresh] but got [%s]", fctn)
 			}
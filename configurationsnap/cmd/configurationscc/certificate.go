@@ -0,0 +1,211 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+// getCertificateRoots returns the PEM encoded root certificates against which the chains of imported certificates
+// are validated
+var getCertificateRoots = func(channelID string) ([]string, error) {
+	return config.GetCertificateRoots(channelID, peerConfigPath)
+}
+
+//importCertificate validates a CA-issued certificate of a key that was generated by the snap on the channel and binds
+//the certificate to the key as a named client identity of the peer. The binding is stored in the config ledger
+//(see ClientIdentitiesAppName) from where httpsnap loads it as a named client.
+//The key registry and the key store are local to the peer that generated the key (MspID and PeerID of the request),
+//so only that peer checks that the key was generated by the snap. Any other endorser only verifies the certificate
+//chain and writes the same binding, so the request can be endorsed by several peers.
+func importCertificate(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if req.Name == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Name is required")
+	}
+	if req.Certificate == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Certificate is required")
+	}
+	if req.PeerID == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "PeerID is required")
+	}
+	if err := checkPeerACL(stub, configDataWriteACLPrefix); err != nil {
+		return nil, err
+	}
+	peerMspID, err := config.GetPeerMSPID(peerConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	peerID, err := config.GetPeerID(peerConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	mspID := req.MspID
+	if mspID == "" {
+		mspID = peerMspID
+	}
+
+	certs, err := parseCertificateChain(req.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	ski, err := getCertificateSKI(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	if req.SKI != "" && !strings.EqualFold(req.SKI, ski) {
+		return nil, errors.Errorf(errors.ValidationError, "Certificate is for key [%s] and not for key [%s]", ski, req.SKI)
+	}
+
+	if mspID == peerMspID && req.PeerID == peerID {
+		//the certificate must be for a key that the snap generated on this channel
+		k, _, err := getRegisteredKey(stub.GetChannelID(), ski)
+		if err != nil {
+			return nil, err
+		}
+		if !k.Private() {
+			return nil, errors.Errorf(errors.ValidationError, "Private key [%s] is not available", ski)
+		}
+	} else {
+		logger.Debugf("Key [%s] is held by peer [%s] of MSP [%s] and is not checked on peer [%s]", ski, req.PeerID, mspID, peerID)
+	}
+	if err := verifyCertificateChain(stub.GetChannelID(), certs); err != nil {
+		return nil, err
+	}
+
+	identity := &cfgsnapapi.ClientIdentity{SKI: ski, Cert: encodeCertificates(certs), NotAfter: certs[0].NotAfter}
+	if err := saveClientIdentity(stub, mspID, req.PeerID, req.Name, identity); err != nil {
+		return nil, err
+	}
+	logger.Infof("Imported certificate [%s] of key [%s] as client identity [%s] of peer [%s]", certs[0].Subject.CommonName, ski, req.Name, req.PeerID)
+
+	payload, e := json.Marshal(identity)
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to marshal client identity")
+	}
+	return payload, nil
+}
+
+//parseCertificateChain parses the PEM encoded certificate and the intermediate certificates that follow it
+func parseCertificateChain(certPEM string) ([]*x509.Certificate, errors.Error) {
+	var certs []*x509.Certificate
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.WithMessage(errors.ParseCertError, err, "Failed to parse certificate")
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New(errors.ParseCertError, "Certificate must be PEM encoded")
+	}
+	return certs, nil
+}
+
+//getCertificateSKI returns the hex encoded SKI of the public key of the certificate as computed by BCCSP
+func getCertificateSKI(cert *x509.Certificate) (string, errors.Error) {
+	csp, _, err := getConfiguredBCCSP()
+	if err != nil {
+		return "", err
+	}
+	pubKey, e := csp.KeyImport(cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
+	if e != nil {
+		return "", errors.Wrap(errors.CryptoError, e, "Failed to import public key of certificate")
+	}
+	return hex.EncodeToString(pubKey.SKI()), nil
+}
+
+//verifyCertificateChain verifies that the first certificate chains to one of the configured roots through the other
+//certificates and that it can be used for client authentication
+func verifyCertificateChain(channelID string, certs []*x509.Certificate) errors.Error {
+	roots, err := getCertificateRoots(channelID)
+	if err != nil {
+		return errors.CreateError(err, errors.MissingConfigDataError, "Failed to get certificate roots")
+	}
+	if len(roots) == 0 {
+		return errors.New(errors.MissingConfigDataError, "No certificate roots are configured")
+	}
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		if !rootPool.AppendCertsFromPEM([]byte(root)) {
+			return errors.New(errors.InitializeConfigError, "Invalid certificate root in config")
+		}
+	}
+	intermediatePool := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediatePool.AddCert(cert)
+	}
+
+	opts := x509.VerifyOptions{Roots: rootPool, Intermediates: intermediatePool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return errors.WithMessage(errors.ValidationError, err, "Failed to verify certificate chain")
+	}
+	return nil
+}
+
+//saveClientIdentity adds (or replaces) the named client identity in the client identities config of the peer
+func saveClientIdentity(stub shim.ChaincodeStubInterface, peerMspID, peerID, name string, identity *cfgsnapapi.ClientIdentity) errors.Error {
+	configKey := mgmtapi.ConfigKey{MspID: peerMspID, PeerID: peerID, AppName: cfgsnapapi.ClientIdentitiesAppName, AppVersion: cfgsnapapi.ClientIdentitiesAppVersion}
+	configManager := mgmt.NewConfigManager(stub)
+	configs, err := configManager.Get(configKey)
+	if err != nil {
+		return err
+	}
+	identities := make(map[string]*cfgsnapapi.ClientIdentity)
+	if len(configs) > 0 && len(configs[0].Value) > 0 {
+		if e := json.Unmarshal(configs[0].Value, &identities); e != nil {
+			return errors.WithMessage(errors.UnmarshalError, e, "Failed to unmarshal client identities")
+		}
+	}
+	identities[name] = identity
+
+	identitiesBytes, e := json.Marshal(identities)
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal client identities")
+	}
+	configMsg := &mgmtapi.ConfigMessage{
+		MspID: peerMspID,
+		Peers: []mgmtapi.PeerConfig{{
+			PeerID: peerID,
+			App:    []mgmtapi.AppConfig{{AppName: configKey.AppName, Version: configKey.AppVersion, Config: string(identitiesBytes)}},
+		}},
+	}
+	configMsgBytes, e := json.Marshal(configMsg)
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal config message")
+	}
+	return configManager.Save(configMsgBytes)
+}
+
+//encodeCertificates returns the PEM encoding of the certificates
+func encodeCertificates(certs []*x509.Certificate) string {
+	var certPEM []byte
+	for _, cert := range certs {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return string(certPEM)
+}
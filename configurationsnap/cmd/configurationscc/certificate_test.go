@@ -0,0 +1,185 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportCertificate(t *testing.T) {
+	_, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+	peerMspID, codedErr := config.GetPeerMSPID(peerConfigPath)
+	require.NoError(t, codedErr)
+	peerID, codedErr := config.GetPeerID(peerConfigPath)
+	require.NoError(t, codedErr)
+
+	res := stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA"})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	pubKey, err := x509.ParsePKIXPublicKey(unmarshalResponse(t, res.Payload).Payload)
+	require.NoError(t, err)
+	keys := listTestKeys(t, stub)
+	require.Len(t, keys, 1)
+	ski := keys[0].SKI
+
+	caKey, caCert := newTestCA(t, "Test CA")
+	certPEM := newTestCertificate(t, caKey, caCert, pubKey, x509.ExtKeyUsageClientAuth)
+
+	prevGetCertificateRoots := getCertificateRoots
+	defer func() { getCertificateRoots = prevGetCertificateRoots }()
+	getCertificateRoots = func(channelID string) ([]string, error) {
+		return []string{encodeCertificates([]*x509.Certificate{caCert})}, nil
+	}
+
+	res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client1", Certificate: certPEM, PeerID: peerID, SKI: ski})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	identity := &cfgsnapapi.ClientIdentity{}
+	require.NoError(t, json.Unmarshal(unmarshalResponse(t, res.Payload).Payload, identity))
+	assert.Equal(t, ski, identity.SKI)
+	assert.Equal(t, certPEM, identity.Cert)
+	assert.False(t, identity.NotAfter.IsZero())
+
+	//positional args, the SKI is optional
+	_, err = invoke(stub, [][]byte{[]byte("importCertificate"), []byte("client2"), []byte(certPEM), []byte(peerID)})
+	require.NoError(t, err)
+
+	//the client identities are stored in the config of the peer
+	configs, codedErr := mgmt.NewConfigManager(stub).Get(mgmtapi.ConfigKey{MspID: peerMspID, PeerID: peerID, AppName: cfgsnapapi.ClientIdentitiesAppName, AppVersion: cfgsnapapi.ClientIdentitiesAppVersion})
+	require.NoError(t, codedErr)
+	require.Len(t, configs, 1)
+	identities := make(map[string]*cfgsnapapi.ClientIdentity)
+	require.NoError(t, json.Unmarshal(configs[0].Value, &identities))
+	require.Len(t, identities, 2)
+	assert.Equal(t, ski, identities["client1"].SKI)
+	assert.Equal(t, certPEM, identities["client2"].Cert)
+
+	//invalid requests
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherCAKey, otherCACert := newTestCA(t, "Other CA")
+	for _, test := range []struct {
+		req       *cfgsnapapi.Request
+		errorCode string
+	}{
+		{&cfgsnapapi.Request{Certificate: certPEM, PeerID: peerID}, errors.MissingRequiredParameterError},
+		{&cfgsnapapi.Request{Name: "client3", PeerID: peerID}, errors.MissingRequiredParameterError},
+		{&cfgsnapapi.Request{Name: "client3", Certificate: certPEM}, errors.MissingRequiredParameterError},
+		{&cfgsnapapi.Request{Name: "client3", Certificate: "not a certificate", PeerID: peerID}, string(errors.ParseCertError)},
+		{&cfgsnapapi.Request{Name: "client3", Certificate: certPEM, PeerID: peerID, SKI: "0102"}, string(errors.ValidationError)},
+		//the key was not generated by the snap
+		{&cfgsnapapi.Request{Name: "client3", Certificate: newTestCertificate(t, caKey, caCert, &otherKey.PublicKey, x509.ExtKeyUsageClientAuth), PeerID: peerID}, string(errors.MissingConfigDataError)},
+		//the certificate is not issued by a configured root
+		{&cfgsnapapi.Request{Name: "client3", Certificate: newTestCertificate(t, otherCAKey, otherCACert, pubKey, x509.ExtKeyUsageClientAuth), PeerID: peerID}, string(errors.ValidationError)},
+		//the certificate can't be used for client authentication
+		{&cfgsnapapi.Request{Name: "client3", Certificate: newTestCertificate(t, caKey, caCert, pubKey, x509.ExtKeyUsageServerAuth), PeerID: peerID}, string(errors.ValidationError)},
+	} {
+		res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, test.req)})
+		require.Equal(t, int32(shim.ERROR), res.Status, "%+v", test.req)
+		assert.Equal(t, test.errorCode, unmarshalResponse(t, res.Payload).ErrorCode, "%+v", test.req)
+	}
+
+	//on another endorser the key isn't checked, only the certificate chain, and the same binding is written for the
+	//peer that holds the key
+	res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client1", Certificate: certPEM, PeerID: "peer2.example.com", MspID: "Org2MSP"})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	otherIdentity := &cfgsnapapi.ClientIdentity{}
+	require.NoError(t, json.Unmarshal(unmarshalResponse(t, res.Payload).Payload, otherIdentity))
+	assert.Equal(t, identity, otherIdentity)
+	configs, codedErr = mgmt.NewConfigManager(stub).Get(mgmtapi.ConfigKey{MspID: "Org2MSP", PeerID: "peer2.example.com", AppName: cfgsnapapi.ClientIdentitiesAppName, AppVersion: cfgsnapapi.ClientIdentitiesAppVersion})
+	require.NoError(t, codedErr)
+	require.Len(t, configs, 1)
+	identities = make(map[string]*cfgsnapapi.ClientIdentity)
+	require.NoError(t, json.Unmarshal(configs[0].Value, &identities))
+	require.Len(t, identities, 1)
+	assert.Equal(t, identity, identities["client1"])
+
+	//the MSP defaults to the MSP of the endorser
+	_, err = invoke(stub, [][]byte{[]byte("importCertificate"), []byte("client1"), []byte(newTestCertificate(t, caKey, caCert, &otherKey.PublicKey, x509.ExtKeyUsageClientAuth)), []byte("peer2.example.com")})
+	require.NoError(t, err)
+	configs, codedErr = mgmt.NewConfigManager(stub).Get(mgmtapi.ConfigKey{MspID: peerMspID, PeerID: "peer2.example.com", AppName: cfgsnapapi.ClientIdentitiesAppName, AppVersion: cfgsnapapi.ClientIdentitiesAppVersion})
+	require.NoError(t, codedErr)
+	require.Len(t, configs, 1)
+
+	//the certificate chain is verified on the other endorser
+	res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client3", Certificate: newTestCertificate(t, otherCAKey, otherCACert, pubKey, x509.ExtKeyUsageClientAuth), PeerID: "peer2.example.com"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.ValidationError), unmarshalResponse(t, res.Payload).ErrorCode)
+
+	//keys of other channels can't be bound
+	res = getMockStub("otherChannel").MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client3", Certificate: certPEM, PeerID: peerID})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.MissingConfigDataError), unmarshalResponse(t, res.Payload).ErrorCode)
+
+	//no roots are configured
+	getCertificateRoots = func(channelID string) ([]string, error) { return nil, nil }
+	res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client3", Certificate: certPEM, PeerID: peerID})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.MissingConfigDataError), unmarshalResponse(t, res.Payload).ErrorCode)
+
+	//the caller must be allowed to write the config of the peer
+	aclProvider = &mockACLProvider{aclFailed: true}
+	defer func() { aclProvider = &mockACLProvider{aclFailed: false} }()
+	res = stub.MockInvoke("txID", [][]byte{[]byte("importCertificate"), newRequestBytes(t, &cfgsnapapi.Request{Name: "client3", Certificate: certPEM, PeerID: peerID})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, errors.ACLCheckError, unmarshalResponse(t, res.Payload).ErrorCode)
+}
+
+func newTestCA(t *testing.T, cn string) (*ecdsa.PrivateKey, *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(certBytes)
+	require.NoError(t, err)
+	return caKey, caCert
+}
+
+func newTestCertificate(t *testing.T, caKey *ecdsa.PrivateKey, caCert *x509.Certificate, pubKey crypto.PublicKey, extKeyUsage x509.ExtKeyUsage) string {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, pubKey, caKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}))
+}
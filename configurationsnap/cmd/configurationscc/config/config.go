@@ -10,6 +10,8 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -38,7 +40,11 @@ type Config struct {
 	// PeerMspID is the MSP ID of the local peer
 	PeerMspID string
 	//cache refresh interval
-	RefreshInterval  time.Duration
+	RefreshInterval time.Duration
+	//CacheMaxBytes is the maximum number of bytes of cached configs of the channel (0 for no limit)
+	CacheMaxBytes int64
+	//CacheTTL is the time after which cached configs that haven't been read are evicted (0 for no limit)
+	CacheTTL         time.Duration
 	ConfigSnapConfig *viper.Viper
 }
 
@@ -53,6 +59,30 @@ type CSRConfig struct {
 	DNSNames       []string
 	EmailAddresses []string
 	IPAddresses    []net.IP
+	URIs           []*url.URL
+	//Allowed contains the fields that can be requested per CSR
+	Allowed CSRAllowList
+}
+
+//CSRAllowList contains the fields of a CSR that can be requested per CSR. Fields that are not listed can't be requested.
+type CSRAllowList struct {
+	//SubjectFields are the subject fields that can be overridden (Country, StateProvince, Locality, Org, OrgUnit)
+	SubjectFields []string
+	//URIPrefixes are the prefixes of the URI subject alternative names that can be requested
+	URIPrefixes []string
+	//KeyUsages are the key usages that can be requested
+	KeyUsages []string
+	//ExtKeyUsages are the extended key usages that can be requested
+	ExtKeyUsages []string
+	//Extensions are the OIDs (in dotted notation) of the extra extensions that can be requested
+	Extensions []string
+}
+
+//PKCS11Config contains the PKCS11 options of the peer's BCCSP
+type PKCS11Config struct {
+	Library string
+	Pin     string
+	Label   string
 }
 
 // New returns a new config snap configuration for the given channel
@@ -74,6 +104,8 @@ func New(channelID, peerConfigPathOverride string) (*Config, errors.Error) {
 
 	}
 	var refreshInterval = defaultRefreshInterval
+	var cacheMaxBytes int64
+	var cacheTTL time.Duration
 	var customConfig *viper.Viper
 	var dirty = true
 	var dataConfig []byte
@@ -103,6 +135,8 @@ func New(channelID, peerConfigPathOverride string) (*Config, errors.Error) {
 		if refreshInterval < minimumRefreshInterval {
 			refreshInterval = minimumRefreshInterval
 		}
+		cacheMaxBytes = customConfig.GetInt64("cache.maxBytes")
+		cacheTTL = customConfig.GetDuration("cache.ttl")
 
 	}
 
@@ -113,6 +147,8 @@ func New(channelID, peerConfigPathOverride string) (*Config, errors.Error) {
 		PeerID:           peerID,
 		PeerMspID:        mspID,
 		RefreshInterval:  refreshInterval,
+		CacheMaxBytes:    cacheMaxBytes,
+		CacheTTL:         cacheTTL,
 		ConfigSnapConfig: customConfig,
 	}
 	if dirty {
@@ -181,6 +217,53 @@ func GetBCCSPProvider(peerConfigPathOverride string) (string, errors.Error) {
 	return bccspProvider, nil
 }
 
+//GetPKCS11Config returns the PKCS11 options of the BCCSP from the peer config
+func GetPKCS11Config(peerConfigPathOverride string) (*PKCS11Config, errors.Error) {
+	peerConfig, err := newPeerViper(peerConfigPathOverride)
+	if err != nil {
+		return nil, errors.WithMessage(errors.PeerConfigError, err, "Error reading peer config for PKCS11")
+	}
+	return &PKCS11Config{
+		Library: peerConfig.GetString("peer.BCCSP.PKCS11.Library"),
+		Pin:     peerConfig.GetString("peer.BCCSP.PKCS11.Pin"),
+		Label:   peerConfig.GetString("peer.BCCSP.PKCS11.Label"),
+	}, nil
+}
+
+//GetSWKeyStorePath returns the key store path of the SW BCCSP from the peer config. It defaults to the keystore
+//directory under the MSP config path. Relative paths are relative to the directory of the peer config.
+func GetSWKeyStorePath(peerConfigPathOverride string) (string, errors.Error) {
+	peerConfig, err := newPeerViper(peerConfigPathOverride)
+	if err != nil {
+		return "", errors.WithMessage(errors.PeerConfigError, err, "Error reading peer config for key store path")
+	}
+	keyStorePath := peerConfig.GetString("peer.BCCSP.SW.FileKeyStore.KeyStore")
+	if keyStorePath == "" {
+		mspConfigPath := peerConfig.GetString("peer.mspConfigPath")
+		if mspConfigPath == "" {
+			return "", errors.New(errors.PeerConfigError, "Neither the key store path nor the MSP config path is configured")
+		}
+		keyStorePath = filepath.Join(mspConfigPath, "keystore")
+	}
+	if !filepath.IsAbs(keyStorePath) {
+		keyStorePath = filepath.Join(filepath.Dir(peerConfig.ConfigFileUsed()), keyStorePath)
+	}
+	return keyStorePath, nil
+}
+
+//GetFileSystemPath returns the path on the file system where the peer stores its data
+func GetFileSystemPath(peerConfigPathOverride string) (string, errors.Error) {
+	peerConfig, err := newPeerViper(peerConfigPathOverride)
+	if err != nil {
+		return "", errors.WithMessage(errors.PeerConfigError, err, "Error reading peer config for file system path")
+	}
+	fileSystemPath := peerConfig.GetString("peer.fileSystemPath")
+	if fileSystemPath == "" {
+		return "", errors.New(errors.PeerConfigError, "The peer's file system path is not configured")
+	}
+	return fileSystemPath, nil
+}
+
 func getMyConfig(channelID string, peerConfigPath string) (*viper.Viper, errors.Error) {
 	peerMspID, codedErr := GetPeerMSPID(peerConfigPath)
 	if codedErr != nil {
@@ -232,11 +315,34 @@ func GetCSRConfigOptions(channelID string, peerConfigPath string) (*CSRConfig, e
 		}
 	}
 	csrConfig.IPAddresses = netAddrs
+	for _, v := range csconfig.GetStringSlice("csr.alternativenames.URIs") {
+		uri, err := url.Parse(v)
+		if err != nil {
+			return nil, errors.WithMessage(errors.InitializeConfigError, err, fmt.Sprintf("invalid URI [%s] in csr config", v))
+		}
+		csrConfig.URIs = append(csrConfig.URIs, uri)
+	}
+
+	csrConfig.Allowed.SubjectFields = csconfig.GetStringSlice("csr.allowed.subjectFields")
+	csrConfig.Allowed.URIPrefixes = csconfig.GetStringSlice("csr.allowed.uriPrefixes")
+	csrConfig.Allowed.KeyUsages = csconfig.GetStringSlice("csr.allowed.keyUsages")
+	csrConfig.Allowed.ExtKeyUsages = csconfig.GetStringSlice("csr.allowed.extKeyUsages")
+	csrConfig.Allowed.Extensions = csconfig.GetStringSlice("csr.allowed.extensions")
 
 	return &csrConfig, nil
 
 }
 
+//GetCertificateRoots returns the PEM encoded root certificates against which the chains of imported certificates are
+//validated
+func GetCertificateRoots(channelID string, peerConfigPath string) ([]string, error) {
+	csconfig, err := getMyConfig(channelID, peerConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return csconfig.GetStringSlice("certificates.roots"), nil
+}
+
 //GetDefaultRefreshInterval get default interval
 func GetDefaultRefreshInterval() time.Duration {
 	return defaultRefreshInterval
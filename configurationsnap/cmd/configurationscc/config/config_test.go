@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	configmanagerApi "github.com/securekey/fabric-snaps/configmanager/api"
@@ -62,6 +64,12 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		panic(fmt.Sprintf("File error: %v\n", err))
 	}
+	//the sample config doesn't trust any roots so the test root is added from a fixture
+	rootsData, err := ioutil.ReadFile("testdata/certificateroots.yaml")
+	if err != nil {
+		panic(fmt.Sprintf("File error: %v\n", err))
+	}
+	configData = append(append(configData, '\n'), rootsData...)
 	stub := getMockStub("testChannel")
 	configMsg := &configmanagerApi.ConfigMessage{MspID: "Org1MSP",
 		Peers: []configmanagerApi.PeerConfig{configmanagerApi.PeerConfig{
@@ -96,6 +104,42 @@ func TestGetBCCSPProvider(t *testing.T) {
 	}
 }
 
+func TestGetKeyStoreConfig(t *testing.T) {
+	_, err := GetSWKeyStorePath("")
+	if err == nil {
+		t.Fatalf("Expected error")
+	}
+	keyStorePath, err := GetSWKeyStorePath("../sampleconfig")
+	if err != nil {
+		t.Fatalf("Got error while getting key store path %s", err)
+	}
+	if !filepath.IsAbs(keyStorePath) || !strings.HasSuffix(keyStorePath, filepath.Join("sampleconfig", "msp", "keystore")) {
+		t.Fatalf("Unexpected key store path %s", keyStorePath)
+	}
+
+	fileSystemPath, err := GetFileSystemPath("../sampleconfig")
+	if err != nil {
+		t.Fatalf("Got error while getting file system path %s", err)
+	}
+	if fileSystemPath == "" {
+		t.Fatalf("Expected file system path")
+	}
+
+	if _, err := GetPKCS11Config("../sampleconfig"); err != nil {
+		t.Fatalf("Got error while getting PKCS11 config %s", err)
+	}
+}
+
+func TestGetCertificateRoots(t *testing.T) {
+	roots, err := GetCertificateRoots("testChannel", "../sampleconfig")
+	if err != nil {
+		t.Fatalf("Got error while getting certificate roots %s", err)
+	}
+	if len(roots) != 1 || !strings.HasPrefix(roots[0], "-----BEGIN CERTIFICATE-----") {
+		t.Fatalf("Expected one PEM encoded root but got %v", roots)
+	}
+}
+
 func TestGetDefaultRefreshInterval(t *testing.T) {
 	csrCfg := GetDefaultRefreshInterval()
 	if csrCfg == 0 {
@@ -6,15 +6,17 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"crypto/dsa"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/json"
 	"fmt"
-	"reflect"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/securekey/fabric-snaps/util/rolesmgr"
@@ -23,11 +25,11 @@ import (
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/logging"
 	fabApi "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/hyperledger/fabric/bccsp"
-	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/bccsp/signer"
 	acl "github.com/hyperledger/fabric/core/aclmgmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/protos/common"
 	protosMSP "github.com/hyperledger/fabric/protos/msp"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
@@ -45,15 +47,26 @@ import (
 )
 
 // functionRegistry is a registry of the functions that are supported by configuration snap
-var functionRegistry = map[string]func(shim.ChaincodeStubInterface, [][]byte, *Metrics) pb.Response{
-	"healthCheck":     healthCheck,
-	"save":            save,
-	"get":             get,
-	"getFromCache":    getFromCache,
-	"delete":          delete,
-	"refresh":         refresh,
-	"generateKeyPair": generateKeyPair,
-	"generateCSR":     generateCSR,
+var functionRegistry = map[string]snapFunction{
+	"healthCheck":       {invoke: healthCheck, parseArgs: parseNoArgs},
+	"save":              {invoke: save, parseArgs: parseConfigArgs},
+	"get":               {invoke: get, parseArgs: parseGetArgs},
+	"getFromCache":      {invoke: getFromCache, parseArgs: parseKeyArgs},
+	"cacheStatus":       {invoke: cacheStatus, parseArgs: parseCacheStatusArgs},
+	"getHistory":        {invoke: getHistory, parseArgs: parseKeyArgs},
+	"getAsOf":           {invoke: getAsOf, parseArgs: parseKeyAndTxIDArgs},
+	"delete":            {invoke: delete, parseArgs: parseKeyArgs},
+	"rollback":          {invoke: rollback, parseArgs: parseKeyAndTxIDArgs},
+	"reindex":           {invoke: reindex, parseArgs: parseReindexArgs},
+	"refresh":           {invoke: refresh, parseArgs: parseRefreshArgs},
+	"generateKeyPair":   {invoke: generateKeyPair, parseArgs: parseKeyPairArgs},
+	"generateCSR":       {invoke: generateCSR, parseArgs: parseCSRArgs},
+	"listKeys":          {invoke: listKeys, parseArgs: parseNoArgs},
+	"getPublicKey":      {invoke: getPublicKey, parseArgs: parseSKIArgs},
+	"deleteKey":         {invoke: deleteKey, parseArgs: parseSKIArgs},
+	"sign":              {invoke: sign, parseArgs: parseSignArgs},
+	"verify":            {invoke: verify, parseArgs: parseVerifyArgs},
+	"importCertificate": {invoke: importCertificate, parseArgs: parseImportCertificateArgs},
 }
 
 // signatureRegistry is a registry of the Signature Algorithms supported by configuration snap
@@ -79,6 +92,9 @@ var availableFunctions = functionSet()
 
 var logger = logging.NewLogger("configsnap")
 
+var listenersMtx sync.Mutex
+var configListeners = make(map[string]listener.ChaincodeListener)
+
 // ConfigurationSnap implementation
 type ConfigurationSnap struct {
 	metrics *Metrics
@@ -96,17 +112,17 @@ const (
 	// configDataWriteACLPrefix is the prefix for the write (save, delete) policy resource names
 	configDataWriteACLPrefix = "configdata/write/"
 
+	// keyUseACLPrefix is the prefix for the policy resource names of the use (sign, verify) of a key. The resource
+	// name of a key is the prefix followed by the hex encoded SKI of the key.
+	keyUseACLPrefix = "keys/use/"
+
+	// cacheDiffMode is the mode of cacheStatus that compares the cache with the ledger
+	cacheDiffMode = "cacheDiff"
+
 	// configSnapName is the cc name used for event source
 	configSnapName = "configurationsnap"
 
-	// connectBackoff is the time to back off for connecting to event listener
-	connectBackoff = time.Second
-
 	publicKeyAlgRSA = "RSA"
-
-	publicKeyAlgDSA = "DSA"
-
-	publicKeyAlgECDSA = "ECDSA"
 )
 
 // Init snap
@@ -127,8 +143,7 @@ func (configSnap *ConfigurationSnap) Init(stub shim.ChaincodeStubInterface) pb.R
 		if err != nil {
 			return util.CreateShimResponseFromError(errors.WithMessage(errors.InitializeSnapError, err, "Error initializing Configuration Snap"), logger, stub)
 		}
-		interval := config.GetDefaultRefreshInterval()
-		logger.Debugf("******** Call initialize for [%s][%s][%v]", peerMspID, peerID, interval)
+		logger.Debugf("******** Call initialize for [%s][%s]", peerMspID, peerID)
 		configmgmtService.Initialize(stub, peerMspID)
 
 		eventSource := &listener.EventSource{
@@ -142,31 +157,54 @@ func (configSnap *ConfigurationSnap) Init(stub shim.ChaincodeStubInterface) pb.R
 			return util.CreateShimResponseFromError(errors.WithMessage(errors.InitializeSnapError, err1, "Error initializing Configuration Snap"), logger, stub)
 		}
 
+		setConfigListener(stub.GetChannelID(), updateListener)
 		go listenConfigEvents(stub.GetChannelID(), updateListener, configSnap.metrics)
 
-		periodicRefresh(stub.GetChannelID(), interval, configSnap.metrics)
+		startRefreshScheduler(stub.GetChannelID(), configSnap.metrics)
 	}
 	return shim.Success(nil)
 }
 
+//setConfigListener sets the listener for config updates on the channel and stops the previous listener (if any)
+func setConfigListener(channelID string, updateListener listener.ChaincodeListener) {
+	listenersMtx.Lock()
+	previous := configListeners[channelID]
+	configListeners[channelID] = updateListener
+	listenersMtx.Unlock()
+
+	if previous != nil {
+		previous.Stop()
+	}
+}
+
+//getConfigListener returns the listener for config updates on the channel or nil if the snap isn't listening on the channel
+func getConfigListener(channelID string) listener.ChaincodeListener {
+	listenersMtx.Lock()
+	defer listenersMtx.Unlock()
+	return configListeners[channelID]
+}
+
+//listenConfigEvents refreshes the cache whenever a config update event is received. The listener re-registers
+//for events by itself so this only returns when the listener is stopped.
 func listenConfigEvents(channelID string, updateListener listener.ChaincodeListener, metrics *Metrics) {
-	for {
-		eventChannel, err := updateListener.Listen()
-		if err != nil {
-			e := errors.Errorf(errors.SystemError, "Error listening for updates on channel [%s]: %s", channelID, err)
-			logger.Warnf(e.Error())
-			time.Sleep(connectBackoff)
+	eventChannel, err := updateListener.Listen()
+	if err != nil {
+		logger.Errorf("Error listening for updates on channel [%s]: %s", channelID, err)
+		return
+	}
+
+	for updateEvent := range eventChannel {
+		if updateEvent == nil {
 			continue
 		}
 
-		for updateEvent := range eventChannel {
-			if updateEvent == nil {
-				continue
+		go func(payload []byte) {
+			if err := sendRefreshRequest(channelID, metrics, payload); err != nil {
+				logger.Errorf("Refresh of config cache on channel [%s] after config update failed: %s", channelID, err)
 			}
-
-			go sendRefreshRequest(channelID, metrics)
-		}
+		}(updateEvent.Payload)
 	}
+	logger.Infof("Stopped listening for updates on channel [%s]", channelID)
 }
 
 // Invoke is the main entry point for invocations
@@ -187,8 +225,18 @@ func (configSnap *ConfigurationSnap) Invoke(stub shim.ChaincodeStubInterface) (r
 
 	functionArgs := args[1:]
 
-	logger.Debugf("Invoking function [%s] with args: %s", functionName, functionArgs)
-	return function(stub, functionArgs, configSnap.metrics)
+	//the function args are either a versioned request or the deprecated positional args of the function
+	req, versioned, codedErr := parseRequest(function, functionArgs)
+	if codedErr != nil {
+		return newResponse(stub, versioned, nil, codedErr)
+	}
+	if !versioned {
+		logger.Debugf("Function [%s] was invoked with deprecated positional args", functionName)
+	}
+
+	logger.Debugf("Invoking function [%s] with request: %+v", functionName, req)
+	payload, codedErr := function.invoke(stub, req, configSnap.metrics)
+	return newResponse(stub, versioned, payload, codedErr)
 }
 
 // functionSet returns a string enumerating all available functions
@@ -200,15 +248,28 @@ func functionSet() string {
 	return functionNames
 }
 
-// healthCheck is the health check function of this ConfigurationSnap
-func healthCheck(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-	response := healthcheck.SmokeTest(healthcheck.ConfigurationScc, stub, args)
+// healthCheck is the health check function of this ConfigurationSnap. The payload contains the result of each check,
+// also if the health check failed.
+func healthCheck(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	response := healthcheck.SmokeTest(healthcheck.ConfigurationScc, stub, nil)
 	if response.Status != shim.OK {
 		errObj := errors.New(errors.SystemError, fmt.Sprintf("%s healthcheck failed: %s ; metrics=%s", healthcheck.ConfigurationScc, response.Message, metrics))
 		logger.Error(errObj.GenerateLogMsg())
-		return util.CreateShimResponseFromError(errObj, logger, stub)
+		return response.Payload, errObj
 	}
-	return shim.Success(response.Payload)
+	return response.Payload, nil
+}
+
+//checkConfigListener is the health check that the snap is registered for config update events on the stub's channel
+func checkConfigListener(stub shim.ChaincodeStubInterface) error {
+	updateListener := getConfigListener(stub.GetChannelID())
+	if updateListener == nil {
+		return errors.Errorf(errors.SystemError, "not listening for config update events on channel [%s]", stub.GetChannelID())
+	}
+	if !updateListener.IsHealthy() {
+		return errors.Errorf(errors.SystemError, "not registered for config update events on channel [%s]", stub.GetChannelID())
+	}
+	return nil
 }
 
 //checkACLforKey - checks acl for the given config key
@@ -217,8 +278,11 @@ func checkACLforKey(stub shim.ChaincodeStubInterface, configKey *mgmtapi.ConfigK
 		return errors.New(errors.SystemError, "ACL check failed, config has empty msp")
 	}
 
-	resourceName := aclResourcePrefix + configKey.MspID
+	return checkACL(stub, aclResourcePrefix+configKey.MspID)
+}
 
+//checkACL checks the ACL of the caller for the given policy resource
+func checkACL(stub shim.ChaincodeStubInterface, resourceName string) errors.Error {
 	logger.Debugf("Checking ACL for resource: %v", resourceName)
 
 	sp, err := stub.GetSignedProposal()
@@ -253,46 +317,56 @@ func getMspID(stub shim.ChaincodeStubInterface) (string, errors.Error) {
 	return sid.Mspid, nil
 }
 
-//save - saves configuration passed in args
-func save(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-	configMsg := args[0]
+//save - saves configuration passed in the request
+func save(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	configMsg := []byte(req.Config)
 	if len(configMsg) == 0 {
-		return util.CreateShimResponseFromError(errors.New(errors.MissingRequiredParameterError, "Config is empty-cannot be saved"), logger, stub)
+		return nil, errors.New(errors.MissingRequiredParameterError, "Config is empty-cannot be saved")
 	}
 
-	// parse config message for ACL check
-	configMessageMap, err := mgmt.ParseConfigMessage(configMsg, stub.GetTxID())
+	// parse config message (or batch) for ACL check
+	configMessageMap, err := mgmt.ParseConfigData(configMsg, stub.GetTxID())
 	if err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+		return nil, err
 	}
 
+	//check write access once for every MSP in the message
+	checkedMspIDs := make(map[string]bool)
 	for key := range configMessageMap {
 		key := key
+		if checkedMspIDs[key.MspID] {
+			continue
+		}
 		if err = checkACLforKey(stub, &key, configDataWriteACLPrefix); err != nil {
-			return util.CreateShimResponseFromError(err, logger, stub)
+			return nil, err
 		}
+		checkedMspIDs[key.MspID] = true
 	}
 
 	cmngr := mgmt.NewConfigManager(stub)
 	err = cmngr.Save(configMsg)
 	if err != nil {
 		logger.Errorf("Got error while saving config: %s ; metrics= %s", err.GenerateLogMsg(), metrics)
-		return util.CreateShimResponseFromError(err, logger, stub)
+		return nil, err
 	}
 
-	return shim.Success(nil)
+	return nil, nil
 }
 
-//get - gets configuration using configkey as criteria
-func get(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
+//get - gets configuration using configkey as criteria.
+//If page options are provided then a page of the configs matching the key is returned
+func get(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if codedErr := requireKey(req); codedErr != nil {
+		return nil, codedErr
+	}
+	configKey := req.Key
 
-	configKey, codedErr := getKey(args)
-	if codedErr != nil {
-		return util.CreateShimResponseFromError(codedErr, logger, stub)
+	if codedErr := checkACLforKey(stub, configKey, configDataReadACLPrefix); codedErr != nil {
+		return nil, codedErr
 	}
 
-	if codedErr = checkACLforKey(stub, configKey, configDataReadACLPrefix); codedErr != nil {
-		return util.CreateShimResponseFromError(codedErr, logger, stub)
+	if req.PageOptions != nil {
+		return query(stub, configKey, req.PageOptions, metrics)
 	}
 
 	//valid key
@@ -300,70 +374,215 @@ func get(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.R
 	config, getCodedErr := cmngr.Get(*configKey)
 	if getCodedErr != nil {
 		logger.Errorf("Get for key %+v returns error: %s ; metrics= %s", configKey, getCodedErr.GenerateLogMsg(), metrics)
-		return util.CreateShimResponseFromError(errors.WithMessage(errors.GetConfigError, getCodedErr, fmt.Sprintf("Failed to retrieve config for config key %+v", configKey)), logger, stub)
+		return nil, errors.WithMessage(errors.GetConfigError, getCodedErr, fmt.Sprintf("Failed to retrieve config for config key %+v", configKey))
 	}
 
 	payload, err := json.Marshal(config)
 	if err != nil {
 		errObj := errors.WithMessage(errors.SystemError, err, "Failed to marshal config")
 		logger.Errorf(errObj.GenerateLogMsg())
-		return util.CreateShimResponseFromError(errObj, logger, stub)
+		return nil, errObj
+	}
+
+	return payload, nil
+}
 
+//query - gets a page of configurations using the MspID, PeerID, AppName and ComponentName of the config key as filters
+func query(stub shim.ChaincodeStubInterface, configKey *mgmtapi.ConfigKey, pageOptions *mgmtapi.PageOptions, metrics *Metrics) ([]byte, errors.Error) {
+	configQuery := mgmtapi.ConfigQuery{
+		MspID:         configKey.MspID,
+		PeerID:        configKey.PeerID,
+		AppName:       configKey.AppName,
+		ComponentName: configKey.ComponentName,
+		PageOptions:   *pageOptions,
 	}
 
-	return shim.Success(payload)
+	cmngr := mgmt.NewConfigManager(stub)
+	result, codedErr := cmngr.Query(configQuery)
+	if codedErr != nil {
+		logger.Errorf("Query %+v returns error: %s ; metrics= %s", configQuery, codedErr.GenerateLogMsg(), metrics)
+		return nil, errors.WithMessage(errors.GetConfigError, codedErr, fmt.Sprintf("Failed to query configs for %+v", configQuery))
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, errors.WithMessage(errors.SystemError, err, "Failed to marshal query result")
+	}
+	return payload, nil
 }
 
-//delete - deletes configuration using config key as criteria
-func delete(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
+//getHistory - gets all values that were stored for the given config key
+func getHistory(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if codedErr := requireKey(req); codedErr != nil {
+		return nil, codedErr
+	}
+	configKey := req.Key
+
+	if codedErr := checkACLforKey(stub, configKey, configDataReadACLPrefix); codedErr != nil {
+		return nil, codedErr
+	}
+
+	cmngr := mgmt.NewConfigManager(stub)
+	history, codedErr := cmngr.GetHistory(*configKey)
+	if codedErr != nil {
+		logger.Errorf("GetHistory for key %+v returns error: %s ; metrics= %s", configKey, codedErr.GenerateLogMsg(), metrics)
+		return nil, errors.WithMessage(errors.GetConfigError, codedErr, fmt.Sprintf("Failed to retrieve config history for config key %+v", configKey))
+	}
+
+	payload, err := json.Marshal(history)
+	if err != nil {
+		errObj := errors.WithMessage(errors.SystemError, err, "Failed to marshal config history")
+		logger.Errorf(errObj.GenerateLogMsg())
+		return nil, errObj
+	}
+
+	return payload, nil
+}
+
+//getAsOf - gets the value that the given config key had when the given transaction was committed
+func getAsOf(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if codedErr := requireKey(req); codedErr != nil {
+		return nil, codedErr
+	}
+	configKey := req.Key
+	if req.TxID == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "TxID is required")
+	}
+
+	if codedErr := checkACLforKey(stub, configKey, configDataReadACLPrefix); codedErr != nil {
+		return nil, codedErr
+	}
 
-	configKey, err := getKey(args)
+	cmngr := mgmt.NewConfigManager(stub)
+	entry, codedErr := cmngr.GetAsOf(*configKey, req.TxID)
+	if codedErr != nil {
+		logger.Errorf("GetAsOf for key %+v returns error: %s ; metrics= %s", configKey, codedErr.GenerateLogMsg(), metrics)
+		return nil, codedErr
+	}
+
+	payload, err := json.Marshal(entry)
 	if err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+		errObj := errors.WithMessage(errors.SystemError, err, "Failed to marshal config")
+		logger.Errorf(errObj.GenerateLogMsg())
+		return nil, errObj
 	}
 
+	return payload, nil
+}
+
+//delete - deletes configuration using config key as criteria
+func delete(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := requireKey(req); err != nil {
+		return nil, err
+	}
+	configKey := req.Key
+
 	if err := checkACLforKey(stub, configKey, configDataWriteACLPrefix); err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+		return nil, err
 	}
 
 	//valid key
 	cmngr := mgmt.NewConfigManager(stub)
 	if err := cmngr.Delete(*configKey); err != nil {
 		logger.Errorf("Got error while deleting config: %s ; metrics= %s", err.GenerateLogMsg(), metrics)
-		return util.CreateShimResponseFromError(err, logger, stub)
+		return nil, err
 
 	}
-	return shim.Success(nil)
+	return nil, nil
 }
 
-func refresh(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-	startTime := time.Now()
-	defer func() { metrics.ConfigRefresh.Observe(time.Since(startTime).Seconds()) }()
+//rollback - restores configuration for the config key to the values that the given transaction stored.
+//A key containing only MspID and optionally AppName restores all matching configs that the transaction changed.
+func rollback(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := requireKey(req); err != nil {
+		return nil, err
+	}
+	configKey := req.Key
+	if req.TxID == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "TxID is required")
+	}
 
-	if len(args) < 1 {
-		return util.CreateShimResponseFromError(errors.New(errors.MissingRequiredParameterError, "expecting first arg to be a JSON array of MSP IDs"), logger, stub)
+	if err := checkACLforKey(stub, configKey, configDataWriteACLPrefix); err != nil {
+		return nil, err
 	}
 
-	var msps []string
-	err := json.Unmarshal(args[0], &msps)
+	cmngr := mgmt.NewConfigManager(stub)
+	if err := cmngr.Rollback(*configKey, req.TxID); err != nil {
+		logger.Errorf("Got error while rolling back config: %s ; metrics= %s", err.GenerateLogMsg(), metrics)
+		return nil, err
+	}
+	return nil, nil
+}
+
+//reindex - rebuilds the index entries of the configurations of the MSP of the config key, or of all MSPs
+//if no config key is provided. Configurations that were saved before an upgrade that added indexes are not
+//found by the queries that use those indexes until they are reindexed.
+func reindex(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	mspID := ""
+	if req.Key != nil {
+		mspID = req.Key.MspID
+		if err := checkACLforKey(stub, req.Key, configDataWriteACLPrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	cmngr := mgmt.NewConfigManager(stub)
+	result, err := cmngr.Reindex(mspID)
 	if err != nil {
-		return util.CreateShimResponseFromError(errors.WithMessage(errors.UnmarshalError, err, "Failed to unmarshal msp IDs"), logger, stub)
+		logger.Errorf("Got error while reindexing configs: %s ; metrics= %s", err.GenerateLogMsg(), metrics)
+		return nil, err
+	}
+	if mspID == "" {
+		//the caller must be allowed to write the configs of every MSP. The index entries that were
+		//written or removed are discarded along with the transaction if it isn't.
+		checked := make(map[string]bool)
+		keys := append(append([]mgmtapi.ConfigKey{}, result.Reindexed...), result.Removed...)
+		for i := range keys {
+			if checked[keys[i].MspID] {
+				continue
+			}
+			if err := checkACLforKey(stub, &keys[i], configDataWriteACLPrefix); err != nil {
+				return nil, err
+			}
+			checked[keys[i].MspID] = true
+		}
+	}
+	logger.Infof("Reindexed %d configs and removed the index entries of %d deleted configs of MSP [%s]", len(result.Reindexed), len(result.Removed), mspID)
+
+	payload, e := json.Marshal(result)
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to marshal reindexed keys")
 	}
+	return payload, nil
+}
+
+func refresh(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	startTime := time.Now()
+	defer func() { metrics.ConfigRefresh.Observe(time.Since(startTime).Seconds()) }()
 
 	peerMspID, err := config.GetPeerMSPID(peerConfigPath)
 	if err != nil {
-		return util.CreateShimResponseFromError(errors.WithMessage(errors.UnmarshalError, err, "Failed to get peer msp ID"), logger, stub)
+		return nil, errors.WithMessage(errors.UnmarshalError, err, "Failed to get peer msp ID")
 	}
 
 	// ACL check
 	if err := checkACLforKey(stub, &mgmtapi.ConfigKey{MspID: peerMspID}, configDataReadACLPrefix); err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+		return nil, err
 	}
 
 	x := configmgmtService.GetInstance()
 	instance := x.(*configmgmtService.ConfigServiceImpl)
 
-	for _, msp := range msps {
+	//if the config event is provided then only the changed configs are refreshed
+	if req.Event != nil {
+		updateErr := instance.Update(stub, req.Event)
+		if updateErr == nil {
+			return nil, nil
+		}
+		logger.Warnf("Failed to update cache with changed configs, refreshing all configs: %s", updateErr)
+	}
+
+	for _, msp := range req.MspIDs {
 		logger.Debugf("****** Refresh msp id %s", msp)
 		refreshErr := instance.Refresh(stub, msp)
 		if refreshErr != nil {
@@ -376,19 +595,18 @@ func refresh(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics)
 		logger.Debugf("****** instance.Refresh returned error %s", refreshError)
 	}
 
-	return shim.Success(nil)
+	return nil, nil
 }
 
 //getFromCache - gets configuration using configkey as criteria from cache
-func getFromCache(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-
-	configKey, err := getKey(args)
-	if err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+func getFromCache(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := requireKey(req); err != nil {
+		return nil, err
 	}
+	configKey := req.Key
 
-	if err = checkACLforKey(stub, configKey, configDataReadACLPrefix); err != nil {
-		return util.CreateShimResponseFromError(err, logger, stub)
+	if err := checkACLforKey(stub, configKey, configDataReadACLPrefix); err != nil {
+		return nil, err
 	}
 	//valid key
 	x := configmgmtService.GetInstance()
@@ -396,85 +614,126 @@ func getFromCache(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metr
 	config, getFromCacheErr := instance.GetFromCache(stub.GetChannelID(), *configKey)
 	if getFromCacheErr != nil {
 		logger.Errorf("Get for key %+v returns error: %s ; metrics=%s", configKey, getFromCacheErr.GenerateLogMsg(), metrics)
-		return util.CreateShimResponseFromError(getFromCacheErr, logger, stub)
+		return nil, getFromCacheErr
 	}
-	return shim.Success(config)
+	return config, nil
 }
 
-//to generate key pair based on options submitted
-//expected keytype and ephemeral flag in args
-func generateKeyPair(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-	if len(args) < 2 {
-		return shim.Error(fmt.Sprintf("Required arguments are: key type and ephemeral flag; metrics=%s", metrics))
+//cacheStatus returns the status of the config cache of each MSP on the channel (key count, hash of each key,
+//last refresh time and block height). If the mode is "cacheDiff" then the keys whose cached hash
+//differs from their ledger value are returned instead.
+func cacheStatus(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	mode := req.Mode
+	if mode != "" && mode != cacheDiffMode {
+		return nil, errors.Errorf(errors.InvalidFunctionError, "Invalid cache status mode: %s. Expecting %s", mode, cacheDiffMode)
 	}
-	keyType := string(args[0])
-	ephemeral, err := strconv.ParseBool(string(args[1]))
+
+	peerMspID, err := config.GetPeerMSPID(peerConfigPath)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Ephemeral flag is not set"))
+		return nil, errors.WithMessage(errors.UnmarshalError, err, "Failed to get peer msp ID")
+	}
+	if err := checkACLforKey(stub, &mgmtapi.ConfigKey{MspID: peerMspID}, configDataReadACLPrefix); err != nil {
+		return nil, err
+	}
+
+	x := configmgmtService.GetInstance()
+	instance := x.(*configmgmtService.ConfigServiceImpl)
+
+	var status interface{}
+	if mode == cacheDiffMode {
+		diffs, err := instance.GetCacheDiff(stub)
+		if err != nil {
+			return nil, err
+		}
+		status = diffs
+	} else {
+		status = instance.GetCacheStatus(stub.GetChannelID())
 	}
+
+	payload, e := json.Marshal(status)
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to marshal cache status")
+	}
+	return payload, nil
+}
+
+//to generate key pair based on the key type and ephemeral flag of the request
+func generateKeyPair(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
 	//check if requesteed option was supported
-	options, err := getKeyOpts(keyType, ephemeral)
+	options, err := getKeyOpts(req.KeyType, req.Ephemeral)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.CreateError(err, errors.ValidationError, "Invalid key type")
+	}
+	if codedErr := checkKeyDestroyable(req.Ephemeral); codedErr != nil {
+		return nil, codedErr
 	}
 	//generate key
-	return generateKeyWithOpts(stub.GetChannelID(), options)
+	k, pubKeyBytes, codedErr := generateKeyWithOpts(stub.GetChannelID(), options)
+	if codedErr != nil {
+		return nil, codedErr
+	}
+	if codedErr = registerGeneratedKey(stub, k, req); codedErr != nil {
+		return nil, codedErr
+	}
+	return pubKeyBytes, nil
 }
 
-//to generate CSR based on supplied arguments
-//first arg: key type (ECDSA, RSA)
-//second arg : ephemeral flag (true/false)
-//third  arg: signature algorithm (one of x509.SignatureAlgorithm)
-func generateCSR(stub shim.ChaincodeStubInterface, args [][]byte, metrics *Metrics) pb.Response {
-	//check args
-	if len(args) < 4 {
-		return shim.Error(fmt.Sprintf("Required arguments are: [key type,ephemeral flag, CSR's signature algorithm and common name; metrics=%s", metrics))
-	}
-	keyType := string(args[0])
-	ephemeral, err := strconv.ParseBool(string(args[1]))
+//to generate CSR based on the key type, ephemeral flag, signature algorithm (one of x509.SignatureAlgorithm)
+//and common name of the request. The subject, URI SANs, key usages and extra extensions of the request (which are
+//checked against the allow-list of the csr config) are added to the configured ones.
+func generateCSR(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	//get requested key options
+	options, err := getKeyOpts(req.KeyType, req.Ephemeral)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Ephemeral flag is not set; metrics=%s", metrics))
+		return nil, errors.CreateError(err, errors.ValidationError, "Invalid key type")
 	}
-	sigAlgType := string(args[2])
-	csrCommonName := string(args[3])
-	//get requested key options
-	options, err := getKeyOpts(keyType, ephemeral)
+	if codedErr := checkKeyDestroyable(req.Ephemeral); codedErr != nil {
+		return nil, codedErr
+	}
+	//validate the request before generating a key for it
+	csrConfig, err := getCSRConfig(stub.GetChannelID(), peerConfigPath)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.CreateError(err, errors.GeneralError, "Failed to get csr config")
+	}
+	if codedErr := validateCSRRequest(csrConfig, req); codedErr != nil {
+		return nil, codedErr
 	}
 	logger.Debugf("Keygen options %+v", options)
 	bccspsuite, keys, err := getBCCSPAndKeyPair(stub.GetChannelID(), options)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.CreateError(err, errors.GeneralError, "Failed to generate key")
 	}
-	csrTemplate, err := getCSRTemplate(stub.GetChannelID(), keys, keyType, sigAlgType, csrCommonName)
+	csrTemplate, err := getCSRTemplate(stub.GetChannelID(), keys, req)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.CreateError(err, errors.GeneralError, "Failed to create certificate request template")
 	}
 	logger.Debugf("Certificate request template %+v", csrTemplate)
 	//generate the csr request
 	cryptoSigner, err := signer.New(bccspsuite, keys)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.WithMessage(errors.GeneralError, err, "Failed to create signer")
 	}
 	csrReq, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, cryptoSigner)
 	if err != nil {
-		return shim.Error(err.Error())
+		return nil, errors.WithMessage(errors.GeneralError, err, "Failed to create certificate request")
+	}
+	if codedErr := registerGeneratedKey(stub, keys, req); codedErr != nil {
+		return nil, codedErr
 	}
 	logger.Debugf("CSR was created. Len is %d", len(csrReq))
-	return shim.Success(csrReq)
+	return csrReq, nil
 
 }
 
-func getCSRTemplate(channelID string, keys bccsp.Key, keyType string, sigAlgType string, csrCommonName string) (x509.CertificateRequest, error) {
+func getCSRTemplate(channelID string, keys bccsp.Key, req *cfgsnapapi.Request) (x509.CertificateRequest, error) {
 
 	var csrTemplate x509.CertificateRequest
-	sigAlg, err := getSignatureAlg(sigAlgType)
+	sigAlg, err := getSignatureAlg(req.SignatureAlgorithm)
 	if err != nil {
 		return csrTemplate, err
 	}
 	//generate subject for CSR
-	asn1Subj, err := getCSRSubject(channelID, csrCommonName)
+	asn1Subj, err := getCSRSubject(channelID, req.CommonName, req.Subject)
 	if err != nil {
 		return csrTemplate, err
 	}
@@ -486,16 +745,28 @@ func getCSRTemplate(channelID string, keys bccsp.Key, keyType string, sigAlgType
 	if keys == nil {
 		return csrTemplate, errors.New(errors.GeneralError, "Invalid key")
 	}
-	pubKey, err := keys.PublicKey()
+	pubKeyBytes, codedErr := parseKey(keys)
+	if codedErr != nil {
+		logger.Debugf("Get error parsing public key %s", codedErr)
+		return csrTemplate, codedErr
+	}
+	pubKey, err := x509.ParsePKIXPublicKey(pubKeyBytes)
 	if err != nil {
-		logger.Debugf("Get error parsing public key %s", err)
-		return csrTemplate, err
+		return csrTemplate, errors.WithMessage(errors.GeneralError, err, "Failed to parse public key")
 	}
-	pubKeyAlg, err := getPublicKeyAlg(keyType)
+	pubKeyAlg, err := getPublicKeyAlg(pubKey)
 	if err != nil {
 		logger.Debugf("Get error parsing public key alg %s", err)
 		return csrTemplate, err
 	}
+	uris, codedErr := getCSRURIs(csrConfig, req)
+	if codedErr != nil {
+		return csrTemplate, codedErr
+	}
+	extensions, codedErr := getCSRExtensions(req)
+	if codedErr != nil {
+		return csrTemplate, codedErr
+	}
 	//generate a csr template
 	csrTemplate = x509.CertificateRequest{
 		Version:            1,
@@ -507,13 +778,18 @@ func getCSRTemplate(channelID string, keys bccsp.Key, keyType string, sigAlgType
 		DNSNames:       csrConfig.DNSNames,
 		EmailAddresses: csrConfig.EmailAddresses,
 		IPAddresses:    csrConfig.IPAddresses,
+		URIs:           uris,
+		//key usage, extended key usage and extra extensions
+		ExtraExtensions: extensions,
 	}
 	logger.Debugf("Certificate request template %+v", csrTemplate)
 	return csrTemplate, nil
 
 }
 
-func getCSRSubject(channelID string, csrCommonName string) ([]byte, error) {
+//getCSRSubject returns the subject of the CSR. The configured subject fields are overridden by the non-empty fields of
+//the requested subject (if any).
+func getCSRSubject(channelID string, csrCommonName string, subject *cfgsnapapi.CSRSubject) ([]byte, error) {
 	if channelID == "" {
 		return nil, errors.Errorf(errors.GeneralError, "Channel is required")
 	}
@@ -531,6 +807,7 @@ func getCSRSubject(channelID string, csrCommonName string) ([]byte, error) {
 		Organization:       []string{csrConfig.Org},
 		OrganizationalUnit: []string{csrConfig.OrgUnit},
 	}
+	overrideSubject(&subj, subject)
 	logger.Debugf("Subject options %+v", subj)
 
 	rawSubj := subj.ToRDNSequence()
@@ -576,7 +853,6 @@ func getCSRConfig(channelID string, peerConfigPath string) (*config.CSRConfig, e
 
 func getBCCSPAndKeyPair(channelID string, opts bccsp.KeyGenOpts) (bccsp.BCCSP, bccsp.Key, error) {
 	var k bccsp.Key
-	var err error
 	var bccspsuite bccsp.BCCSP
 
 	if channelID == "" {
@@ -587,35 +863,29 @@ func getBCCSPAndKeyPair(channelID string, opts bccsp.KeyGenOpts) (bccsp.BCCSP, b
 		return bccspsuite, k, errors.New(errors.GeneralError, "The key gen option is required")
 	}
 
-	bccspProvider, err := config.GetBCCSPProvider(peerConfigPath)
-	if err != nil {
-		return bccspsuite, k, err
-	}
-	logger.Debugf("***Configured BCCSP provider's ID is %s", bccspProvider)
-	bccspsuite, err = factory.GetBCCSP(bccspProvider)
-	if err != nil {
-		logger.Debugf("Error getting BCCSP based on provider ID %s %s", bccspProvider, err)
-		return bccspsuite, k, errors.Wrap(errors.GeneralError, err, "BCCSP Initialize failed")
+	bccspsuite, _, codedErr := getConfiguredBCCSP()
+	if codedErr != nil {
+		return bccspsuite, k, codedErr
 	}
-	logger.Debugf("***Configured BCCSP provider is %s", reflect.TypeOf(bccspsuite))
-	k, err = bccspsuite.KeyGen(opts)
+	k, err := bccspsuite.KeyGen(opts)
 	if err != nil {
 		return bccspsuite, k, errors.Wrap(errors.GeneralError, err, "Key Gen failed")
 	}
 	return bccspsuite, k, nil
 }
 
-func getPublicKeyAlg(algorithm string) (x509.PublicKeyAlgorithm, error) {
-	var sigAlg x509.PublicKeyAlgorithm
-	switch algorithm {
-	case publicKeyAlgRSA:
-		return x509.RSA, nil
-	case publicKeyAlgDSA:
-		return x509.RSA, nil
-	case publicKeyAlgECDSA:
+//getPublicKeyAlg returns the algorithm of the given public key
+func getPublicKeyAlg(pubKey interface{}) (x509.PublicKeyAlgorithm, error) {
+	var pubKeyAlg x509.PublicKeyAlgorithm
+	switch pubKey.(type) {
+	case *rsa.PublicKey:
 		return x509.RSA, nil
+	case *dsa.PublicKey:
+		return x509.DSA, nil
+	case *ecdsa.PublicKey:
+		return x509.ECDSA, nil
 	default:
-		return sigAlg, errors.Errorf(errors.GeneralError, "Public key algorithm is not supported %s", algorithm)
+		return pubKeyAlg, errors.Errorf(errors.GeneralError, "Public key algorithm is not supported %T", pubKey)
 	}
 }
 func getSignatureAlg(algorithm string) (x509.SignatureAlgorithm, error) {
@@ -655,85 +925,85 @@ func getKeyOpts(keyType string, ephemeral bool) (bccsp.KeyGenOpts, error) {
 
 }
 
-//generateKeyWithOpts to generate key using BCCSP
-func generateKeyWithOpts(channelID string, opts bccsp.KeyGenOpts) pb.Response {
+//generateKeyWithOpts to generate key using BCCSP. The key and its public key bytes are returned.
+func generateKeyWithOpts(channelID string, opts bccsp.KeyGenOpts) (bccsp.Key, []byte, errors.Error) {
 
 	_, k, err := getBCCSPAndKeyPair(channelID, opts)
 	if err != nil {
-		return shim.Error(fmt.Sprintf("Got error from getBCCSPAndKeyPair in %+v %s", opts, err))
+		return nil, nil, errors.CreateError(err, errors.GeneralError, fmt.Sprintf("Got error from getBCCSPAndKeyPair in %+v", opts))
+	}
+	pubKeyBytes, codedErr := parseKey(k)
+	if codedErr != nil {
+		return nil, nil, codedErr
+	}
+	return k, pubKeyBytes, nil
+}
+
+//registerGeneratedKey adds a key that was generated by the request to the key registry unless the key is ephemeral.
+//A key that can't be registered can't be used or deleted through the snap, so it is destroyed and an error is returned.
+func registerGeneratedKey(stub shim.ChaincodeStubInterface, k bccsp.Key, req *cfgsnapapi.Request) errors.Error {
+	if req.Ephemeral {
+		return nil
+	}
+	err := registerKey(stub, k, req.KeyType, req.Label)
+	if err == nil {
+		return nil
 	}
-	return parseKey(k)
+	_, provider, codedErr := getConfiguredBCCSP()
+	if codedErr == nil {
+		codedErr = destroyKey(provider, k.SKI())
+	}
+	if codedErr != nil {
+		logger.Warnf("Failed to destroy key [%x] that couldn't be registered: %s", k.SKI(), codedErr.GenerateLogMsg())
+	}
+	return errors.WithMessage(errors.SystemError, err, "Failed to register generated key")
 }
 
 //pass generated key (private/public) and return public to caller
-func parseKey(k bccsp.Key) pb.Response {
+func parseKey(k bccsp.Key) ([]byte, errors.Error) {
 	//logger.Debugf("Parsing key %v", k)
 	var pubKey bccsp.Key
 	var err error
 	if k.Private() {
 		pubKey, err = k.PublicKey()
 		if err != nil {
-			return pb.Response{Payload: nil, Status: shim.ERROR, Message: err.Error()}
+			return nil, errors.WithMessage(errors.GeneralError, err, "Failed to get public key")
 		}
 	} else {
 		pubKey = k
 	}
 	pubKeyBts, err := pubKey.Bytes()
 	if err != nil {
-		return pb.Response{Payload: nil, Status: shim.ERROR, Message: err.Error()}
+		return nil, errors.WithMessage(errors.GeneralError, err, "Failed to get public key bytes")
 	}
 	logger.Debugf("***PubKey - len '%d' - SKI: '%v'", len(pubKeyBts), pubKey.SKI())
-	return shim.Success(pubKeyBts)
+	return pubKeyBts, nil
 
 }
 
-func periodicRefresh(channelID string, refreshInterval time.Duration, metrics *Metrics) {
-	logger.Debugf("***Periodic refresh was called on [%d]\n", refreshInterval)
-	go func() {
-		for {
-			time.Sleep(refreshInterval)
-			sendRefreshRequest(channelID, metrics)
-			csccconfig, err := config.New(channelID, peerConfigPath)
-			if err != nil {
-				logger.Debugf("Got error while creating config for channel %v\n", channelID)
-			}
-			if csccconfig == nil {
-				refreshInterval = config.GetDefaultRefreshInterval()
-			} else {
-				if csccconfig.RefreshInterval < config.GetMinimumRefreshInterval() {
-					refreshInterval = config.GetMinimumRefreshInterval()
-				} else {
-					refreshInterval = csccconfig.RefreshInterval
-				}
-			}
-		}
-	}()
-}
-
-func sendRefreshRequest(channelID string, metrics *Metrics) {
+//sendRefreshRequest sends a refresh request to the local peer. If the payload of the config event is provided
+//then only the configs that were changed by the event are refreshed.
+func sendRefreshRequest(channelID string, metrics *Metrics, eventPayload []byte) error {
 	startTime := time.Now()
 	defer func() { metrics.ConfigPeriodicRefresh.Observe(time.Since(startTime).Seconds()) }()
 
 	//call to get snaps config from ledger and to initilaize cahce instance
 	txService, err := txsnapservice.Get(channelID)
 	if err != nil {
-		logger.Debugf("Cannot get txService: %v", err)
-		return
+		return errors.WithMessage(errors.SystemError, err, "Cannot get txService")
 	}
-	sendEndorseRequest(channelID, txService)
+	return sendEndorseRequest(channelID, txService, eventPayload)
 }
 
-func sendEndorseRequest(channelID string, txService *txsnapservice.TxServiceImpl) {
+func sendEndorseRequest(channelID string, txService *txsnapservice.TxServiceImpl, eventPayload []byte) errors.Error {
 	targetPeer, err := txService.GetLocalPeer()
 	if err != nil {
-		logger.Errorf(errors.WithMessage(errors.SystemError, err, "Error creating target peer when sending refresh request").GenerateLogMsg())
-		return
+		return errors.WithMessage(errors.SystemError, err, "Error creating target peer when sending refresh request")
 	}
 
 	chConfig, err := txService.FcClient.ChannelConfig()
 	if err != nil {
-		logger.Errorf(errors.WithMessage(errors.SystemError, err, "Error getting channel config").GenerateLogMsg())
-		return
+		return errors.WithMessage(errors.SystemError, err, "Error getting channel config")
 	}
 
 	var mspIDs []string
@@ -746,20 +1016,27 @@ func sendEndorseRequest(channelID string, txService *txsnapservice.TxServiceImpl
 		mspIDs = append(mspIDs, fabricMSPConfig.Name)
 	}
 
-	mspIDsBytes, marshalErr := json.Marshal(mspIDs)
+	req := &cfgsnapapi.Request{Version: cfgsnapapi.RequestVersion, MspIDs: mspIDs}
+	if len(eventPayload) > 0 {
+		req.Event = &mgmtapi.ConfigEvent{}
+		if err := json.Unmarshal(eventPayload, req.Event); err != nil {
+			logger.Warnf("Failed to unmarshal config event, refreshing all configs: %s", err)
+			req.Event = nil
+		}
+	}
+	reqBytes, marshalErr := json.Marshal(req)
 	if marshalErr != nil {
-		logger.Errorf(errors.WithMessage(errors.SystemError, marshalErr, "Error marshalling JSON args").GenerateLogMsg())
-		return
+		return errors.WithMessage(errors.SystemError, marshalErr, "Error marshalling refresh request")
 	}
 
-	args := [][]byte{[]byte("refresh"), mspIDsBytes}
+	args := [][]byte{[]byte("refresh"), reqBytes}
 	txSnapReq := createTransactionSnapRequest("configurationsnap", channelID, args, nil, nil)
 
 	_, err = txService.EndorseTransaction(txSnapReq, []fabApi.Peer{targetPeer})
 	if err != nil {
-		logger.Errorf(errors.WithMessage(errors.SystemError, err, "configuration snap 'referesh' failed").GenerateLogMsg())
-		return
+		return errors.WithMessage(errors.SystemError, err, "configuration snap 'referesh' failed")
 	}
+	return nil
 }
 
 func createTransactionSnapRequest(chaincodeID string, chnlID string,
@@ -804,8 +1081,30 @@ func getACLProvider() acl.ACLProvider {
 	return acl.NewACLProvider(peer.GetStableChannelConfig)
 }
 
+//getBlockchainInfo returns the blockchain info of the ledger of the channel for the ledger health check
+func getBlockchainInfo(channelID string) (*common.BlockchainInfo, error) {
+	lgr := peer.GetLedger(channelID)
+	if lgr == nil {
+		return nil, errors.Errorf(errors.SystemError, "cannot obtain ledger for channel [%s]", channelID)
+	}
+	return lgr.GetBlockchainInfo()
+}
+
+//checkTxClient creates the transaction service, and hence its client, of the channel for the tx client health check
+func checkTxClient(channelID string) error {
+	if _, err := txsnapservice.Get(channelID); err != nil {
+		return err
+	}
+	return nil
+}
+
 // New chaincode implementation
 func New() shim.Chaincode {
+	cacheChecker := configmgmtService.GetInstance().(*configmgmtService.ConfigServiceImpl)
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.CacheInitializedCheck, healthcheck.NewCacheCheck(cacheChecker))
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.LedgerReachableCheck, healthcheck.NewLedgerCheck(healthcheck.LedgerCheckerFunc(getBlockchainInfo)))
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.TxClientCheck, healthcheck.NewTxClientCheck(healthcheck.TxClientCheckerFunc(checkTxClient)))
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.EventListenerCheck, checkConfigListener)
 	return &ConfigurationSnap{metrics: NewMetrics(metricsutil.GetMetricsInstance())}
 }
 
@@ -7,16 +7,21 @@ SPDX-License-Identifier: Apache-2.0
 package main
 
 import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"testing"
 
 	"strings"
 
+	fabApi "github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
 	"github.com/hyperledger/fabric/bccsp"
 	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
@@ -25,6 +30,8 @@ import (
 	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
 	configmgmtService "github.com/securekey/fabric-snaps/configmanager/pkg/service"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/healthcheck"
 	"github.com/securekey/fabric-snaps/membershipsnap/api/membership"
 	metricsutil "github.com/securekey/fabric-snaps/metrics/pkg/util"
 	mockstub "github.com/securekey/fabric-snaps/mocks/mockstub"
@@ -68,18 +75,16 @@ func TestRefreshACLSuccess(t *testing.T) {
 
 	stub.ChannelID = "testChannel"
 
-	mspsBytes, err := json.Marshal([]string{"Org1MSP", "Org2MSP", "Org3MSP"})
-	require.NoError(t, err)
-	args := [][]byte{mspsBytes}
+	req := &cfgsnapapi.Request{MspIDs: []string{"Org1MSP", "Org2MSP", "Org3MSP"}}
 
 	peerConfigPath = "./sampleconfig"
 
 	aclCheckCalled = false
 	aclProvider = &mockACLProvider{aclFailed: false}
 	membershipService = &mockMembershipService{}
-	response := refresh(stub, args, NewMetrics(metricsutil.GetMetricsInstance()))
-	if response.Status != 200 {
-		t.Fatalf("Refresh failed: %v", response.Message)
+	_, err := refresh(stub, req, NewMetrics(metricsutil.GetMetricsInstance()))
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
 	}
 	if !aclCheckCalled {
 		t.Fatal("ACL check call was expected")
@@ -91,18 +96,16 @@ func TestRefreshACLFailure(t *testing.T) {
 
 	stub.ChannelID = "testChannel"
 
-	mspsBytes, err := json.Marshal([]string{"Org1MSP", "Org2MSP", "Org3MSP"})
-	require.NoError(t, err)
-	args := [][]byte{mspsBytes}
+	req := &cfgsnapapi.Request{MspIDs: []string{"Org1MSP", "Org2MSP", "Org3MSP"}}
 
 	peerConfigPath = "./sampleconfig"
 
 	aclCheckCalled = false
 	aclProvider = &mockACLProvider{aclFailed: true}
 	membershipService = &mockMembershipService{}
-	response := refresh(stub, args, NewMetrics(metricsutil.GetMetricsInstance()))
-	if response.Status != 500 {
-		t.Fatal("Refresh should have failed for ACL with 500 status")
+	_, err := refresh(stub, req, NewMetrics(metricsutil.GetMetricsInstance()))
+	if err == nil {
+		t.Fatal("Refresh should have failed for ACL")
 	}
 }
 
@@ -158,7 +161,7 @@ func TestGenerateCSR(t *testing.T) {
 }
 
 func TestSendRefreshRequest(t *testing.T) {
-	sendRefreshRequest("testChannel", NewMetrics(metricsutil.GetMetricsInstance()))
+	sendRefreshRequest("testChannel", NewMetrics(metricsutil.GetMetricsInstance()), nil)
 }
 
 func TestNew(t *testing.T) {
@@ -185,9 +188,12 @@ func TestParseKey(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error %s", err)
 	}
-	response := parseKey(k)
-	if response.Status != 200 {
-		t.Fatalf("Error %v", response.Message)
+	pubKey, codedErr := parseKey(k)
+	if codedErr != nil {
+		t.Fatalf("Error %v", codedErr)
+	}
+	if len(pubKey) == 0 {
+		t.Fatal("Expecting public key")
 	}
 
 }
@@ -202,11 +208,11 @@ func TestGetCSRTemplate(t *testing.T) {
 	peerConfigPath = "./sampleconfig"
 
 	//	getCSRTemplate(channelID string, keys bccsp.Key, keyType string, sigAlgType string, csrCommonName string) (x509.CertificateRequest, error) {
-	_, err := getCSRTemplate("testChannel", nil, "ECDSA", "ECDSA", "csrCommonName")
+	_, err := getCSRTemplate("testChannel", nil, &cfgsnapapi.Request{KeyType: "ECDSA", SignatureAlgorithm: "ECDSA", CommonName: "csrCommonName"})
 	if err == nil {
 		t.Fatal("Expected: ' Alg is not supported'")
 	}
-	_, err = getCSRTemplate("testChannel", nil, "ECDSA", "ECDSAWithSHA1", "csrCommonName")
+	_, err = getCSRTemplate("testChannel", nil, &cfgsnapapi.Request{KeyType: "ECDSA", SignatureAlgorithm: "ECDSAWithSHA1", CommonName: "csrCommonName"})
 	if err == nil {
 		t.Fatal("Expected 'Error Invalid key'")
 	}
@@ -228,13 +234,26 @@ func TestGetCSRTemplate(t *testing.T) {
 		t.Fatalf("Error  %s", err)
 	}
 
-	_, err = getCSRTemplate("testChannel", k, "ECDSA", "ECDSAWithSHA1", "csrCommonName")
+	template, err := getCSRTemplate("testChannel", k, &cfgsnapapi.Request{KeyType: "ECDSA", SignatureAlgorithm: "ECDSAWithSHA1", CommonName: "csrCommonName"})
 	if err != nil {
 		t.Fatalf("Expected 'Error Invalid key' %s", err)
 	}
+	assert.Equal(t, x509.ECDSA, template.PublicKeyAlgorithm)
+	require.Len(t, template.URIs, 1)
+	assert.Equal(t, "spiffe://securekey.com/fabric-snaps", template.URIs[0].String())
 }
 
 func testHealthcheck(t *testing.T, stub *mockstub.MockStub) {
+	//registers the health checks of the snap
+	New()
+	//the ledger and the tx client aren't available in unit tests
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.LedgerReachableCheck, func(stub shim.ChaincodeStubInterface) error { return nil })
+	healthcheck.RegisterCheck(healthcheck.ConfigurationScc, healthcheck.TxClientCheck, func(stub shim.ChaincodeStubInterface) error { return nil })
+
+	updateListener := &mockListener{healthy: true}
+	setConfigListener(stub.GetChannelID(), updateListener)
+	defer setConfigListener(stub.GetChannelID(), nil)
+
 	// configuration Scc healthcheck call
 	echoBytes, err := invoke(stub, [][]byte{[]byte("healthCheck")})
 	if err != nil {
@@ -242,6 +261,20 @@ func testHealthcheck(t *testing.T, stub *mockstub.MockStub) {
 	}
 
 	logger.Infof("Message received from healthcheck: %s", echoBytes)
+	result, err := healthcheck.UnmarshalEchoResponse(echoBytes)
+	require.NoError(t, err)
+	assert.Equal(t, shim.OK, result.Status)
+	var checks []string
+	for _, check := range result.Checks {
+		assert.Equal(t, shim.OK, check.Status)
+		checks = append(checks, check.Name)
+	}
+	assert.Equal(t, []string{healthcheck.BCCSPCheck, healthcheck.CacheInitializedCheck, healthcheck.LedgerReachableCheck, healthcheck.TxClientCheck, healthcheck.EventListenerCheck}, checks)
+
+	updateListener.healthy = false
+	_, err = invoke(stub, [][]byte{[]byte("healthCheck")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), healthcheck.EventListenerCheck)
 }
 
 func invoke(stub *mockstub.MockStub, args [][]byte) ([]byte, error) {
@@ -317,6 +350,42 @@ func TestSaveACLFailure(t *testing.T) {
 	}
 }
 
+func TestSaveBatch(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+	batch := `{"Messages":[{"MspID":"Org1MSP","Apps":[{"AppName":"batchApp","Version":"1","Config":"org1 config"}]},{"MspID":"Org2MSP","Apps":[{"AppName":"batchApp","Version":"1","Config":"org2 config"}]},{"MspID":"general","Apps":[{"AppName":"batchApp","Version":"1","Config":"general config"}]}]}`
+
+	//the batch is rejected if any of the MSPs fails the ACL check
+	provider := &mockACLProvider{failedResource: configDataWriteACLPrefix + "Org2MSP"}
+	aclProvider = provider
+	if _, err := invoke(stub, [][]byte{[]byte("save"), []byte(batch)}); err == nil {
+		t.Fatal("Save batch should have failed with ACL check error")
+	}
+	cmngr := mgmt.NewConfigManager(stub)
+	if configs, err := cmngr.Get(mgmtapi.ConfigKey{MspID: "Org1MSP", AppName: "batchApp", AppVersion: "1"}); err != nil || len(configs) != 0 {
+		t.Fatalf("Expected no configs to be saved but got %v, %v", configs, err)
+	}
+
+	provider = &mockACLProvider{}
+	aclProvider = provider
+	if _, err := invoke(stub, [][]byte{[]byte("save"), []byte(batch)}); err != nil {
+		t.Fatalf("Could not save batch :%s", err)
+	}
+	assert.Equal(t, []string{configDataWriteACLPrefix + "Org1MSP", configDataWriteACLPrefix + "Org2MSP", configDataWriteACLPrefix + "general"}, sortedStrings(provider.checkedResources))
+	for _, mspID := range []string{"Org1MSP", "Org2MSP", "general"} {
+		configs, err := cmngr.Get(mgmtapi.ConfigKey{MspID: mspID, AppName: "batchApp", AppVersion: "1"})
+		if err != nil || len(configs) != 1 {
+			t.Fatalf("Expected config to be saved for MSP %s but got %v, %v", mspID, configs, err)
+		}
+	}
+}
+
+func sortedStrings(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
 func TestGetACLSuccess(t *testing.T) {
 	peerConfigPath = "./sampleconfig"
 
@@ -422,6 +491,53 @@ func TestGetFromCacheACLSuccess(t *testing.T) {
 	}
 }
 
+func TestCacheStatus(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+	aclProvider = &mockACLProvider{aclFailed: false}
+
+	response, err := invoke(stub, [][]byte{[]byte("cacheStatus")})
+	if err != nil {
+		t.Fatalf("Could not get cache status: %s", err)
+	}
+	var statuses []*mgmtapi.CacheStatus
+	require.NoError(t, json.Unmarshal(response, &statuses))
+	var status *mgmtapi.CacheStatus
+	for _, s := range statuses {
+		if s.MspID == "Org1MSP" {
+			status = s
+		}
+	}
+	require.NotNil(t, status)
+	assert.Equal(t, "testChannel", status.ChannelID)
+	assert.True(t, status.KeyCount > 0)
+	assert.Equal(t, status.KeyCount, len(status.Hashes))
+
+	//the ledger of the new stub is empty so every cached key differs from the ledger
+	response, err = invoke(stub, [][]byte{[]byte("cacheStatus"), []byte("cacheDiff")})
+	if err != nil {
+		t.Fatalf("Could not get cache diff: %s", err)
+	}
+	var diffs []*mgmtapi.CacheDiff
+	require.NoError(t, json.Unmarshal(response, &diffs))
+	var numDiffs int
+	for _, diff := range diffs {
+		if diff.MspID == "Org1MSP" {
+			numDiffs++
+			assert.Equal(t, status.Hashes[diff.Key], diff.CachedHash)
+			assert.Empty(t, diff.LedgerHash)
+		}
+	}
+	assert.Equal(t, status.KeyCount, numDiffs)
+
+	_, err = invoke(stub, [][]byte{[]byte("cacheStatus"), []byte("invalid")})
+	assert.Error(t, err)
+
+	aclProvider = &mockACLProvider{aclFailed: true}
+	_, err = invoke(stub, [][]byte{[]byte("cacheStatus")})
+	assert.Error(t, err)
+}
+
 func TestGetFromCacheACLFailure(t *testing.T) {
 	peerConfigPath = "./sampleconfig"
 
@@ -539,6 +655,149 @@ func TestDeleteACLFailure(t *testing.T) {
 	}
 }
 
+func TestGetHistoryAndAsOf(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	_, err := invoke(stub, [][]byte{[]byte("save"), []byte(strings.Replace(validMsgMultiplePeersAndApps, "$v", api.VERSION, -1))})
+	if err != nil {
+		t.Fatalf("Could not save configuration :%s", err)
+	}
+
+	configKey := mgmtapi.ConfigKey{MspID: "Org1MSP", PeerID: "peer.one.one.example.com", AppName: "appNameB", AppVersion: api.VERSION}
+	keyBytes, err := json.Marshal(&configKey)
+	require.NoError(t, err)
+
+	aclCheckCalled = false
+	response, err := invoke(stub, [][]byte{[]byte("getHistory"), keyBytes})
+	require.NoError(t, err)
+	assert.True(t, aclCheckCalled, "ACL check call was expected")
+	var history []*mgmtapi.ConfigHistoryEntry
+	require.NoError(t, json.Unmarshal(response, &history))
+	require.NotEmpty(t, history)
+	assert.Equal(t, "1", history[len(history)-1].TxID)
+	assert.Equal(t, "config for appNametwo", string(history[len(history)-1].Value))
+
+	require.NotNil(t, history[len(history)-1].Creator)
+	assert.Equal(t, "Org1MSP", history[len(history)-1].Creator.MspID)
+
+	response, err = invoke(stub, [][]byte{[]byte("getAsOf"), keyBytes, []byte(history[len(history)-1].TxID)})
+	require.NoError(t, err)
+	entry := &mgmtapi.ConfigHistoryEntry{}
+	require.NoError(t, json.Unmarshal(response, entry))
+	assert.Equal(t, "config for appNametwo", string(entry.Value))
+
+	_, err = invoke(stub, [][]byte{[]byte("getAsOf"), keyBytes})
+	assert.Error(t, err, "expected error: TxID is required")
+	_, err = invoke(stub, [][]byte{[]byte("getAsOf"), keyBytes, []byte("bogusTxn")})
+	assert.Error(t, err, "expected error: No config found for key")
+
+	aclProvider = &mockACLProvider{aclFailed: true}
+	_, err = invoke(stub, [][]byte{[]byte("getHistory"), keyBytes})
+	assert.Error(t, err, "expected ACL check error")
+}
+
+func TestRollback(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	configMsg := strings.Replace(validMsgMultiplePeersAndApps, "$v", api.VERSION, -1)
+	res := stub.MockInvoke("saveTxn", [][]byte{[]byte("save"), []byte(configMsg)})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	res = stub.MockInvoke("updateTxn", [][]byte{[]byte("save"), []byte(strings.Replace(configMsg, "config for appNametwo", "updated config", 1))})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+
+	configKey := mgmtapi.ConfigKey{MspID: "Org1MSP", PeerID: "peer.one.one.example.com", AppName: "appNameB", AppVersion: api.VERSION}
+	keyBytes, err := json.Marshal(&configKey)
+	require.NoError(t, err)
+
+	aclProvider = &mockACLProvider{aclFailed: true}
+	_, err = invoke(stub, [][]byte{[]byte("rollback"), keyBytes, []byte("saveTxn")})
+	assert.Error(t, err, "expected ACL check error")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	_, err = invoke(stub, [][]byte{[]byte("rollback"), keyBytes})
+	assert.Error(t, err, "expected error: TxID is required")
+
+	_, err = invoke(stub, [][]byte{[]byte("rollback"), keyBytes, []byte("saveTxn")})
+	require.NoError(t, err)
+
+	response, err := invoke(stub, [][]byte{[]byte("get"), keyBytes})
+	require.NoError(t, err)
+	var configs []*mgmtapi.ConfigKV
+	require.NoError(t, json.Unmarshal(response, &configs))
+	require.Len(t, configs, 1)
+	assert.Equal(t, "config for appNametwo", string(configs[0].Value))
+}
+
+func TestReindex(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	res := stub.MockInvoke("saveTxn", [][]byte{[]byte("save"), []byte(strings.Replace(validMsgMultiplePeersAndApps, "$v", api.VERSION, -1))})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+
+	keyBytes, err := json.Marshal(&mgmtapi.ConfigKey{MspID: "Org1MSP"})
+	require.NoError(t, err)
+
+	aclProvider = &mockACLProvider{failedResource: configDataWriteACLPrefix + "Org1MSP"}
+	_, err = invoke(stub, [][]byte{[]byte("reindex"), keyBytes})
+	assert.Error(t, err, "expected ACL check error")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	response, err := invoke(stub, [][]byte{[]byte("reindex"), keyBytes})
+	require.NoError(t, err)
+	result := &mgmtapi.ReindexResult{}
+	require.NoError(t, json.Unmarshal(response, result))
+	assert.NotEmpty(t, result.Reindexed)
+	for _, key := range result.Reindexed {
+		assert.Equal(t, "Org1MSP", key.MspID)
+	}
+
+	//without a key the configs of all MSPs are reindexed
+	acl := &mockACLProvider{}
+	aclProvider = acl
+	_, err = invoke(stub, [][]byte{[]byte("reindex")})
+	require.NoError(t, err)
+	assert.Contains(t, acl.checkedResources, configDataWriteACLPrefix+"Org1MSP")
+}
+
+func TestGetPage(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	configMsg := strings.Replace(validMsgMultiplePeersAndApps, "$v", api.VERSION, -1)
+	res := stub.MockInvoke("saveTxn", [][]byte{[]byte("save"), []byte(configMsg)})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+
+	keyBytes, err := json.Marshal(&mgmtapi.ConfigKey{MspID: "Org1MSP", PeerID: "peer.one.one.example.com"})
+	require.NoError(t, err)
+
+	_, err = invoke(stub, [][]byte{[]byte("get"), keyBytes, []byte("{")})
+	assert.Error(t, err, "expected error unmarshalling page options")
+
+	response, err := invoke(stub, [][]byte{[]byte("get"), keyBytes, []byte(`{"PageSize":2}`)})
+	require.NoError(t, err)
+	result := &mgmtapi.ConfigQueryResult{}
+	require.NoError(t, json.Unmarshal(response, result))
+	require.Len(t, result.Configs, 2)
+	require.NotEmpty(t, result.Bookmark)
+
+	pageOptions, err := json.Marshal(&mgmtapi.PageOptions{PageSize: 2, Bookmark: result.Bookmark})
+	require.NoError(t, err)
+	response, err = invoke(stub, [][]byte{[]byte("get"), keyBytes, pageOptions})
+	require.NoError(t, err)
+	result = &mgmtapi.ConfigQueryResult{}
+	require.NoError(t, json.Unmarshal(response, result))
+	require.Len(t, result.Configs, 1)
+	assert.Empty(t, result.Bookmark)
+	assert.Equal(t, "peer.one.one.example.com", result.Configs[0].Key.PeerID)
+}
+
 func TestGetKey(t *testing.T) {
 	_, err := getKey(nil)
 	if err == nil {
@@ -691,7 +950,7 @@ func TestGenerateKeyArgs(t *testing.T) {
 func TestGetCSRSubject(t *testing.T) {
 	stub := newMockStub(nil, nil)
 	peerConfigPath = "./sampleconfig"
-	raw, err := getCSRSubject("testChannel", "CSRCommonName")
+	raw, err := getCSRSubject("testChannel", "CSRCommonName", nil)
 	if err != nil {
 		t.Fatalf("Error %s", err)
 	}
@@ -742,17 +1001,17 @@ func TestGetBCCSPAndKeyPair(t *testing.T) {
 
 func TestGenerateKeyWithOpts(t *testing.T) {
 	peerConfigPath = "./sampleconfig"
-	rsp := generateKeyWithOpts("", nil)
-	if rsp.Message == "" {
+	_, _, err := generateKeyWithOpts("", nil)
+	if err == nil {
 		t.Fatal("Expected: Cannot obtain ledger for channel")
 	}
-	rsp = generateKeyWithOpts("testChannel", nil)
-	if rsp.Message == "" {
+	_, _, err = generateKeyWithOpts("testChannel", nil)
+	if err == nil {
 		t.Fatal("Expected: The key gen option is required")
 	}
 	opts, _ := getKeyOpts("ECDSA", false)
-	rsp = generateKeyWithOpts("testChannel", opts)
-	if rsp.Message == "" {
+	_, _, err = generateKeyWithOpts("testChannel", opts)
+	if err == nil {
 		t.Fatal("Expected: Failed initializing PKCS11 library")
 	}
 }
@@ -769,18 +1028,21 @@ func TestGetPublicKeyAlg(t *testing.T) {
 		t.Fatal("Alg should be nil")
 
 	}
-	_, err = getPublicKeyAlg("RSA")
+	alg, err = getPublicKeyAlg(&rsa.PublicKey{})
 	if err != nil {
 		t.Fatalf("Error:  %s", err)
 	}
-	_, err = getPublicKeyAlg("ECDSA")
+	assert.Equal(t, x509.RSA, alg)
+	alg, err = getPublicKeyAlg(&ecdsa.PublicKey{})
 	if err != nil {
 		t.Fatalf("Error:  %s", err)
 	}
-	_, err = getPublicKeyAlg("DSA")
+	assert.Equal(t, x509.ECDSA, alg)
+	alg, err = getPublicKeyAlg(&dsa.PublicKey{})
 	if err != nil {
 		t.Fatalf("Error:  %s", err)
 	}
+	assert.Equal(t, x509.DSA, alg)
 }
 
 func TestGetCSRConfig(t *testing.T) {
@@ -1064,11 +1326,15 @@ func getMockStub(channelID string) *mockstub.MockStub {
 
 type mockACLProvider struct {
 	aclFailed bool
+	//failedResource (optional) is the only resource for which the ACL check fails
+	failedResource   string
+	checkedResources []string
 }
 
 func (m *mockACLProvider) CheckACL(resName string, channelID string, idinfo interface{}) error {
 	aclCheckCalled = true
-	if m.aclFailed {
+	m.checkedResources = append(m.checkedResources, resName)
+	if m.aclFailed || resName == m.failedResource {
 		return fmt.Errorf("ACL failed")
 	}
 	return nil
@@ -1089,3 +1355,18 @@ func (m *mockMembershipService) GetPeersOfChannel(channelID string) ([]*membersh
 func (m *mockMembershipService) GetLocalPeer(channelID string) (*membership.PeerEndpoint, error) {
 	return nil, nil
 }
+
+type mockListener struct {
+	healthy bool
+}
+
+func (l *mockListener) Listen() (<-chan *fabApi.CCEvent, error) {
+	return make(chan *fabApi.CCEvent), nil
+}
+
+func (l *mockListener) Stop() {
+}
+
+func (l *mockListener) IsHealthy() bool {
+	return l.healthy
+}
@@ -0,0 +1,242 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/url"
+	"strconv"
+	"strings"
+
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+var (
+	oidExtensionKeyUsage         = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidExtensionSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// keyUsageRegistry is a registry of the key usages that can be requested in a CSR
+var keyUsageRegistry = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+	"encipherOnly":      x509.KeyUsageEncipherOnly,
+	"decipherOnly":      x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsageRegistry is a registry of the extended key usages that can be requested in a CSR
+var extKeyUsageRegistry = map[string]asn1.ObjectIdentifier{
+	"serverAuth":      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	"clientAuth":      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	"codeSigning":     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	"emailProtection": {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	"timeStamping":    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	"ocspSigning":     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+//validateCSRRequest checks the CSR fields of the request against the fields that are allowed by the csr config
+func validateCSRRequest(csrConfig *config.CSRConfig, req *cfgsnapapi.Request) errors.Error {
+	allowed := csrConfig.Allowed
+
+	if req.Subject != nil {
+		for _, field := range []struct{ name, value string }{
+			{"Country", req.Subject.Country},
+			{"StateProvince", req.Subject.StateProvince},
+			{"Locality", req.Subject.Locality},
+			{"Org", req.Subject.Org},
+			{"OrgUnit", req.Subject.OrgUnit},
+		} {
+			if field.value != "" && !containsFold(allowed.SubjectFields, field.name) {
+				return errors.Errorf(errors.ValidationError, "Subject field [%s] can't be requested", field.name)
+			}
+		}
+	}
+
+	for _, uri := range req.URIs {
+		if _, err := url.Parse(uri); err != nil {
+			return errors.WithMessage(errors.ValidationError, err, "Invalid URI ["+uri+"]")
+		}
+		if !hasPrefix(uri, allowed.URIPrefixes) {
+			return errors.Errorf(errors.ValidationError, "URI [%s] can't be requested", uri)
+		}
+	}
+
+	for _, usage := range req.KeyUsage {
+		if _, ok := keyUsageRegistry[usage]; !ok {
+			return errors.Errorf(errors.ValidationError, "Key usage [%s] is not supported", usage)
+		}
+		if !containsFold(allowed.KeyUsages, usage) {
+			return errors.Errorf(errors.ValidationError, "Key usage [%s] can't be requested", usage)
+		}
+	}
+
+	for _, usage := range req.ExtKeyUsage {
+		if _, ok := extKeyUsageRegistry[usage]; !ok {
+			return errors.Errorf(errors.ValidationError, "Extended key usage [%s] is not supported", usage)
+		}
+		if !containsFold(allowed.ExtKeyUsages, usage) {
+			return errors.Errorf(errors.ValidationError, "Extended key usage [%s] can't be requested", usage)
+		}
+	}
+
+	for _, ext := range req.Extensions {
+		oid, err := parseOID(ext.ID)
+		if err != nil {
+			return err
+		}
+		//these extensions are generated from the dedicated fields of the request
+		if oid.Equal(oidExtensionKeyUsage) || oid.Equal(oidExtensionExtendedKeyUsage) || oid.Equal(oidExtensionSubjectAltName) {
+			return errors.Errorf(errors.ValidationError, "Extension [%s] must be requested with its dedicated field", ext.ID)
+		}
+		if !containsFold(allowed.Extensions, oid.String()) {
+			return errors.Errorf(errors.ValidationError, "Extension [%s] can't be requested", ext.ID)
+		}
+	}
+
+	return nil
+}
+
+//overrideSubject overrides the fields of the subject with the non-empty fields of the requested subject
+func overrideSubject(subj *pkix.Name, subject *cfgsnapapi.CSRSubject) {
+	if subject == nil {
+		return
+	}
+	if subject.Country != "" {
+		subj.Country = []string{subject.Country}
+	}
+	if subject.StateProvince != "" {
+		subj.Province = []string{subject.StateProvince}
+	}
+	if subject.Locality != "" {
+		subj.Locality = []string{subject.Locality}
+	}
+	if subject.Org != "" {
+		subj.Organization = []string{subject.Org}
+	}
+	if subject.OrgUnit != "" {
+		subj.OrganizationalUnit = []string{subject.OrgUnit}
+	}
+}
+
+//getCSRURIs returns the configured URI subject alternative names followed by the requested ones
+func getCSRURIs(csrConfig *config.CSRConfig, req *cfgsnapapi.Request) ([]*url.URL, errors.Error) {
+	uris := append([]*url.URL{}, csrConfig.URIs...)
+	for _, v := range req.URIs {
+		uri, err := url.Parse(v)
+		if err != nil {
+			return nil, errors.WithMessage(errors.ValidationError, err, "Invalid URI ["+v+"]")
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+//getCSRExtensions returns the key usage, extended key usage and extra extensions of the request
+func getCSRExtensions(req *cfgsnapapi.Request) ([]pkix.Extension, errors.Error) {
+	var extensions []pkix.Extension
+
+	if len(req.KeyUsage) > 0 {
+		var keyUsage x509.KeyUsage
+		for _, usage := range req.KeyUsage {
+			ku, ok := keyUsageRegistry[usage]
+			if !ok {
+				return nil, errors.Errorf(errors.ValidationError, "Key usage [%s] is not supported", usage)
+			}
+			keyUsage |= ku
+		}
+		value, err := marshalKeyUsage(keyUsage)
+		if err != nil {
+			return nil, errors.WithMessage(errors.SystemError, err, "Failed to marshal key usage")
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionKeyUsage, Critical: true, Value: value})
+	}
+
+	if len(req.ExtKeyUsage) > 0 {
+		var oids []asn1.ObjectIdentifier
+		for _, usage := range req.ExtKeyUsage {
+			oid, ok := extKeyUsageRegistry[usage]
+			if !ok {
+				return nil, errors.Errorf(errors.ValidationError, "Extended key usage [%s] is not supported", usage)
+			}
+			oids = append(oids, oid)
+		}
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return nil, errors.WithMessage(errors.SystemError, err, "Failed to marshal extended key usage")
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidExtensionExtendedKeyUsage, Value: value})
+	}
+
+	for _, ext := range req.Extensions {
+		oid, err := parseOID(ext.ID)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oid, Critical: ext.Critical, Value: ext.Value})
+	}
+
+	return extensions, nil
+}
+
+//marshalKeyUsage marshals the key usage extension value as a DER bit string (RFC 5280, section 4.2.1.3)
+func marshalKeyUsage(keyUsage x509.KeyUsage) ([]byte, error) {
+	bits := asn1.BitString{Bytes: make([]byte, 2)}
+	for i := uint(0); i < 9; i++ {
+		if keyUsage&(1<<i) != 0 {
+			bits.Bytes[i/8] |= 0x80 >> (i % 8)
+			bits.BitLength = int(i) + 1
+		}
+	}
+	//DER requires the trailing zero bits to be removed
+	bits.Bytes = bits.Bytes[:(bits.BitLength+7)/8]
+	return asn1.Marshal(bits)
+}
+
+//parseOID parses an OID in dotted notation
+func parseOID(id string) (asn1.ObjectIdentifier, errors.Error) {
+	parts := strings.Split(id, ".")
+	if len(parts) < 2 {
+		return nil, errors.Errorf(errors.ValidationError, "Invalid OID [%s]", id)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf(errors.ValidationError, "Invalid OID [%s]", id)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testExtensionOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+func TestGenerateCSRWithExtensions(t *testing.T) {
+	_, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+
+	req := &cfgsnapapi.Request{
+		KeyType:            "ECDSAP256",
+		Ephemeral:          true,
+		SignatureAlgorithm: "ECDSAWithSHA256",
+		CommonName:         "cn",
+		Subject:            &cfgsnapapi.CSRSubject{OrgUnit: "Payments"},
+		URIs:               []string{"spiffe://securekey.com/payments"},
+		KeyUsage:           []string{"digitalSignature", "keyEncipherment"},
+		ExtKeyUsage:        []string{"clientAuth"},
+		Extensions:         []cfgsnapapi.CSRExtension{{ID: testExtensionOID.String(), Value: []byte{0x05, 0x00}}},
+	}
+	res := stub.MockInvoke("txID", [][]byte{[]byte("generateCSR"), newRequestBytes(t, req)})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+
+	csr, err := x509.ParseCertificateRequest(unmarshalResponse(t, res.Payload).Payload)
+	require.NoError(t, err)
+	require.NoError(t, csr.CheckSignature())
+
+	assert.Equal(t, x509.ECDSA, csr.PublicKeyAlgorithm)
+	assert.Equal(t, "cn", csr.Subject.CommonName)
+	assert.Equal(t, []string{"Payments"}, csr.Subject.OrganizationalUnit)
+	assert.Equal(t, []string{"SK"}, csr.Subject.Organization)
+	require.Len(t, csr.URIs, 2)
+	assert.Equal(t, "spiffe://securekey.com/fabric-snaps", csr.URIs[0].String())
+	assert.Equal(t, "spiffe://securekey.com/payments", csr.URIs[1].String())
+	assert.Equal(t, []string{"a.b.c", "something.com"}, csr.DNSNames)
+
+	extensions := make(map[string]pkix.Extension)
+	for _, ext := range csr.Extensions {
+		extensions[ext.Id.String()] = ext
+	}
+	assert.True(t, extensions[oidExtensionKeyUsage.String()].Critical)
+	var ekus []asn1.ObjectIdentifier
+	_, err = asn1.Unmarshal(extensions[oidExtensionExtendedKeyUsage.String()].Value, &ekus)
+	require.NoError(t, err)
+	assert.Equal(t, []asn1.ObjectIdentifier{extKeyUsageRegistry["clientAuth"]}, ekus)
+	assert.Equal(t, []byte{0x05, 0x00}, extensions[testExtensionOID.String()].Value)
+
+	//fields that are not allowed by the csr config are rejected before a key is generated
+	req.Ephemeral = false
+	req.Subject = &cfgsnapapi.CSRSubject{Org: "Other"}
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateCSR"), newRequestBytes(t, req)})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.ValidationError), unmarshalResponse(t, res.Payload).ErrorCode)
+	assert.Empty(t, listTestKeys(t, stub))
+}
+
+func TestValidateCSRRequest(t *testing.T) {
+	csrConfig := &config.CSRConfig{Allowed: config.CSRAllowList{
+		SubjectFields: []string{"OrgUnit", "locality"},
+		URIPrefixes:   []string{"spiffe://securekey.com/"},
+		KeyUsages:     []string{"digitalSignature"},
+		ExtKeyUsages:  []string{"serverAuth"},
+		Extensions:    []string{testExtensionOID.String()},
+	}}
+
+	assert.NoError(t, validateCSRRequest(csrConfig, &cfgsnapapi.Request{}))
+	assert.NoError(t, validateCSRRequest(csrConfig, &cfgsnapapi.Request{
+		Subject:     &cfgsnapapi.CSRSubject{OrgUnit: "ou", Locality: "l"},
+		URIs:        []string{"spiffe://securekey.com/app"},
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"serverAuth"},
+		Extensions:  []cfgsnapapi.CSRExtension{{ID: testExtensionOID.String()}},
+	}))
+
+	for _, req := range []*cfgsnapapi.Request{
+		{Subject: &cfgsnapapi.CSRSubject{Country: "US"}},
+		{URIs: []string{"spiffe://other.com/app"}},
+		{KeyUsage: []string{"certSign"}},
+		{KeyUsage: []string{"unknown"}},
+		{ExtKeyUsage: []string{"clientAuth"}},
+		{ExtKeyUsage: []string{"unknown"}},
+		{Extensions: []cfgsnapapi.CSRExtension{{ID: "1.2.3.4"}}},
+		{Extensions: []cfgsnapapi.CSRExtension{{ID: "not an oid"}}},
+		{Extensions: []cfgsnapapi.CSRExtension{{ID: oidExtensionSubjectAltName.String()}}},
+	} {
+		err := validateCSRRequest(csrConfig, req)
+		require.Error(t, err, "%+v", req)
+		assert.Equal(t, errors.ValidationError, err.ErrorCode())
+	}
+
+	//nothing can be requested if the csr config has no allow-list
+	assert.Error(t, validateCSRRequest(&config.CSRConfig{}, &cfgsnapapi.Request{KeyUsage: []string{"digitalSignature"}}))
+}
+
+func TestMarshalKeyUsage(t *testing.T) {
+	//the key usage extension must be encoded the same way as by the x509 package
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	for _, keyUsage := range []x509.KeyUsage{
+		x509.KeyUsageDigitalSignature,
+		x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		x509.KeyUsageDigitalSignature | x509.KeyUsageDecipherOnly,
+	} {
+		template := &x509.Certificate{SerialNumber: big.NewInt(1), KeyUsage: keyUsage}
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(certBytes)
+		require.NoError(t, err)
+
+		value, err := marshalKeyUsage(keyUsage)
+		require.NoError(t, err)
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidExtensionKeyUsage) {
+				assert.Equal(t, ext.Value, value, "key usage %d", keyUsage)
+			}
+		}
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	oid, err := parseOID("1.3.6.1.4.1.99999.1")
+	require.NoError(t, err)
+	assert.True(t, oid.Equal(testExtensionOID))
+
+	for _, id := range []string{"", "1", "1.a", "1.-2", "1..2"} {
+		_, err = parseOID(id)
+		assert.Error(t, err, id)
+	}
+}
@@ -0,0 +1,453 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+const (
+	// keyRegistryDirName is the directory (under the peer's file system path) of the key registry
+	keyRegistryDirName = "configsnapkeys"
+
+	// swProvider is the ID of the SW BCCSP provider
+	swProvider = "SW"
+
+	// pkcs11Provider is the ID of the PKCS11 BCCSP provider
+	pkcs11Provider = "PKCS11"
+
+	// defaultDigestAlgorithm is the digest algorithm of sign and verify if none is requested
+	defaultDigestAlgorithm = "SHA256"
+)
+
+// digestAlgorithm is a digest algorithm supported by sign and verify
+type digestAlgorithm struct {
+	hashOpts bccsp.HashOpts
+	hash     crypto.Hash
+}
+
+// digestRegistry is a registry of the digest algorithms supported by sign and verify
+var digestRegistry = map[string]digestAlgorithm{
+	"SHA256":   {hashOpts: &bccsp.SHA256Opts{}, hash: crypto.SHA256},
+	"SHA384":   {hashOpts: &bccsp.SHA384Opts{}, hash: crypto.SHA384},
+	"SHA3_256": {hashOpts: &bccsp.SHA3_256Opts{}, hash: crypto.SHA3_256},
+	"SHA3_384": {hashOpts: &bccsp.SHA3_384Opts{}, hash: crypto.SHA3_384},
+}
+
+// keyRegistryMtx serializes access to the key registry
+var keyRegistryMtx sync.RWMutex
+
+// getConfiguredBCCSP returns the BCCSP of the provider that is configured in the peer config and the ID of the provider
+var getConfiguredBCCSP = func() (bccsp.BCCSP, string, errors.Error) {
+	bccspProvider, codedErr := config.GetBCCSPProvider(peerConfigPath)
+	if codedErr != nil {
+		return nil, "", codedErr
+	}
+	logger.Debugf("***Configured BCCSP provider's ID is %s", bccspProvider)
+	bccspsuite, err := factory.GetBCCSP(bccspProvider)
+	if err != nil {
+		logger.Debugf("Error getting BCCSP based on provider ID %s %s", bccspProvider, err)
+		return nil, "", errors.Wrap(errors.GeneralError, err, "BCCSP Initialize failed")
+	}
+	logger.Debugf("***Configured BCCSP provider is %s", reflect.TypeOf(bccspsuite))
+	return bccspsuite, bccspProvider, nil
+}
+
+// getKeyRegistryDir returns the directory of the key registry, which contains the metadata of the non-ephemeral
+// keys that were generated by the snap
+var getKeyRegistryDir = func() (string, errors.Error) {
+	fileSystemPath, err := config.GetFileSystemPath(peerConfigPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(fileSystemPath, keyRegistryDirName), nil
+}
+
+// getSWKeyStorePath returns the key store path of the SW BCCSP provider
+var getSWKeyStorePath = func() (string, errors.Error) {
+	return config.GetSWKeyStorePath(peerConfigPath)
+}
+
+//listKeys returns the metadata of the non-ephemeral keys that were generated by the snap on the channel, sorted by
+//creation time
+func listKeys(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := checkPeerACL(stub, configDataReadACLPrefix); err != nil {
+		return nil, err
+	}
+
+	keys, err := readKeyInfos(stub.GetChannelID())
+	if err != nil {
+		return nil, err
+	}
+
+	payload, e := json.Marshal(keys)
+	if e != nil {
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to marshal keys")
+	}
+	return payload, nil
+}
+
+//getPublicKey returns the public key of a key that was generated by the snap on the channel
+func getPublicKey(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := checkPeerACL(stub, configDataReadACLPrefix); err != nil {
+		return nil, err
+	}
+
+	k, _, err := getRegisteredKey(stub.GetChannelID(), req.SKI)
+	if err != nil {
+		return nil, err
+	}
+	return parseKey(k)
+}
+
+//deleteKey destroys a key that was generated by the snap on the channel and removes it from the key registry
+func deleteKey(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if err := checkPeerACL(stub, configDataWriteACLPrefix); err != nil {
+		return nil, err
+	}
+
+	keyInfo, err := getKeyInfo(stub.GetChannelID(), req.SKI)
+	if err != nil {
+		return nil, err
+	}
+	ski, e := hex.DecodeString(keyInfo.SKI)
+	if e != nil {
+		return nil, errors.WithMessage(errors.ValidationError, e, "SKI must be hex encoded")
+	}
+
+	_, provider, err := getConfiguredBCCSP()
+	if err != nil {
+		return nil, err
+	}
+	if err := destroyKey(provider, ski); err != nil {
+		return nil, err
+	}
+	if err := unregisterKey(keyInfo.SKI); err != nil {
+		return nil, err
+	}
+	logger.Infof("Deleted key [%s] with label [%s] on channel [%s]", keyInfo.SKI, keyInfo.Label, keyInfo.ChannelID)
+	return nil, nil
+}
+
+//sign signs the data of the request with a key that was generated by the snap on the channel. The caller must
+//satisfy the policy of the key's use resource (keys/use/<SKI>).
+func sign(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	csp, k, signerOpts, digest, err := prepareKeyUse(stub, req)
+	if err != nil {
+		return nil, err
+	}
+	signature, e := csp.Sign(k, digest, signerOpts)
+	if e != nil {
+		return nil, errors.Wrapf(errors.GeneralError, e, "Failed to sign with key [%s]", req.SKI)
+	}
+	return signature, nil
+}
+
+//verify verifies the signature of the data of the request with a key that was generated by the snap on the channel.
+//The payload is true if the signature is valid, otherwise false. The caller must satisfy the policy of the key's
+//use resource (keys/use/<SKI>).
+func verify(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request, metrics *Metrics) ([]byte, errors.Error) {
+	if len(req.Signature) == 0 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Signature is required")
+	}
+	csp, k, signerOpts, digest, err := prepareKeyUse(stub, req)
+	if err != nil {
+		return nil, err
+	}
+	valid, e := csp.Verify(k, req.Signature, digest, signerOpts)
+	if e != nil {
+		logger.Debugf("Failed to verify signature with key [%s]: %s", req.SKI, e)
+		valid = false
+	}
+	return []byte(strconv.FormatBool(valid)), nil
+}
+
+//prepareKeyUse checks the ACL of the caller for the use of the requested key and returns the BCCSP, the key, the
+//signer options and the digest of the data of the request
+func prepareKeyUse(stub shim.ChaincodeStubInterface, req *cfgsnapapi.Request) (bccsp.BCCSP, bccsp.Key, bccsp.SignerOpts, []byte, errors.Error) {
+	if req.SKI == "" {
+		return nil, nil, nil, nil, errors.New(errors.MissingRequiredParameterError, "SKI is required")
+	}
+	if len(req.Data) == 0 {
+		return nil, nil, nil, nil, errors.New(errors.MissingRequiredParameterError, "Data is required")
+	}
+	digestAlgName := req.DigestAlgorithm
+	if digestAlgName == "" {
+		digestAlgName = defaultDigestAlgorithm
+	}
+	digestAlg, ok := digestRegistry[digestAlgName]
+	if !ok {
+		return nil, nil, nil, nil, errors.Errorf(errors.ValidationError, "Digest algorithm [%s] is not supported", digestAlgName)
+	}
+	if err := checkACL(stub, keyUseACLPrefix+strings.ToLower(req.SKI)); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	k, keyInfo, err := getRegisteredKey(stub.GetChannelID(), req.SKI)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	csp, _, err := getConfiguredBCCSP()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	digest, e := csp.Hash(req.Data, digestAlg.hashOpts)
+	if e != nil {
+		return nil, nil, nil, nil, errors.Wrapf(errors.GeneralError, e, "Failed to compute %s digest", digestAlgName)
+	}
+
+	//BCCSP only supports PSS signatures for RSA keys. The hash is ignored for ECDSA keys.
+	var signerOpts bccsp.SignerOpts = digestAlg.hash
+	if strings.HasPrefix(keyInfo.KeyType, publicKeyAlgRSA) {
+		signerOpts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: digestAlg.hash}
+	}
+	return csp, k, signerOpts, digest, nil
+}
+
+//checkPeerACL checks the ACL of the caller for the configs of the peer's MSP
+func checkPeerACL(stub shim.ChaincodeStubInterface, aclResourcePrefix string) errors.Error {
+	peerMspID, err := config.GetPeerMSPID(peerConfigPath)
+	if err != nil {
+		return errors.WithMessage(errors.UnmarshalError, err, "Failed to get peer msp ID")
+	}
+	return checkACLforKey(stub, &mgmtapi.ConfigKey{MspID: peerMspID}, aclResourcePrefix)
+}
+
+//registerKey adds the metadata of a non-ephemeral key that was generated by the snap to the key registry
+func registerKey(stub shim.ChaincodeStubInterface, k bccsp.Key, keyType string, label string) errors.Error {
+	creatorMspID, err := getMspID(stub)
+	if err != nil {
+		logger.Warnf("Failed to get the MSP of the creator of key [%x]: %s", k.SKI(), err)
+	}
+	keyInfo := &cfgsnapapi.KeyInfo{
+		SKI:          hex.EncodeToString(k.SKI()),
+		KeyType:      keyType,
+		Label:        label,
+		Created:      time.Now().UTC(),
+		CreatorMspID: creatorMspID,
+		ChannelID:    stub.GetChannelID(),
+	}
+
+	dir, err := getKeyRegistryDir()
+	if err != nil {
+		return err
+	}
+
+	keyRegistryMtx.Lock()
+	defer keyRegistryMtx.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to create key registry directory")
+	}
+	keyInfoBytes, e := json.Marshal(keyInfo)
+	if e != nil {
+		return errors.WithMessage(errors.SystemError, e, "Failed to marshal key info")
+	}
+	//write to a temporary file first so that a crash never leaves a partially written entry
+	tmpFile := getKeyInfoFile(dir, keyInfo.SKI) + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, keyInfoBytes, 0600); err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to write key info")
+	}
+	if err := os.Rename(tmpFile, getKeyInfoFile(dir, keyInfo.SKI)); err != nil {
+		return errors.WithMessage(errors.SystemError, err, "Failed to write key info")
+	}
+	return nil
+}
+
+//unregisterKey removes a key from the key registry
+func unregisterKey(ski string) errors.Error {
+	dir, err := getKeyRegistryDir()
+	if err != nil {
+		return err
+	}
+
+	keyRegistryMtx.Lock()
+	defer keyRegistryMtx.Unlock()
+
+	if err := os.Remove(getKeyInfoFile(dir, ski)); err != nil && !os.IsNotExist(err) {
+		return errors.WithMessage(errors.SystemError, err, "Failed to remove key info")
+	}
+	return nil
+}
+
+//readKeyInfos returns the metadata of the registered keys of the channel, sorted by creation time
+func readKeyInfos(channelID string) ([]*cfgsnapapi.KeyInfo, errors.Error) {
+	dir, err := getKeyRegistryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	keyRegistryMtx.RLock()
+	defer keyRegistryMtx.RUnlock()
+
+	files, e := ioutil.ReadDir(dir)
+	if e != nil {
+		if os.IsNotExist(e) {
+			return []*cfgsnapapi.KeyInfo{}, nil
+		}
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to read key registry")
+	}
+
+	keys := []*cfgsnapapi.KeyInfo{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		keyInfo, err := readKeyInfo(filepath.Join(dir, file.Name()))
+		if err != nil {
+			logger.Warnf("Skipping invalid key registry entry [%s]: %s", file.Name(), err)
+			continue
+		}
+		if keyInfo.ChannelID == channelID {
+			keys = append(keys, keyInfo)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+//getKeyInfo returns the metadata of the registered key with the given (hex encoded) SKI on the channel
+func getKeyInfo(channelID string, ski string) (*cfgsnapapi.KeyInfo, errors.Error) {
+	if ski == "" {
+		return nil, errors.New(errors.MissingRequiredParameterError, "SKI is required")
+	}
+	if _, err := hex.DecodeString(ski); err != nil {
+		return nil, errors.WithMessage(errors.ValidationError, err, "SKI must be hex encoded")
+	}
+	dir, err := getKeyRegistryDir()
+	if err != nil {
+		return nil, err
+	}
+
+	keyRegistryMtx.RLock()
+	defer keyRegistryMtx.RUnlock()
+
+	keyInfo, e := readKeyInfo(getKeyInfoFile(dir, strings.ToLower(ski)))
+	if e != nil {
+		if os.IsNotExist(e) {
+			return nil, errors.Errorf(errors.MissingConfigDataError, "Key [%s] was not generated by the configuration snap", ski)
+		}
+		return nil, errors.WithMessage(errors.SystemError, e, "Failed to read key info")
+	}
+	//keys are only visible on the channel on which they were generated
+	if keyInfo.ChannelID != channelID {
+		return nil, errors.Errorf(errors.MissingConfigDataError, "Key [%s] was not generated by the configuration snap on channel [%s]", ski, channelID)
+	}
+	return keyInfo, nil
+}
+
+//getRegisteredKey returns the BCCSP key of the registered key with the given (hex encoded) SKI on the channel
+func getRegisteredKey(channelID string, ski string) (bccsp.Key, *cfgsnapapi.KeyInfo, errors.Error) {
+	keyInfo, err := getKeyInfo(channelID, ski)
+	if err != nil {
+		return nil, nil, err
+	}
+	skiBytes, e := hex.DecodeString(keyInfo.SKI)
+	if e != nil {
+		return nil, nil, errors.WithMessage(errors.ValidationError, e, "SKI must be hex encoded")
+	}
+
+	csp, _, err := getConfiguredBCCSP()
+	if err != nil {
+		return nil, nil, err
+	}
+	k, e := csp.GetKey(skiBytes)
+	if e != nil {
+		return nil, nil, errors.Wrapf(errors.GeneralError, e, "Failed to get key [%s]", keyInfo.SKI)
+	}
+	return k, keyInfo, nil
+}
+
+func readKeyInfo(file string) (*cfgsnapapi.KeyInfo, error) {
+	keyInfoBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keyInfo := &cfgsnapapi.KeyInfo{}
+	if err := json.Unmarshal(keyInfoBytes, keyInfo); err != nil {
+		return nil, err
+	}
+	return keyInfo, nil
+}
+
+func getKeyInfoFile(dir, ski string) string {
+	return filepath.Join(dir, ski+".json")
+}
+
+//destroyKey destroys the key with the given SKI in the given BCCSP provider
+func destroyKey(provider string, ski []byte) errors.Error {
+	switch provider {
+	case swProvider:
+		return destroySWKey(ski)
+	case pkcs11Provider:
+		return destroyPKCS11Key(ski)
+	default:
+		return errors.Errorf(errors.GeneralError, "Deleting keys is not supported by BCCSP provider %s", provider)
+	}
+}
+
+//checkKeyDestroyable returns an error if a key generated by the configured BCCSP provider couldn't be destroyed when
+//its registration fails, in which case the key must not be generated. Ephemeral keys aren't registered, and destroying
+//PKCS11 keys requires a build with the pkcs11 tag.
+func checkKeyDestroyable(ephemeral bool) errors.Error {
+	if ephemeral {
+		return nil
+	}
+	_, provider, err := getConfiguredBCCSP()
+	if err != nil {
+		return err
+	}
+	if provider == pkcs11Provider && !pkcs11KeysDestroyable {
+		return errors.New(errors.GeneralError, "Generating non-ephemeral PKCS11 keys requires a build with the pkcs11 tag")
+	}
+	return nil
+}
+
+//destroySWKey removes the files of the key with the given SKI from the key store of the SW provider
+func destroySWKey(ski []byte) errors.Error {
+	keyStorePath, err := getSWKeyStorePath()
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	alias := hex.EncodeToString(ski)
+	for _, suffix := range []string{"_sk", "_pk", "_key"} {
+		e := os.Remove(filepath.Join(keyStorePath, alias+suffix))
+		if e == nil {
+			removed = true
+		} else if !os.IsNotExist(e) {
+			return errors.Wrapf(errors.SystemError, e, "Failed to remove key [%s] from key store", alias)
+		}
+	}
+	if !removed {
+		logger.Warnf("Key [%s] was not found in key store [%s]", alias, keyStorePath)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+// +build !pkcs11
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+//pkcs11KeysDestroyable is false since the PKCS11 keys can't be destroyed without the pkcs11 build tag
+const pkcs11KeysDestroyable = false
+
+//destroyPKCS11Key is not supported without the pkcs11 build tag
+func destroyPKCS11Key(ski []byte) errors.Error {
+	return errors.New(errors.GeneralError, "Deleting PKCS11 keys requires a build with the pkcs11 tag")
+}
@@ -0,0 +1,109 @@
+// +build pkcs11
+
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/pkcs11"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+//pkcs11KeysDestroyable is true since the PKCS11 keys can be destroyed in this build
+const pkcs11KeysDestroyable = true
+
+//destroyPKCS11Key destroys the private and public key objects whose ID is the given SKI in the token of the
+//PKCS11 provider. The library is shared with the peer's BCCSP so it is neither finalized nor logged out.
+func destroyPKCS11Key(ski []byte) errors.Error {
+	pkcs11Config, err := config.GetPKCS11Config(peerConfigPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := pkcs11.New(pkcs11Config.Library)
+	if ctx == nil {
+		return errors.Errorf(errors.SystemError, "Failed to load PKCS11 library [%s]", pkcs11Config.Library)
+	}
+	if e := ctx.Initialize(); e != nil && e != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return errors.Wrap(errors.SystemError, e, "Failed to initialize PKCS11 library")
+	}
+
+	slot, err := findPKCS11Slot(ctx, pkcs11Config.Label)
+	if err != nil {
+		return err
+	}
+	session, e := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if e != nil {
+		return errors.Wrap(errors.SystemError, e, "Failed to open PKCS11 session")
+	}
+	defer ctx.CloseSession(session)
+
+	if e := ctx.Login(session, pkcs11.CKU_USER, pkcs11Config.Pin); e != nil && e != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return errors.Wrap(errors.SystemError, e, "Failed to log in to PKCS11 token")
+	}
+
+	destroyed := 0
+	for _, class := range []uint{pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_PUBLIC_KEY} {
+		objects, err := findPKCS11Objects(ctx, session, class, ski)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if e := ctx.DestroyObject(session, object); e != nil {
+				return errors.Wrapf(errors.SystemError, e, "Failed to destroy PKCS11 object of key [%x]", ski)
+			}
+			destroyed++
+		}
+	}
+	if destroyed == 0 {
+		logger.Warnf("Key [%x] was not found in PKCS11 token [%s]", ski, pkcs11Config.Label)
+	}
+	return nil
+}
+
+func findPKCS11Slot(ctx *pkcs11.Ctx, label string) (uint, errors.Error) {
+	slots, e := ctx.GetSlotList(true)
+	if e != nil {
+		return 0, errors.Wrap(errors.SystemError, e, "Failed to get PKCS11 slots")
+	}
+	for _, slot := range slots {
+		info, e := ctx.GetTokenInfo(slot)
+		if e != nil {
+			continue
+		}
+		if strings.TrimSpace(info.Label) == label {
+			return slot, nil
+		}
+	}
+	return 0, errors.Errorf(errors.SystemError, "PKCS11 token with label [%s] not found", label)
+}
+
+func findPKCS11Objects(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, ski []byte) ([]pkcs11.ObjectHandle, errors.Error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, ski),
+	}
+	if e := ctx.FindObjectsInit(session, template); e != nil {
+		return nil, errors.Wrap(errors.SystemError, e, "Failed to find PKCS11 objects")
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	var objects []pkcs11.ObjectHandle
+	for {
+		found, _, e := ctx.FindObjects(session, 10)
+		if e != nil {
+			return nil, errors.Wrap(errors.SystemError, e, "Failed to find PKCS11 objects")
+		}
+		if len(found) == 0 {
+			return objects, nil
+		}
+		objects = append(objects, found...)
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/sw"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLifecycle(t *testing.T) {
+	keyStorePath, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+
+	//ephemeral keys are not registered
+	res := stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Ephemeral: true})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	assert.Empty(t, listTestKeys(t, stub))
+
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Label: "first"})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	firstPubKey := unmarshalResponse(t, res.Payload).Payload
+
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateCSR"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Label: "second", SignatureAlgorithm: "ECDSAWithSHA256", CommonName: "cn"})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+
+	keys := listTestKeys(t, stub)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "first", keys[0].Label)
+	assert.Equal(t, "second", keys[1].Label)
+	assert.Equal(t, "ECDSA", keys[0].KeyType)
+	assert.Equal(t, "testChannel", keys[0].ChannelID)
+	assert.False(t, keys[0].Created.IsZero())
+
+	//public key
+	res = stub.MockInvoke("txID", [][]byte{[]byte("getPublicKey"), []byte(keys[0].SKI)})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	assert.Equal(t, firstPubKey, res.Payload)
+
+	//keys are not visible on other channels
+	otherStub := getMockStub("otherChannel")
+	assert.Empty(t, listTestKeys(t, otherStub))
+	res = otherStub.MockInvoke("txID", [][]byte{[]byte("getPublicKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.MissingConfigDataError), unmarshalResponse(t, res.Payload).ErrorCode)
+	res = otherStub.MockInvoke("txID", [][]byte{[]byte("deleteKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+
+	//delete
+	aclProvider = &mockACLProvider{failedResource: configDataWriteACLPrefix + "Org1MSP"}
+	res = stub.MockInvoke("txID", [][]byte{[]byte("deleteKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	aclProvider = &mockACLProvider{aclFailed: false}
+
+	ski, err := hex.DecodeString(keys[0].SKI)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(keyStorePath, hex.EncodeToString(ski)+"_sk"))
+	require.NoError(t, err)
+
+	res = stub.MockInvoke("txID", [][]byte{[]byte("deleteKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	_, err = os.Stat(filepath.Join(keyStorePath, hex.EncodeToString(ski)+"_sk"))
+	assert.True(t, os.IsNotExist(err))
+
+	keys = listTestKeys(t, stub)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "second", keys[0].Label)
+
+	//unknown and invalid SKIs
+	res = stub.MockInvoke("txID", [][]byte{[]byte("getPublicKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: hex.EncodeToString(ski)})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.MissingConfigDataError), unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("getPublicKey"), newRequestBytes(t, &cfgsnapapi.Request{SKI: "not hex"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.ValidationError), unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("deleteKey"), newRequestBytes(t, &cfgsnapapi.Request{})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, errors.MissingRequiredParameterError, unmarshalResponse(t, res.Payload).ErrorCode)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	_, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+	data := []byte("data to sign")
+
+	for _, keyType := range []string{"ECDSA", "RSA2048"} {
+		res := stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: keyType})})
+		require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	}
+	keys := listTestKeys(t, stub)
+	require.Len(t, keys, 2)
+
+	for _, key := range keys {
+		for _, digestAlg := range []string{"", "SHA384", "SHA3_256"} {
+			acl := &mockACLProvider{}
+			aclProvider = acl
+			res := stub.MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: key.SKI, DigestAlgorithm: digestAlg, Data: data})})
+			require.Equal(t, int32(shim.OK), res.Status, res.Message)
+			signature := unmarshalResponse(t, res.Payload).Payload
+			assert.Equal(t, []string{keyUseACLPrefix + key.SKI}, acl.checkedResources)
+
+			res = stub.MockInvoke("txID", [][]byte{[]byte("verify"), newRequestBytes(t, &cfgsnapapi.Request{SKI: key.SKI, DigestAlgorithm: digestAlg, Signature: signature, Data: data})})
+			require.Equal(t, int32(shim.OK), res.Status, res.Message)
+			assert.Equal(t, "true", string(unmarshalResponse(t, res.Payload).Payload), key.KeyType)
+
+			res = stub.MockInvoke("txID", [][]byte{[]byte("verify"), newRequestBytes(t, &cfgsnapapi.Request{SKI: key.SKI, DigestAlgorithm: digestAlg, Signature: signature, Data: []byte("other data")})})
+			require.Equal(t, int32(shim.OK), res.Status, res.Message)
+			assert.Equal(t, "false", string(unmarshalResponse(t, res.Payload).Payload), key.KeyType)
+		}
+	}
+
+	//positional args
+	aclProvider = &mockACLProvider{}
+	signature, err := invoke(stub, [][]byte{[]byte("sign"), []byte(keys[0].SKI), []byte("SHA256"), data})
+	require.NoError(t, err)
+	valid, err := invoke(stub, [][]byte{[]byte("verify"), []byte(keys[0].SKI), signature, data})
+	require.NoError(t, err)
+	assert.Equal(t, "true", string(valid))
+	_, err = invoke(stub, [][]byte{[]byte("sign"), []byte(keys[0].SKI), []byte("SHA256")})
+	assert.Error(t, err)
+
+	//the caller must satisfy the policy of the key's use resource
+	aclProvider = &mockACLProvider{failedResource: keyUseACLPrefix + keys[0].SKI}
+	res := stub.MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI, Data: data})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, errors.ACLCheckError, unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[1].SKI, Data: data})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	aclProvider = &mockACLProvider{aclFailed: false}
+
+	//invalid requests
+	res = stub.MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI, DigestAlgorithm: "MD5", Data: data})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.ValidationError), unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, errors.MissingRequiredParameterError, unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("verify"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI, Data: data})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, errors.MissingRequiredParameterError, unmarshalResponse(t, res.Payload).ErrorCode)
+
+	//keys of other channels can't be used
+	res = getMockStub("otherChannel").MockInvoke("txID", [][]byte{[]byte("sign"), newRequestBytes(t, &cfgsnapapi.Request{SKI: keys[0].SKI, Data: data})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.MissingConfigDataError), unmarshalResponse(t, res.Payload).ErrorCode)
+}
+
+func TestListKeysACLFailure(t *testing.T) {
+	_, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: true}
+	defer func() { aclProvider = &mockACLProvider{aclFailed: false} }()
+
+	_, err := listKeys(getMockStub("testChannel"), &cfgsnapapi.Request{}, nil)
+	assert.Error(t, err)
+}
+
+func TestGenerateKeyRegistrationFailure(t *testing.T) {
+	keyStorePath, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+	getKeyRegistryDir = func() (string, errors.Error) {
+		return "", errors.New(errors.SystemError, "key registry is not available")
+	}
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+
+	//the generated key is destroyed if it can't be registered
+	res := stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Label: "unregistered"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateCSR"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Label: "unregistered", SignatureAlgorithm: "ECDSAWithSHA256", CommonName: "cn"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	files, err := filepath.Glob(filepath.Join(keyStorePath, "*_sk"))
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestGenerateKeyNotDestroyable(t *testing.T) {
+	if pkcs11KeysDestroyable {
+		t.Skip("PKCS11 keys can be destroyed with the pkcs11 build tag")
+	}
+	keyStorePath, cleanup := setupKeyRegistry(t)
+	defer cleanup()
+	csp, _, err := getConfiguredBCCSP()
+	require.NoError(t, err)
+	getConfiguredBCCSP = func() (bccsp.BCCSP, string, errors.Error) { return csp, pkcs11Provider, nil }
+
+	peerConfigPath = "./sampleconfig"
+	aclProvider = &mockACLProvider{aclFailed: false}
+	stub := getMockStub("testChannel")
+
+	//a PKCS11 key that couldn't be destroyed if its registration failed is not generated
+	res := stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	assert.Equal(t, string(errors.GeneralError), unmarshalResponse(t, res.Payload).ErrorCode)
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateCSR"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", SignatureAlgorithm: "ECDSAWithSHA256", CommonName: "cn"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	files, e := filepath.Glob(filepath.Join(keyStorePath, "*_sk"))
+	require.NoError(t, e)
+	assert.Empty(t, files)
+
+	//ephemeral keys aren't registered so they can still be generated
+	res = stub.MockInvoke("txID", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "ECDSA", Ephemeral: true})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+}
+
+func TestDestroyKeyUnsupportedProvider(t *testing.T) {
+	err := destroyKey("PLUGIN", []byte("ski"))
+	require.Error(t, err)
+	assert.Equal(t, errors.GeneralError, err.ErrorCode())
+}
+
+//setupKeyRegistry configures a SW BCCSP, a key store and a key registry in temporary directories
+func setupKeyRegistry(t *testing.T) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "configsnapkeys")
+	require.NoError(t, err)
+	keyStorePath := filepath.Join(tmpDir, "keystore")
+	csp, err := sw.NewDefaultSecurityLevel(keyStorePath)
+	require.NoError(t, err)
+
+	prevGetConfiguredBCCSP, prevGetKeyRegistryDir, prevGetSWKeyStorePath := getConfiguredBCCSP, getKeyRegistryDir, getSWKeyStorePath
+	getConfiguredBCCSP = func() (bccsp.BCCSP, string, errors.Error) { return csp, swProvider, nil }
+	getKeyRegistryDir = func() (string, errors.Error) { return filepath.Join(tmpDir, keyRegistryDirName), nil }
+	getSWKeyStorePath = func() (string, errors.Error) { return keyStorePath, nil }
+
+	return keyStorePath, func() {
+		getConfiguredBCCSP, getKeyRegistryDir, getSWKeyStorePath = prevGetConfiguredBCCSP, prevGetKeyRegistryDir, prevGetSWKeyStorePath
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func listTestKeys(t *testing.T, stub shim.ChaincodeStubInterface) []*cfgsnapapi.KeyInfo {
+	payload, err := listKeys(stub, &cfgsnapapi.Request{}, nil)
+	require.NoError(t, err)
+	var keys []*cfgsnapapi.KeyInfo
+	require.NoError(t, json.Unmarshal(payload, &keys))
+	return keys
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright SecureKey Technologies Inc.
+   This file contains software code that is the intellectual property of SecureKey.
+   SecureKey reserves all rights in the code and you may not use it without
+	 written permission from SecureKey.
+*/
+
+package listener
+
+import (
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	ledgerutil "github.com/hyperledger/fabric/core/ledger/util"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/utils"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+// getChaincodeEvents returns the events with the given name that were emitted by the given chaincode
+// in the valid transactions of the block
+func getChaincodeEvents(block *common.Block, ccID, eventName string) ([]*fab.CCEvent, error) {
+	if block.Header == nil || block.Data == nil {
+		return nil, errors.New(errors.SystemError, "Block has no header or data")
+	}
+
+	var txFilter ledgerutil.TxValidationFlags
+	if block.Metadata != nil && len(block.Metadata.Metadata) > int(common.BlockMetadataIndex_TRANSACTIONS_FILTER) {
+		txFilter = ledgerutil.TxValidationFlags(block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+	}
+
+	var events []*fab.CCEvent
+	for i, envBytes := range block.Data.Data {
+		if i < len(txFilter) && !txFilter.IsValid(i) {
+			continue
+		}
+		event, err := getChaincodeEvent(envBytes)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil || event.ChaincodeId != ccID || event.EventName != eventName {
+			continue
+		}
+		events = append(events, &fab.CCEvent{
+			TxID:        event.TxId,
+			ChaincodeID: event.ChaincodeId,
+			EventName:   event.EventName,
+			Payload:     event.Payload,
+			BlockNumber: block.Header.Number,
+		})
+	}
+	return events, nil
+}
+
+// getChaincodeEvent returns the chaincode event of the given transaction envelope or nil if
+// the transaction isn't an endorser transaction or has no event
+func getChaincodeEvent(envBytes []byte) (*pb.ChaincodeEvent, error) {
+	env, err := utils.GetEnvelopeFromBlock(envBytes)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling envelope")
+	}
+	payload, err := utils.GetPayload(env)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling payload")
+	}
+	if payload.Header == nil {
+		return nil, nil
+	}
+	chdr, err := utils.UnmarshalChannelHeader(payload.Header.ChannelHeader)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling channel header")
+	}
+	if common.HeaderType(chdr.Type) != common.HeaderType_ENDORSER_TRANSACTION {
+		return nil, nil
+	}
+	tx, err := utils.GetTransaction(payload.Data)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling transaction")
+	}
+	if len(tx.Actions) == 0 {
+		return nil, nil
+	}
+	ccActionPayload, err := utils.GetChaincodeActionPayload(tx.Actions[0].Payload)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling chaincode action payload")
+	}
+	if ccActionPayload.Action == nil {
+		return nil, nil
+	}
+	prp, err := utils.GetProposalResponsePayload(ccActionPayload.Action.ProposalResponsePayload)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling proposal response payload")
+	}
+	ccAction, err := utils.GetChaincodeAction(prp.Extension)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling chaincode action")
+	}
+	if len(ccAction.Events) == 0 {
+		return nil, nil
+	}
+	event, err := utils.GetChaincodeEvents(ccAction.Events)
+	if err != nil {
+		return nil, errors.Wrap(errors.UnmarshalError, err, "Error unmarshalling chaincode event")
+	}
+	return event, nil
+}
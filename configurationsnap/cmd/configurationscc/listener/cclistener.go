@@ -8,19 +8,35 @@
 package listener
 
 import (
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/logging"
 	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/protos/common"
 	"github.com/securekey/fabric-snaps/transactionsnap/pkg/txsnapservice"
 	"github.com/securekey/fabric-snaps/util/errors"
 )
 
 var logger = logging.NewLogger("configsnap")
 
+const (
+	// minBackoff is the time to back off after the first failure to register for events
+	minBackoff = time.Second
+	// maxBackoff is the maximum time to back off between attempts to register for events
+	maxBackoff = time.Minute
+)
+
 // ChaincodeListener listen for events emitted by fabric
 type ChaincodeListener interface {
-	// Listen for events
+	// Listen for events. The returned channel remains open when the listener re-registers with the
+	// event service (e.g. after the event service reconnects) and is closed when the listener is stopped.
 	Listen() (<-chan *fab.CCEvent, error)
 	Stop()
+	// IsHealthy returns true if the listener is currently registered for events
+	IsHealthy() bool
 }
 
 // EventSource defines the source of an event
@@ -33,9 +49,33 @@ type EventSource struct {
 	EventName string
 }
 
+// blockSource provides the blocks of the channel from which missed events are replayed
+type blockSource interface {
+	GetBlockchainInfo() (*common.BlockchainInfo, error)
+	GetBlockByNumber(blockNumber uint64) (*common.Block, error)
+}
+
 type listenerImpl struct {
-	source *EventSource
-	reg    fab.Registration
+	source          *EventSource
+	getEventService func(channelID string) (fab.EventService, error)
+	getBlockSource  func(channelID string) (blockSource, error)
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+
+	mtx          sync.Mutex
+	events       chan *fab.CCEvent
+	eventService fab.EventService
+	reg          fab.Registration
+	healthy      int32
+	stop         chan struct{}
+	stopOnce     sync.Once
+
+	// lastBlock is the block of the last event that was delivered (or the ledger height when the listener
+	// last registered or replayed missed events) and lastBlockTxIDs contains the transactions of that block
+	// whose events were delivered
+	lastBlock      uint64
+	haveLastBlock  bool
+	lastBlockTxIDs map[string]bool
 }
 
 // NewChaincodeListener Create a new chaincode listener on the specified source
@@ -46,18 +86,104 @@ func NewChaincodeListener(source *EventSource) (ChaincodeListener, error) {
 	}
 
 	return &listenerImpl{
-		source: source,
+		source:          source,
+		getEventService: getEventService,
+		getBlockSource:  getBlockSource,
+		minBackoff:      minBackoff,
+		maxBackoff:      maxBackoff,
+		stop:            make(chan struct{}),
 	}, nil
 }
 
+// Listen starts listening for events. Registration with the event service is retried with backoff
+// until it succeeds, and is repeated whenever the event channel of the registration is closed.
 func (l *listenerImpl) Listen() (<-chan *fab.CCEvent, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	select {
+	case <-l.stop:
+		return nil, errors.New(errors.SystemError, "Listener has been stopped")
+	default:
+	}
+
+	if l.events == nil {
+		logger.Infof("Starting chaincode listener on channel [%s] for CC [%s] and Event [%s]", l.source.ChannelID, l.source.ChaincodeID, l.source.EventName)
+		l.events = make(chan *fab.CCEvent)
+		go l.run()
+	}
+	return l.events, nil
+}
+
+// Stop unregisters from the event service and closes the event channel
+func (l *listenerImpl) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+
+	l.mtx.Lock()
+	started := l.events != nil
+	l.mtx.Unlock()
+	if !started {
+		l.unregister()
+	}
+}
+
+// IsHealthy returns true if the listener is currently registered for events
+func (l *listenerImpl) IsHealthy() bool {
+	return atomic.LoadInt32(&l.healthy) == 1
+}
+
+func (l *listenerImpl) setHealthy(healthy bool) {
+	var value int32
+	if healthy {
+		value = 1
+	}
+	atomic.StoreInt32(&l.healthy, value)
+}
+
+func (l *listenerImpl) run() {
+	defer close(l.events)
+	defer l.unregister()
+
+	backoff := l.minBackoff
+	for {
+		eventch, err := l.register()
+		if err != nil {
+			l.setHealthy(false)
+			logger.Warnf("Error registering for events on channel [%s]. Retrying in %s: %s", l.source.ChannelID, backoff, err)
+			if !l.sleep(backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > l.maxBackoff {
+				backoff = l.maxBackoff
+			}
+			continue
+		}
+		l.setHealthy(true)
+		backoff = l.minBackoff
+
+		if !l.replayMissedEvents() {
+			return
+		}
+		if !l.forward(eventch) {
+			return
+		}
+
+		l.setHealthy(false)
+		logger.Warnf("Event channel on channel [%s] was closed. Re-registering for events.", l.source.ChannelID)
+		l.unregister()
+	}
+}
+
+// register registers for chaincode events with the event service
+func (l *listenerImpl) register() (<-chan *fab.CCEvent, error) {
 	channelID := l.source.ChannelID
 	ccID := l.source.ChaincodeID
 	eventFilter := l.source.EventName
 
-	logger.Infof("Starting chaincode listener on channel [%s] for CC [%s] and Event [%s]", channelID, ccID, eventFilter)
-
-	eventService, err := getEventService(channelID)
+	eventService, err := l.getEventService(channelID)
 	if err != nil {
 		return nil, errors.WithMessage(errors.SystemError, err, "GetEventService failed")
 	}
@@ -69,23 +195,148 @@ func (l *listenerImpl) Listen() (<-chan *fab.CCEvent, error) {
 		return nil, errors.Wrapf(errors.SystemError, err, "Error registering for CC events on channel %s, CC %s, and event filter %s", channelID, ccID, eventFilter)
 	}
 
+	l.mtx.Lock()
+	l.eventService = eventService
 	l.reg = reg
+	l.mtx.Unlock()
 
+	if !l.haveLastBlock {
+		//events that were emitted before the listener first registered are not replayed
+		height, err := l.getHeight()
+		if err != nil {
+			logger.Warnf("Cannot get block height of channel [%s]. Events that are missed before the first event is received cannot be replayed: %s", channelID, err)
+		} else {
+			l.setLastBlock(height)
+		}
+	}
 	return eventch, nil
 }
 
-func (l *listenerImpl) Stop() {
-	if l.reg == nil {
-		return
+func (l *listenerImpl) unregister() {
+	l.mtx.Lock()
+	eventService := l.eventService
+	reg := l.reg
+	l.eventService = nil
+	l.reg = nil
+	l.mtx.Unlock()
+
+	if reg != nil {
+		eventService.Unregister(reg)
 	}
+}
+
+// forward delivers the events of the registration until the event channel is closed (true is returned)
+// or the listener is stopped (false is returned)
+func (l *listenerImpl) forward(eventch <-chan *fab.CCEvent) bool {
+	for {
+		select {
+		case <-l.stop:
+			return false
+		case event, ok := <-eventch:
+			if !ok {
+				return true
+			}
+			if !l.deliver(event) {
+				return false
+			}
+		}
+	}
+}
+
+// deliver delivers the event unless it was already delivered. False is returned if the listener is stopped.
+func (l *listenerImpl) deliver(event *fab.CCEvent) bool {
+	if event == nil {
+		return true
+	}
+	if l.haveLastBlock && (event.BlockNumber < l.lastBlock || (event.BlockNumber == l.lastBlock && l.lastBlockTxIDs[event.TxID])) {
+		logger.Debugf("Skipping event of TxID [%s] in block %d on channel [%s] that was already delivered", event.TxID, event.BlockNumber, l.source.ChannelID)
+		return true
+	}
+
+	select {
+	case <-l.stop:
+		return false
+	case l.events <- event:
+	}
+
+	if !l.haveLastBlock || event.BlockNumber != l.lastBlock {
+		l.setLastBlock(event.BlockNumber)
+	}
+	l.lastBlockTxIDs[event.TxID] = true
+	return true
+}
+
+func (l *listenerImpl) setLastBlock(blockNumber uint64) {
+	l.lastBlock = blockNumber
+	l.haveLastBlock = true
+	l.lastBlockTxIDs = make(map[string]bool)
+}
+
+// replayMissedEvents delivers the events in the blocks since the last delivered event, which may have been
+// missed while the listener wasn't registered. The last block is then advanced to the ledger height so that
+// the blocks aren't scanned again by the next replay. False is returned if the listener is stopped.
+func (l *listenerImpl) replayMissedEvents() bool {
+	if !l.haveLastBlock {
+		return true
+	}
+	channelID := l.source.ChannelID
 
-	eventService, err := getEventService(l.source.ChannelID)
+	blocks, err := l.getBlockSource(channelID)
 	if err != nil {
-		logger.Warnf("GetEventService failed %s", err)
-		return
+		logger.Warnf("Cannot replay missed events on channel [%s]: %s", channelID, err)
+		return true
 	}
+	info, err := blocks.GetBlockchainInfo()
+	if err != nil {
+		logger.Warnf("Cannot replay missed events on channel [%s]: %s", channelID, err)
+		return true
+	}
+
+	for blockNumber := l.lastBlock; blockNumber < info.Height; blockNumber++ {
+		block, err := blocks.GetBlockByNumber(blockNumber)
+		if err != nil {
+			logger.Warnf("Cannot replay missed events in block %d on channel [%s]: %s", blockNumber, channelID, err)
+			return true
+		}
+		events, err := getChaincodeEvents(block, l.source.ChaincodeID, l.source.EventName)
+		if err != nil {
+			logger.Warnf("Cannot replay missed events in block %d on channel [%s]: %s", blockNumber, channelID, err)
+			return true
+		}
+		for _, event := range events {
+			logger.Debugf("Replaying event of TxID [%s] in block %d on channel [%s]", event.TxID, event.BlockNumber, channelID)
+			if !l.deliver(event) {
+				return false
+			}
+		}
+	}
+	//the events of every block below the height were delivered
+	if info.Height > l.lastBlock {
+		l.setLastBlock(info.Height)
+	}
+	return true
+}
+
+func (l *listenerImpl) getHeight() (uint64, error) {
+	blocks, err := l.getBlockSource(l.source.ChannelID)
+	if err != nil {
+		return 0, err
+	}
+	info, err := blocks.GetBlockchainInfo()
+	if err != nil {
+		return 0, err
+	}
+	return info.Height, nil
+}
 
-	eventService.Unregister(l.reg)
+// sleep sleeps for the given time. False is returned if the listener is stopped.
+func (l *listenerImpl) sleep(d time.Duration) bool {
+	select {
+	case <-l.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
 
 func getEventService(channelID string) (fab.EventService, error) {
@@ -97,3 +348,11 @@ func getEventService(channelID string) (fab.EventService, error) {
 	}
 	return txService.FcClient.EventService()
 }
+
+func getBlockSource(channelID string) (blockSource, error) {
+	lgr := peer.GetLedger(channelID)
+	if lgr == nil {
+		return nil, errors.Errorf(errors.SystemError, "Cannot obtain ledger for channel %s", channelID)
+	}
+	return lgr, nil
+}
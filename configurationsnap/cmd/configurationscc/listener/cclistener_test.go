@@ -0,0 +1,237 @@
+/*
+   Copyright SecureKey Technologies Inc.
+   This file contains software code that is the intellectual property of SecureKey.
+   SecureKey reserves all rights in the code and you may not use it without
+	 written permission from SecureKey.
+*/
+
+package listener
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-sdk-go/pkg/common/providers/fab"
+	"github.com/hyperledger/fabric/protos/common"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testChannelID = "testChannel"
+	testCCID      = "configurationsnap"
+	testEventName = "cfgsnap-event"
+)
+
+func TestNewChaincodeListener(t *testing.T) {
+	_, err := NewChaincodeListener(nil)
+	assert.Error(t, err)
+	_, err = NewChaincodeListener(&EventSource{ChannelID: testChannelID, ChaincodeID: testCCID})
+	assert.Error(t, err)
+}
+
+func TestListenerReRegistersAndReplaysMissedEvents(t *testing.T) {
+	eventService := &mockEventService{failures: 1}
+	blocks := &mockBlockSource{blocks: make(map[uint64]*common.Block), height: 5}
+
+	l, err := NewChaincodeListener(&EventSource{ChannelID: testChannelID, ChaincodeID: testCCID, EventName: testEventName})
+	require.NoError(t, err)
+	impl := l.(*listenerImpl)
+	impl.minBackoff = time.Millisecond
+	impl.getEventService = func(channelID string) (fab.EventService, error) { return eventService, nil }
+	impl.getBlockSource = func(channelID string) (blockSource, error) { return blocks, nil }
+
+	assert.False(t, l.IsHealthy())
+	events, err := l.Listen()
+	require.NoError(t, err)
+
+	//the first registration fails and is retried
+	ch1 := eventService.nextRegistration(t)
+	ch1 <- &fab.CCEvent{TxID: "tx5", ChaincodeID: testCCID, EventName: testEventName, BlockNumber: 5}
+	assertEvent(t, events, "tx5")
+	assert.True(t, l.IsHealthy())
+
+	//the event of tx6 is missed while the event channel is closed
+	blocks.add(newBlock(t, 5, "tx5"))
+	blocks.add(newBlock(t, 6, "tx6"))
+	close(ch1)
+
+	ch2 := eventService.nextRegistration(t)
+	assertEvent(t, events, "tx6")
+
+	//events that were replayed are not delivered again
+	ch2 <- &fab.CCEvent{TxID: "tx6", ChaincodeID: testCCID, EventName: testEventName, BlockNumber: 6}
+	ch2 <- &fab.CCEvent{TxID: "tx7", ChaincodeID: testCCID, EventName: testEventName, BlockNumber: 7}
+	assertEvent(t, events, "tx7")
+	assert.True(t, l.IsHealthy())
+
+	//blocks that were scanned by a replay aren't scanned again
+	blocks.add(newBlock(t, 7, "tx7"))
+	reads := blocks.numReads()
+	close(ch2)
+	close(eventService.nextRegistration(t))
+	ch4 := eventService.nextRegistration(t)
+	ch4 <- &fab.CCEvent{TxID: "tx8", ChaincodeID: testCCID, EventName: testEventName, BlockNumber: 8}
+	assertEvent(t, events, "tx8")
+	assert.Equal(t, reads+1, blocks.numReads())
+
+	l.Stop()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for event channel to close")
+	}
+	assert.Equal(t, 4, eventService.unregistered())
+
+	_, err = l.Listen()
+	assert.Error(t, err)
+}
+
+func TestGetChaincodeEvents(t *testing.T) {
+	block := newBlock(t, 3, "tx3")
+	events, err := getChaincodeEvents(block, testCCID, testEventName)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, "tx3", events[0].TxID)
+	assert.Equal(t, uint64(3), events[0].BlockNumber)
+
+	events, err = getChaincodeEvents(block, "othercc", testEventName)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(events))
+
+	//events of invalid transactions are ignored
+	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] = []byte{byte(pb.TxValidationCode_MVCC_READ_CONFLICT)}
+	events, err = getChaincodeEvents(block, testCCID, testEventName)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(events))
+}
+
+func assertEvent(t *testing.T, events <-chan *fab.CCEvent, txID string) {
+	select {
+	case event := <-events:
+		require.NotNil(t, event)
+		assert.Equal(t, txID, event.TxID)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for event of %s", txID)
+	}
+}
+
+func newBlock(t *testing.T, number uint64, txID string) *common.Block {
+	marshal := func(msg proto.Message) []byte {
+		bytes, err := proto.Marshal(msg)
+		require.NoError(t, err)
+		return bytes
+	}
+	event := marshal(&pb.ChaincodeEvent{ChaincodeId: testCCID, TxId: txID, EventName: testEventName, Payload: []byte("payload")})
+	prp := marshal(&pb.ProposalResponsePayload{Extension: marshal(&pb.ChaincodeAction{Events: event})})
+	ccActionPayload := marshal(&pb.ChaincodeActionPayload{Action: &pb.ChaincodeEndorsedAction{ProposalResponsePayload: prp}})
+	tx := marshal(&pb.Transaction{Actions: []*pb.TransactionAction{{Payload: ccActionPayload}}})
+	chdr := marshal(&common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), ChannelId: testChannelID, TxId: txID})
+	env := marshal(&common.Envelope{Payload: marshal(&common.Payload{Header: &common.Header{ChannelHeader: chdr}, Data: tx})})
+
+	return &common.Block{
+		Header:   &common.BlockHeader{Number: number},
+		Data:     &common.BlockData{Data: [][]byte{env}},
+		Metadata: &common.BlockMetadata{Metadata: [][]byte{{}, {}, {byte(pb.TxValidationCode_VALID)}, {}}},
+	}
+}
+
+type mockRegistration struct {
+	events chan *fab.CCEvent
+}
+
+type mockEventService struct {
+	fab.EventService
+	mtx           sync.Mutex
+	failures      int
+	registrations chan chan *fab.CCEvent
+	numUnregister int
+}
+
+func (s *mockEventService) RegisterChaincodeEvent(ccID, eventFilter string) (fab.Registration, <-chan *fab.CCEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return nil, nil, fmt.Errorf("registration failed")
+	}
+	reg := &mockRegistration{events: make(chan *fab.CCEvent)}
+	s.registrationsChan() <- reg.events
+	return reg, reg.events, nil
+}
+
+func (s *mockEventService) Unregister(reg fab.Registration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.numUnregister++
+}
+
+func (s *mockEventService) registrationsChan() chan chan *fab.CCEvent {
+	if s.registrations == nil {
+		s.registrations = make(chan chan *fab.CCEvent, 10)
+	}
+	return s.registrations
+}
+
+func (s *mockEventService) nextRegistration(t *testing.T) chan *fab.CCEvent {
+	s.mtx.Lock()
+	registrations := s.registrationsChan()
+	s.mtx.Unlock()
+	select {
+	case events := <-registrations:
+		return events
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for registration")
+		return nil
+	}
+}
+
+func (s *mockEventService) unregistered() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.numUnregister
+}
+
+type mockBlockSource struct {
+	mtx    sync.Mutex
+	blocks map[uint64]*common.Block
+	height uint64
+	reads  int
+}
+
+func (b *mockBlockSource) add(block *common.Block) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.blocks[block.Header.Number] = block
+	if block.Header.Number >= b.height {
+		b.height = block.Header.Number + 1
+	}
+}
+
+func (b *mockBlockSource) numReads() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.reads
+}
+
+func (b *mockBlockSource) GetBlockchainInfo() (*common.BlockchainInfo, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return &common.BlockchainInfo{Height: b.height}, nil
+}
+
+func (b *mockBlockSource) GetBlockByNumber(blockNumber uint64) (*common.Block, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.reads++
+	block, ok := b.blocks[blockNumber]
+	if !ok {
+		return nil, fmt.Errorf("block %d not found", blockNumber)
+	}
+	return block, nil
+}
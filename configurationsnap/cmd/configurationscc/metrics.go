@@ -23,18 +23,34 @@ var (
 		Name:      "periodic_refresh_duration",
 		Help:      "The config periodic refresh duration.",
 	}
+	configRefreshSuccesses = kitmetrics.CounterOpts{
+		Namespace: "snap",
+		Subsystem: "config",
+		Name:      "periodic_refresh_successes",
+		Help:      "The number of successful periodic config refreshes.",
+	}
+	configRefreshFailures = kitmetrics.CounterOpts{
+		Namespace: "snap",
+		Subsystem: "config",
+		Name:      "periodic_refresh_failures",
+		Help:      "The number of failed periodic config refreshes.",
+	}
 )
 
 //Metrics contain graphs
 type Metrics struct {
-	ConfigRefresh         kitmetrics.Histogram
-	ConfigPeriodicRefresh kitmetrics.Histogram
+	ConfigRefresh          kitmetrics.Histogram
+	ConfigPeriodicRefresh  kitmetrics.Histogram
+	ConfigRefreshSuccesses kitmetrics.Counter
+	ConfigRefreshFailures  kitmetrics.Counter
 }
 
 //NewMetrics create new instance of metrics
 func NewMetrics(p kitmetrics.Provider) *Metrics {
 	return &Metrics{
-		ConfigRefresh:         p.NewHistogram(configRefresh),
-		ConfigPeriodicRefresh: p.NewHistogram(configPeriodicRefresh),
+		ConfigRefresh:          p.NewHistogram(configRefresh),
+		ConfigPeriodicRefresh:  p.NewHistogram(configPeriodicRefresh),
+		ConfigRefreshSuccesses: p.NewCounter(configRefreshSuccesses),
+		ConfigRefreshFailures:  p.NewCounter(configRefreshFailures),
 	}
 }
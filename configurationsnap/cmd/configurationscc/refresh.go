@@ -0,0 +1,178 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	configmgmtService "github.com/securekey/fabric-snaps/configmanager/pkg/service"
+	"github.com/securekey/fabric-snaps/configurationsnap/cmd/configurationscc/config"
+)
+
+const (
+	// refreshJitter is the maximum fraction of the refresh interval that is randomly added to or
+	// subtracted from each interval so that the peers don't all refresh at the same time
+	refreshJitter = 0.2
+
+	// maxRefreshBackoff is the maximum interval between refreshes after repeated failures
+	maxRefreshBackoff = 5 * time.Minute
+)
+
+var schedulersMtx sync.Mutex
+var refreshSchedulers = make(map[string]*refreshScheduler)
+
+// refreshScheduler periodically refreshes the config cache of a channel. The interval is
+// jittered and backs off exponentially on repeated failures.
+type refreshScheduler struct {
+	channelID string
+	// refresh sends the refresh request
+	refresh func() error
+	// getInterval returns the configured refresh interval
+	getInterval func() time.Duration
+	// configChanges receives a value whenever the config of the configuration snap changes
+	configChanges <-chan mgmtapi.ConfigChange
+	unsubscribe   func()
+	metrics       *Metrics
+	random        *rand.Rand
+	stop          chan struct{}
+	stopOnce      sync.Once
+	done          chan struct{}
+}
+
+func newRefreshScheduler(channelID string, refresh func() error, getInterval func() time.Duration, configChanges <-chan mgmtapi.ConfigChange, unsubscribe func(), metrics *Metrics) *refreshScheduler {
+	return &refreshScheduler{
+		channelID:     channelID,
+		refresh:       refresh,
+		getInterval:   getInterval,
+		configChanges: configChanges,
+		unsubscribe:   unsubscribe,
+		metrics:       metrics,
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// startRefreshScheduler starts refreshing the config cache of the channel periodically.
+// Any scheduler that was previously started for the channel is stopped.
+func startRefreshScheduler(channelID string, metrics *Metrics) {
+	var configChanges <-chan mgmtapi.ConfigChange
+	unsubscribe := func() {}
+	peerMspID, err := config.GetPeerMSPID(peerConfigPath)
+	peerID, err1 := config.GetPeerID(peerConfigPath)
+	if err == nil && err1 == nil {
+		filter := mgmtapi.ConfigKey{MspID: peerMspID, PeerID: peerID, AppName: configSnapName}
+		configChanges, unsubscribe = configmgmtService.GetInstance().Subscribe(channelID, filter)
+	} else {
+		logger.Warnf("Cannot subscribe to config changes on channel [%s]. Changes to the refresh interval take effect after the next refresh.", channelID)
+	}
+
+	scheduler := newRefreshScheduler(
+		channelID,
+		func() error { return sendRefreshRequest(channelID, metrics, nil) },
+		func() time.Duration { return loadRefreshConfig(channelID) },
+		configChanges, unsubscribe, metrics,
+	)
+
+	schedulersMtx.Lock()
+	previous := refreshSchedulers[channelID]
+	refreshSchedulers[channelID] = scheduler
+	schedulersMtx.Unlock()
+
+	if previous != nil {
+		previous.Stop()
+	}
+	go scheduler.run()
+}
+
+// loadRefreshConfig reads the refresh interval and applies the cache limits from the config of the channel
+func loadRefreshConfig(channelID string) time.Duration {
+	csccconfig, err := config.New(channelID, peerConfigPath)
+	if err != nil {
+		logger.Warnf("Error getting config for channel [%s], using default refresh interval: %s", channelID, err)
+		return config.GetDefaultRefreshInterval()
+	}
+	x := configmgmtService.GetInstance()
+	x.(*configmgmtService.ConfigServiceImpl).SetCacheLimits(channelID, csccconfig.CacheMaxBytes, csccconfig.CacheTTL)
+
+	if csccconfig.RefreshInterval < config.GetMinimumRefreshInterval() {
+		return config.GetMinimumRefreshInterval()
+	}
+	return csccconfig.RefreshInterval
+}
+
+// Stop stops the scheduler and waits for it to exit
+func (s *refreshScheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.unsubscribe()
+	})
+	<-s.done
+}
+
+func (s *refreshScheduler) run() {
+	defer close(s.done)
+
+	interval := s.getInterval()
+	failures := 0
+	timer := time.NewTimer(s.nextDelay(interval, failures))
+	defer timer.Stop()
+
+	logger.Debugf("Started refresh scheduler on channel [%s] with interval %s", s.channelID, interval)
+	for {
+		select {
+		case <-s.stop:
+			logger.Debugf("Stopped refresh scheduler on channel [%s]", s.channelID)
+			return
+
+		case _, ok := <-s.configChanges:
+			if !ok {
+				s.configChanges = nil
+				continue
+			}
+			newInterval := s.getInterval()
+			if newInterval == interval {
+				continue
+			}
+			logger.Infof("Refresh interval on channel [%s] changed from %s to %s", s.channelID, interval, newInterval)
+			interval = newInterval
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(s.nextDelay(interval, failures))
+
+		case <-timer.C:
+			if err := s.refresh(); err != nil {
+				failures++
+				s.metrics.ConfigRefreshFailures.Add(1)
+				logger.Warnf("Refresh of config cache on channel [%s] failed %d time(s): %s", s.channelID, failures, err)
+			} else {
+				failures = 0
+				s.metrics.ConfigRefreshSuccesses.Add(1)
+			}
+			interval = s.getInterval()
+			timer.Reset(s.nextDelay(interval, failures))
+		}
+	}
+}
+
+// nextDelay returns the time until the next refresh. The interval is doubled for each consecutive
+// failure (up to maxRefreshBackoff) and a random jitter is applied.
+func (s *refreshScheduler) nextDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < maxRefreshBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRefreshBackoff && interval < maxRefreshBackoff {
+		delay = maxRefreshBackoff
+	}
+	jitter := time.Duration(float64(delay) * refreshJitter * (2*s.random.Float64() - 1))
+	return delay + jitter
+}
@@ -0,0 +1,78 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	metricsutil "github.com/securekey/fabric-snaps/metrics/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSchedulerNextDelay(t *testing.T) {
+	s := newRefreshScheduler("testChannel", nil, nil, nil, func() {}, NewMetrics(metricsutil.GetMetricsInstance()))
+
+	interval := 10 * time.Second
+	for i := 0; i < 100; i++ {
+		delay := s.nextDelay(interval, 0)
+		assert.True(t, delay >= 8*time.Second && delay <= 12*time.Second, "unexpected delay %s", delay)
+
+		delay = s.nextDelay(interval, 2)
+		assert.True(t, delay >= 32*time.Second && delay <= 48*time.Second, "unexpected delay %s", delay)
+
+		//the backoff is capped
+		delay = s.nextDelay(interval, 20)
+		assert.True(t, delay >= 4*time.Minute && delay <= 6*time.Minute, "unexpected delay %s", delay)
+	}
+}
+
+func TestRefreshScheduler(t *testing.T) {
+	var refreshes int32
+	refresh := func() error {
+		if atomic.AddInt32(&refreshes, 1) == 1 {
+			return fmt.Errorf("refresh failed")
+		}
+		return nil
+	}
+	interval := int64(time.Hour)
+	getInterval := func() time.Duration {
+		return time.Duration(atomic.LoadInt64(&interval))
+	}
+	configChanges := make(chan mgmtapi.ConfigChange, 1)
+	unsubscribed := false
+	unsubscribe := func() {
+		unsubscribed = true
+		close(configChanges)
+	}
+
+	s := newRefreshScheduler("testChannel", refresh, getInterval, configChanges, unsubscribe, NewMetrics(metricsutil.GetMetricsInstance()))
+	go s.run()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&refreshes))
+
+	//a change to the interval takes effect immediately
+	atomic.StoreInt64(&interval, int64(10*time.Millisecond))
+	configChanges <- mgmtapi.ConfigChange{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&refreshes) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, atomic.LoadInt32(&refreshes) >= 3)
+
+	s.Stop()
+	s.Stop()
+	assert.True(t, unsubscribed)
+	count := atomic.LoadInt32(&refreshes)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, atomic.LoadInt32(&refreshes))
+}
@@ -0,0 +1,285 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util"
+	"github.com/securekey/fabric-snaps/util/errors"
+)
+
+// snapFunction is a function of the configuration snap
+type snapFunction struct {
+	// invoke performs the function and returns its payload
+	invoke func(shim.ChaincodeStubInterface, *cfgsnapapi.Request, *Metrics) ([]byte, errors.Error)
+	// parseArgs parses the deprecated positional args of the function into a request
+	parseArgs func(args [][]byte) (*cfgsnapapi.Request, errors.Error)
+}
+
+// parseRequest parses the args of a function into a request. The args are a versioned request if they consist of a
+// single JSON object with a version field, otherwise they are the (deprecated) positional args of the function.
+// True is returned if the args are a versioned request.
+func parseRequest(function snapFunction, args [][]byte) (*cfgsnapapi.Request, bool, errors.Error) {
+	if !isVersionedRequest(args) {
+		req, err := function.parseArgs(args)
+		return req, false, err
+	}
+
+	req := &cfgsnapapi.Request{}
+	if err := json.Unmarshal(args[0], req); err != nil {
+		return nil, true, errors.WithMessage(errors.UnmarshalError, err, "Failed to unmarshal request")
+	}
+	if req.Version != cfgsnapapi.RequestVersion {
+		return nil, true, errors.Errorf(errors.ValidationError, "Unsupported request version [%s]. Expecting version %s", req.Version, cfgsnapapi.RequestVersion)
+	}
+	return req, true, nil
+}
+
+// isVersionedRequest returns true if the args consist of a single JSON object with a version field
+func isVersionedRequest(args [][]byte) bool {
+	if len(args) != 1 {
+		return false
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(args[0], &fields); err != nil {
+		return false
+	}
+	_, ok := fields["version"]
+	return ok
+}
+
+// newResponse returns the chaincode response of a function. If the function was invoked with a versioned request then
+// the payload of the response is a response envelope, otherwise it is the payload of the function.
+func newResponse(stub shim.ChaincodeStubInterface, versioned bool, payload []byte, codedErr errors.Error) pb.Response {
+	if !versioned {
+		if codedErr != nil {
+			errResponse := util.CreateShimResponseFromError(codedErr, logger, stub)
+			errResponse.Payload = payload
+			return errResponse
+		}
+		return shim.Success(payload)
+	}
+
+	response := &cfgsnapapi.Response{Version: cfgsnapapi.RequestVersion, Payload: payload}
+	if codedErr != nil {
+		//the error ID in the response identifies the error in the log of the snap
+		logger.Error(codedErr.GenerateLogMsg())
+		response.ErrorCode = string(codedErr.ErrorCode())
+		response.ErrorID = codedErr.ErrorID()
+		response.Message = codedErr.Error()
+	}
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return util.CreateShimResponseFromError(errors.WithMessage(errors.SystemError, err, "Failed to marshal response"), logger, stub)
+	}
+	if codedErr != nil {
+		return pb.Response{Status: shim.ERROR, Message: codedErr.GenerateClientErrorMsg(), Payload: responseBytes}
+	}
+	return shim.Success(responseBytes)
+}
+
+// requireKey returns an error if the request has no config key
+func requireKey(req *cfgsnapapi.Request) errors.Error {
+	if req.Key == nil {
+		return errors.New(errors.MissingRequiredParameterError, "Config key is required")
+	}
+	return nil
+}
+
+// parseNoArgs ignores the args of a function that has none
+func parseNoArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	return &cfgsnapapi.Request{}, nil
+}
+
+// parseConfigArgs parses the args of save
+// first arg: config message
+func parseConfigArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	req := &cfgsnapapi.Request{}
+	if len(args) > 0 {
+		req.Config = args[0]
+	}
+	return req, nil
+}
+
+// parseKeyArgs parses the args of getFromCache, getHistory and delete
+// first arg: config key
+func parseKeyArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	configKey, err := getKey(args)
+	if err != nil {
+		return nil, err
+	}
+	return &cfgsnapapi.Request{Key: configKey}, nil
+}
+
+// parseReindexArgs parses the args of reindex
+// first arg (optional): config key
+func parseReindexArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) == 0 || len(args[0]) == 0 {
+		return &cfgsnapapi.Request{}, nil
+	}
+	return parseKeyArgs(args)
+}
+
+// parseGetArgs parses the args of get
+// first arg: config key
+// second arg (optional): page options
+func parseGetArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	req, err := parseKeyArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 1 && len(args[1]) > 0 {
+		req.PageOptions = &mgmtapi.PageOptions{}
+		if err := json.Unmarshal(args[1], req.PageOptions); err != nil {
+			return nil, errors.WithMessage(errors.UnmarshalError, err, fmt.Sprintf("Got an error unmarshalling page options %s", string(args[1])))
+		}
+	}
+	return req, nil
+}
+
+// parseKeyAndTxIDArgs parses the args of getAsOf and rollback
+// first arg: config key
+// second arg: TxID
+func parseKeyAndTxIDArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	req, err := parseKeyArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 1 {
+		req.TxID = string(args[1])
+	}
+	return req, nil
+}
+
+// parseRefreshArgs parses the args of refresh
+// first arg: JSON array of MSP IDs
+// second arg (optional): payload of the config event
+func parseRefreshArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 1 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "expecting first arg to be a JSON array of MSP IDs")
+	}
+
+	req := &cfgsnapapi.Request{}
+	if err := json.Unmarshal(args[0], &req.MspIDs); err != nil {
+		return nil, errors.WithMessage(errors.UnmarshalError, err, "Failed to unmarshal msp IDs")
+	}
+	if len(args) > 1 && len(args[1]) > 0 {
+		event := &mgmtapi.ConfigEvent{}
+		if err := json.Unmarshal(args[1], event); err != nil {
+			logger.Warnf("Failed to unmarshal config event, refreshing all configs: %s", err)
+		} else {
+			req.Event = event
+		}
+	}
+	return req, nil
+}
+
+// parseCacheStatusArgs parses the args of cacheStatus
+// first arg (optional): mode
+func parseCacheStatusArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	req := &cfgsnapapi.Request{}
+	if len(args) > 0 {
+		req.Mode = string(args[0])
+	}
+	return req, nil
+}
+
+// parseKeyPairArgs parses the args of generateKeyPair
+// first arg: key type
+// second arg: ephemeral flag (true/false)
+func parseKeyPairArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 2 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Required arguments are: key type and ephemeral flag")
+	}
+	ephemeral, err := strconv.ParseBool(string(args[1]))
+	if err != nil {
+		return nil, errors.WithMessage(errors.ValidationError, err, "Ephemeral flag is not set")
+	}
+	return &cfgsnapapi.Request{KeyType: string(args[0]), Ephemeral: ephemeral}, nil
+}
+
+// parseCSRArgs parses the args of generateCSR
+// first arg: key type (ECDSA, RSA)
+// second arg : ephemeral flag (true/false)
+// third  arg: signature algorithm (one of x509.SignatureAlgorithm)
+// fourth arg: common name
+func parseCSRArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 4 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Required arguments are: key type, ephemeral flag, CSR's signature algorithm and common name")
+	}
+	req, err := parseKeyPairArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	req.SignatureAlgorithm = string(args[2])
+	req.CommonName = string(args[3])
+	return req, nil
+}
+
+// parseSKIArgs parses the args of getPublicKey and deleteKey
+// first arg: hex encoded SKI
+func parseSKIArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	req := &cfgsnapapi.Request{}
+	if len(args) > 0 {
+		req.SKI = string(args[0])
+	}
+	return req, nil
+}
+
+// parseSignArgs parses the args of sign
+// first arg: hex encoded SKI
+// second arg: digest algorithm
+// third arg: data to sign
+func parseSignArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 3 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Required arguments are: SKI, digest algorithm and data")
+	}
+	return &cfgsnapapi.Request{SKI: string(args[0]), DigestAlgorithm: string(args[1]), Data: args[2]}, nil
+}
+
+// parseVerifyArgs parses the args of verify
+// first arg: hex encoded SKI
+// second arg: signature
+// third arg: signed data
+// fourth arg (optional): digest algorithm
+func parseVerifyArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 3 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Required arguments are: SKI, signature and data")
+	}
+	req := &cfgsnapapi.Request{SKI: string(args[0]), Signature: args[1], Data: args[2]}
+	if len(args) > 3 {
+		req.DigestAlgorithm = string(args[3])
+	}
+	return req, nil
+}
+
+// parseImportCertificateArgs parses the args of importCertificate
+// first arg: name of the client identity
+// second arg: PEM encoded certificate (optionally followed by the intermediate certificates of its chain)
+// third arg: ID of the peer that holds the key of the certificate
+// fourth arg (optional): hex encoded SKI of the key of the certificate
+// fifth arg (optional): MSP ID of the peer that holds the key of the certificate
+func parseImportCertificateArgs(args [][]byte) (*cfgsnapapi.Request, errors.Error) {
+	if len(args) < 3 {
+		return nil, errors.New(errors.MissingRequiredParameterError, "Required arguments are: name, certificate and peer ID")
+	}
+	req := &cfgsnapapi.Request{Name: string(args[0]), Certificate: string(args[1]), PeerID: string(args[2])}
+	if len(args) > 3 {
+		req.SKI = string(args[3])
+	}
+	if len(args) > 4 {
+		req.MspID = string(args[4])
+	}
+	return req, nil
+}
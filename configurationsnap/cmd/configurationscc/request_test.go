@@ -0,0 +1,114 @@
+/*
+Copyright SecureKey Technologies Inc. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	mgmtapi "github.com/securekey/fabric-snaps/configmanager/api"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
+	"github.com/securekey/fabric-snaps/util/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsVersionedRequest(t *testing.T) {
+	assert.True(t, isVersionedRequest([][]byte{[]byte(`{"version":"1"}`)}))
+	assert.False(t, isVersionedRequest(nil))
+	assert.False(t, isVersionedRequest([][]byte{[]byte(`{"version":"1"}`), []byte("1")}))
+	assert.False(t, isVersionedRequest([][]byte{[]byte(`{"MspID":"Org1MSP"}`)}))
+	assert.False(t, isVersionedRequest([][]byte{[]byte(`["Org1MSP"]`)}))
+	assert.False(t, isVersionedRequest([][]byte{[]byte("cacheDiff")}))
+}
+
+func TestParseRequest(t *testing.T) {
+	function := functionRegistry["generateCSR"]
+
+	//deprecated positional args
+	req, versioned, err := parseRequest(function, [][]byte{[]byte("ECDSA"), []byte("true"), []byte("ECDSAWithSHA256"), []byte("cn")})
+	require.NoError(t, err)
+	assert.False(t, versioned)
+	assert.Equal(t, &cfgsnapapi.Request{KeyType: "ECDSA", Ephemeral: true, SignatureAlgorithm: "ECDSAWithSHA256", CommonName: "cn"}, req)
+
+	_, _, err = parseRequest(function, [][]byte{[]byte("ECDSA"), []byte("true")})
+	require.Error(t, err)
+	assert.Equal(t, errors.ErrorCode(errors.MissingRequiredParameterError), err.ErrorCode())
+
+	_, _, err = parseRequest(function, [][]byte{[]byte("ECDSA"), []byte("notbool"), []byte("ECDSAWithSHA256"), []byte("cn")})
+	require.Error(t, err)
+	assert.Equal(t, errors.ValidationError, err.ErrorCode())
+
+	//versioned request
+	req, versioned, err = parseRequest(function, [][]byte{[]byte(`{"version":"1","keyType":"RSA2048","signatureAlgorithm":"SHA256WithRSA","commonName":"cn"}`)})
+	require.NoError(t, err)
+	assert.True(t, versioned)
+	assert.Equal(t, &cfgsnapapi.Request{Version: "1", KeyType: "RSA2048", SignatureAlgorithm: "SHA256WithRSA", CommonName: "cn"}, req)
+
+	_, versioned, err = parseRequest(function, [][]byte{[]byte(`{"version":"2"}`)})
+	require.Error(t, err)
+	assert.True(t, versioned)
+	assert.Equal(t, errors.ValidationError, err.ErrorCode())
+}
+
+func TestVersionedRequest(t *testing.T) {
+	peerConfigPath = "./sampleconfig"
+	stub := getMockStub("testChannel")
+
+	aclProvider = &mockACLProvider{aclFailed: false}
+	configMsg := strings.Replace(validMsgMultiplePeersAndApps, "$v", mgmtapi.VERSION, -1)
+	res := stub.MockInvoke("saveTxn", [][]byte{[]byte("save"), newRequestBytes(t, &cfgsnapapi.Request{Config: json.RawMessage(configMsg)})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	response := unmarshalResponse(t, res.Payload)
+	assert.Equal(t, cfgsnapapi.RequestVersion, response.Version)
+	assert.Empty(t, response.ErrorCode)
+
+	configKey := &mgmtapi.ConfigKey{MspID: "Org1MSP", PeerID: "peer.one.one.example.com", AppName: "appNameB", AppVersion: mgmtapi.VERSION}
+	res = stub.MockInvoke("getTxn", [][]byte{[]byte("get"), newRequestBytes(t, &cfgsnapapi.Request{Key: configKey})})
+	require.Equal(t, int32(shim.OK), res.Status, res.Message)
+	response = unmarshalResponse(t, res.Payload)
+	var configs []*mgmtapi.ConfigKV
+	require.NoError(t, json.Unmarshal(response.Payload, &configs))
+	require.Len(t, configs, 1)
+	assert.Equal(t, "config for appNametwo", string(configs[0].Value))
+
+	//errors are returned in the response envelope
+	res = stub.MockInvoke("getTxn", [][]byte{[]byte("get"), newRequestBytes(t, &cfgsnapapi.Request{})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	response = unmarshalResponse(t, res.Payload)
+	assert.Equal(t, errors.MissingRequiredParameterError, response.ErrorCode)
+	assert.NotEmpty(t, response.ErrorID)
+	assert.Contains(t, res.Message, response.ErrorID)
+
+	aclProvider = &mockACLProvider{aclFailed: true}
+	res = stub.MockInvoke("getTxn", [][]byte{[]byte("get"), newRequestBytes(t, &cfgsnapapi.Request{Key: configKey})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	response = unmarshalResponse(t, res.Payload)
+	assert.NotEmpty(t, response.ErrorCode)
+	assert.NotEmpty(t, response.ErrorID)
+	aclProvider = &mockACLProvider{aclFailed: false}
+
+	res = stub.MockInvoke("getTxn", [][]byte{[]byte("generateKeyPair"), newRequestBytes(t, &cfgsnapapi.Request{KeyType: "FAKE"})})
+	require.Equal(t, int32(shim.ERROR), res.Status)
+	response = unmarshalResponse(t, res.Payload)
+	assert.Equal(t, string(errors.GeneralError), response.ErrorCode)
+}
+
+func newRequestBytes(t *testing.T, req *cfgsnapapi.Request) []byte {
+	req.Version = cfgsnapapi.RequestVersion
+	reqBytes, err := json.Marshal(req)
+	require.NoError(t, err)
+	return reqBytes
+}
+
+func unmarshalResponse(t *testing.T, payload []byte) *cfgsnapapi.Response {
+	response := &cfgsnapapi.Response{}
+	require.NoError(t, json.Unmarshal(payload, response))
+	return response
+}
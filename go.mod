@@ -12,6 +12,7 @@ require (
 	github.com/google/certificate-transparency-go v0.0.0-20180219093839-391726f8973d // indirect
 	github.com/hyperledger/fabric v1.4.0
 	github.com/hyperledger/fabric-sdk-go v0.0.0-20190125204638-b490519efff9
+	github.com/miekg/pkcs11 v0.0.0-20181002074154-c6d6ee821fb1
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/pkg/errors v0.8.1
@@ -31,6 +32,7 @@ require (
 	golang.org/x/net v0.0.0-20181003013248-f5e5bdd77824
 	golang.org/x/tools v0.0.0-20181026183834-f60e5f99f081
 	google.golang.org/grpc v1.17.0
+	gopkg.in/yaml.v2 v2.2.1
 
 )
 
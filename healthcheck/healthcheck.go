@@ -9,9 +9,16 @@ package healthcheck
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
 	pb "github.com/hyperledger/fabric/protos/peer"
+	configapi "github.com/securekey/fabric-snaps/configmanager/api"
+	"github.com/securekey/fabric-snaps/util/errors"
 )
 
 var logger = shim.NewLogger("healthcheck")
@@ -21,14 +28,100 @@ const (
 	FMPScc = "FMPScc"
 	// ConfigurationScc Healthcheck
 	ConfigurationScc = "ConfigurationScc"
-	// TxDelegationScc Healthcheck
+	// TxDelegationScc Healthcheck. TxDelegationScc is not part of fabric-snaps so it must register its ledger and tx
+	// client checks itself with RegisterCheck (see NewLedgerCheck and NewTxClientCheck). Until it does, they fail.
 	TxDelegationScc = "TxDelegationScc"
 )
 
+const (
+	// CacheInitializedCheck checks that the config cache is initialized on the channel
+	CacheInitializedCheck = "cacheInitialized"
+	// EventListenerCheck checks that the snap is registered for events on the channel
+	EventListenerCheck = "eventListenerRegistered"
+	// LedgerReachableCheck checks that the ledger of the channel can be read
+	LedgerReachableCheck = "ledgerReachable"
+	// TxClientCheck checks that a transaction client can be created for the channel
+	TxClientCheck = "txClientCreatable"
+	// BCCSPCheck checks that the default BCCSP provider is usable
+	BCCSPCheck = "bccspUsable"
+)
+
 // SmokeTestResult is a structure representing the results of a SmokeTest
 type SmokeTestResult struct {
+	Message string        `json:"message,omitempty"`
+	Status  int           `json:"status,omitempty"`
+	Checks  []CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the result of a single check of a SmokeTest
+type CheckResult struct {
+	Name    string `json:"name"`
+	Status  int    `json:"status"`
 	Message string `json:"message,omitempty"`
-	Status  int    `json:"status,omitempty"`
+}
+
+// Check performs a single health check. The stub's channel is checked if the check is channel specific.
+type Check func(stub shim.ChaincodeStubInterface) error
+
+type namedCheck struct {
+	name string
+	// channelScoped checks are only performed when the stub has a channel
+	channelScoped bool
+	check         Check
+}
+
+// CacheChecker is the part of the config service used by the cache check
+type CacheChecker interface {
+	GetCacheStatus(channelID string) []*configapi.CacheStatus
+}
+
+// LedgerChecker reads the blockchain info of the ledger of a channel for the ledger check
+type LedgerChecker interface {
+	GetBlockchainInfo(channelID string) (*common.BlockchainInfo, error)
+}
+
+// LedgerCheckerFunc adapts a function to a LedgerChecker
+type LedgerCheckerFunc func(channelID string) (*common.BlockchainInfo, error)
+
+// GetBlockchainInfo returns f(channelID)
+func (f LedgerCheckerFunc) GetBlockchainInfo(channelID string) (*common.BlockchainInfo, error) {
+	return f(channelID)
+}
+
+// TxClientChecker creates the transaction client of a channel for the tx client check
+type TxClientChecker interface {
+	CheckTxClient(channelID string) error
+}
+
+// TxClientCheckerFunc adapts a function to a TxClientChecker
+type TxClientCheckerFunc func(channelID string) error
+
+// CheckTxClient returns f(channelID)
+func (f TxClientCheckerFunc) CheckTxClient(channelID string) error {
+	return f(channelID)
+}
+
+var getBCCSP = factory.GetDefault
+
+var checksMtx sync.RWMutex
+var registeredChecks = make(map[string][]namedCheck)
+
+// RegisterCheck registers a channel specific check that is performed by the SmokeTest of the given snap in addition
+// to its built-in checks. A check that was previously registered for the snap with the same name, or the built-in
+// check with the same name, is replaced. The snaps register the checks of the services they depend on, e.g. the
+// checks returned by NewCacheCheck, NewLedgerCheck and NewTxClientCheck.
+func RegisterCheck(extScc string, name string, check Check) {
+	checksMtx.Lock()
+	defer checksMtx.Unlock()
+
+	checks := registeredChecks[extScc]
+	for i, c := range checks {
+		if c.name == name {
+			checks[i].check = check
+			return
+		}
+	}
+	registeredChecks[extScc] = append(checks, namedCheck{name: name, channelScoped: true, check: check})
 }
 
 // SmokeTest is a health check function that returns the status of Snap it is called up
@@ -50,8 +143,8 @@ func SmokeTest(extScc string, stub shim.ChaincodeStubInterface, args [][]byte) p
 	default:
 		logger.Info("Smoke test of unrecognized ExtSCC '%s' ...")
 		defaultResult := &SmokeTestResult{
-			fmt.Sprintf("%s Healthcheck had nothing to run. Returning empty success response..", extScc),
-			shim.OK,
+			Message: fmt.Sprintf("%s Healthcheck had nothing to run. Returning empty success response..", extScc),
+			Status:  shim.OK,
 		}
 		payload, err := json.Marshal(defaultResult)
 		if err != nil {
@@ -74,8 +167,7 @@ func healthcheckFmpScc(stub shim.ChaincodeStubInterface, args [][]byte) pb.Respo
 	if stub == nil || args == nil {
 		logger.Info("healthcheckFmpScc: The arguments stub or args may be nil")
 	}
-	//todo add FMPScc healthcheck logic here
-	return shim.Success(nil)
+	return runChecks(FMPScc, stub, namedCheck{name: BCCSPCheck, check: checkBCCSP})
 }
 
 // Healthcheck ConfigurationScc
@@ -83,15 +175,120 @@ func healthcheckConfigurationScc(stub shim.ChaincodeStubInterface, args [][]byte
 	if stub == nil || args == nil {
 		logger.Info("healthcheckConfigurationScc: The arguments stub or args may be nil")
 	}
-	//todo add ConfigurationScc healthcheck logic here
-	return shim.Success(nil)
+	return runChecks(ConfigurationScc, stub, namedCheck{name: BCCSPCheck, check: checkBCCSP})
 }
 
-// Healthcheck TxDelegationScc
+// Healthcheck TxDelegationScc. The ledger and tx client checks fail unless TxDelegationScc registered them.
 func healthcheckTxDelegationScc(stub shim.ChaincodeStubInterface, args [][]byte) pb.Response {
 	if stub == nil || args == nil {
 		logger.Info("healthcheckTxDelegationScc: The arguments stub or args may be nil")
 	}
-	//todo add TxDelegationScc healthcheck logic here
-	return shim.Success(nil)
+	return runChecks(TxDelegationScc, stub,
+		namedCheck{name: LedgerReachableCheck, channelScoped: true, check: notRegisteredCheck(TxDelegationScc, LedgerReachableCheck)},
+		namedCheck{name: TxClientCheck, channelScoped: true, check: notRegisteredCheck(TxDelegationScc, TxClientCheck)},
+		namedCheck{name: BCCSPCheck, check: checkBCCSP},
+	)
+}
+
+// runChecks performs the given checks and the checks registered for the snap and returns a response whose payload is
+// the SmokeTestResult. The status of the response is shim.ERROR if any of the checks failed.
+// Channel specific checks are skipped if the stub has no channel.
+func runChecks(extScc string, stub shim.ChaincodeStubInterface, checks ...namedCheck) pb.Response {
+	checksMtx.RLock()
+	for _, registered := range registeredChecks[extScc] {
+		replaced := false
+		for i, c := range checks {
+			if c.name == registered.name {
+				checks[i] = registered
+				replaced = true
+			}
+		}
+		if !replaced {
+			checks = append(checks, registered)
+		}
+	}
+	checksMtx.RUnlock()
+
+	hasChannel := stub != nil && stub.GetChannelID() != ""
+
+	result := &SmokeTestResult{Status: shim.OK}
+	var failed []string
+	for _, c := range checks {
+		if c.channelScoped && !hasChannel {
+			continue
+		}
+		checkResult := CheckResult{Name: c.name, Status: shim.OK}
+		if err := c.check(stub); err != nil {
+			logger.Warningf("%s healthcheck [%s] failed: %s", extScc, c.name, err)
+			checkResult.Status = shim.ERROR
+			checkResult.Message = err.Error()
+			failed = append(failed, c.name)
+		}
+		result.Checks = append(result.Checks, checkResult)
+	}
+
+	if len(failed) > 0 {
+		result.Status = shim.ERROR
+		result.Message = fmt.Sprintf("%s healthcheck failed checks: %s", extScc, strings.Join(failed, ", "))
+	} else {
+		result.Message = fmt.Sprintf("%s healthcheck passed %d checks", extScc, len(result.Checks))
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Error occurred while Marshalling: %s", err))
+	}
+	if result.Status != shim.OK {
+		return pb.Response{Status: shim.ERROR, Message: result.Message, Payload: payload}
+	}
+	return shim.Success(payload)
+}
+
+// NewCacheCheck returns the check that the config cache of at least one MSP is initialized on the stub's channel
+func NewCacheCheck(checker CacheChecker) Check {
+	return func(stub shim.ChaincodeStubInterface) error {
+		if len(checker.GetCacheStatus(stub.GetChannelID())) == 0 {
+			return errors.Errorf(errors.SystemError, "config cache is not initialized on channel [%s]", stub.GetChannelID())
+		}
+		return nil
+	}
+}
+
+// NewLedgerCheck returns the check that the blockchain info can be read from the ledger of the stub's channel
+func NewLedgerCheck(checker LedgerChecker) Check {
+	return func(stub shim.ChaincodeStubInterface) error {
+		if _, err := checker.GetBlockchainInfo(stub.GetChannelID()); err != nil {
+			return errors.Wrapf(errors.SystemError, err, "cannot read blockchain info of channel [%s]", stub.GetChannelID())
+		}
+		return nil
+	}
+}
+
+// NewTxClientCheck returns the check that a transaction client can be created for the stub's channel
+func NewTxClientCheck(checker TxClientChecker) Check {
+	return func(stub shim.ChaincodeStubInterface) error {
+		if err := checker.CheckTxClient(stub.GetChannelID()); err != nil {
+			return errors.WithMessage(errors.SystemError, err, fmt.Sprintf("cannot create tx client for channel [%s]", stub.GetChannelID()))
+		}
+		return nil
+	}
+}
+
+// notRegisteredCheck returns a check that fails because the snap didn't register the check with the given name
+func notRegisteredCheck(extScc string, name string) Check {
+	return func(stub shim.ChaincodeStubInterface) error {
+		return errors.Errorf(errors.SystemError, "%s did not register the %s check", extScc, name)
+	}
+}
+
+// checkBCCSP checks that the default BCCSP provider can compute a hash
+func checkBCCSP(stub shim.ChaincodeStubInterface) error {
+	csp := getBCCSP()
+	if csp == nil {
+		return errors.New(errors.SystemError, "default BCCSP provider is not available")
+	}
+	if _, err := csp.Hash([]byte(BCCSPCheck), &bccsp.SHA256Opts{}); err != nil {
+		return errors.Wrap(errors.SystemError, err, "default BCCSP provider failed to compute a hash")
+	}
+	return nil
 }
@@ -7,10 +7,14 @@ SPDX-License-Identifier: Apache-2.0
 package healthcheck
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/common"
+	configapi "github.com/securekey/fabric-snaps/configmanager/api"
 )
 
 func TestMain(m *testing.M) {
@@ -33,3 +37,181 @@ func TestDefaultSmokeTestReturnEmptyResult(t *testing.T) {
 		t.Fatalf("Default smokeTest returned abnormal message '%s'. Payload is: '%s'", resp.GetMessage(), resp.GetPayload())
 	}
 }
+
+func TestSmokeTestChecks(t *testing.T) {
+	checks := mockChecks(nil, nil, 1)
+	defer registerChecks(TxDelegationScc, checks, LedgerReachableCheck, TxClientCheck)()
+	defer registerChecks(ConfigurationScc, checks, CacheInitializedCheck, LedgerReachableCheck, TxClientCheck)()
+
+	stub := shim.NewMockStub("", nil)
+	stub.ChannelID = "testChannel"
+
+	resp := SmokeTest(TxDelegationScc, stub, [][]byte{})
+	if resp.Status != shim.OK {
+		t.Fatalf("TxDelegationScc smoke test failed: %s", resp.GetMessage())
+	}
+	result, err := UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{LedgerReachableCheck: shim.OK, TxClientCheck: shim.OK, BCCSPCheck: shim.OK})
+
+	resp = SmokeTest(ConfigurationScc, stub, [][]byte{})
+	if resp.Status != shim.OK {
+		t.Fatalf("ConfigurationScc smoke test failed: %s", resp.GetMessage())
+	}
+	result, err = UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{CacheInitializedCheck: shim.OK, LedgerReachableCheck: shim.OK, TxClientCheck: shim.OK, BCCSPCheck: shim.OK})
+
+	//channel specific checks are skipped without a channel
+	stub.ChannelID = ""
+	resp = SmokeTest(ConfigurationScc, stub, [][]byte{})
+	if resp.Status != shim.OK {
+		t.Fatalf("ConfigurationScc smoke test failed: %s", resp.GetMessage())
+	}
+	result, err = UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{BCCSPCheck: shim.OK})
+}
+
+func TestSmokeTestFailedChecks(t *testing.T) {
+	checks := mockChecks(fmt.Errorf("ledger unavailable"), fmt.Errorf("no client"), 0)
+	defer registerChecks(ConfigurationScc, checks, CacheInitializedCheck, LedgerReachableCheck, TxClientCheck)()
+
+	stub := shim.NewMockStub("", nil)
+	stub.ChannelID = "testChannel"
+
+	resp := SmokeTest(ConfigurationScc, stub, [][]byte{})
+	if resp.Status != shim.ERROR {
+		t.Fatal("ConfigurationScc smoke test should have failed")
+	}
+	if !strings.Contains(resp.Message, CacheInitializedCheck) || !strings.Contains(resp.Message, LedgerReachableCheck) || !strings.Contains(resp.Message, TxClientCheck) {
+		t.Fatalf("Expecting the failed checks in the message: %s", resp.Message)
+	}
+	result, err := UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{CacheInitializedCheck: shim.ERROR, LedgerReachableCheck: shim.ERROR, TxClientCheck: shim.ERROR, BCCSPCheck: shim.OK})
+}
+
+func TestSmokeTestUnregisteredChecks(t *testing.T) {
+	stub := shim.NewMockStub("", nil)
+	stub.ChannelID = "testChannel"
+
+	//TxDelegationScc must register its ledger and tx client checks
+	resp := SmokeTest(TxDelegationScc, stub, [][]byte{})
+	if resp.Status != shim.ERROR {
+		t.Fatal("TxDelegationScc smoke test should have failed")
+	}
+	if !strings.Contains(resp.Message, LedgerReachableCheck) || !strings.Contains(resp.Message, TxClientCheck) {
+		t.Fatalf("Expecting the unregistered checks in the message: %s", resp.Message)
+	}
+	result, err := UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{LedgerReachableCheck: shim.ERROR, TxClientCheck: shim.ERROR, BCCSPCheck: shim.OK})
+
+	stub.ChannelID = ""
+	resp = SmokeTest(TxDelegationScc, stub, [][]byte{})
+	if resp.Status != shim.OK {
+		t.Fatalf("TxDelegationScc smoke test failed: %s", resp.GetMessage())
+	}
+}
+
+func TestRegisterCheck(t *testing.T) {
+	checks := mockChecks(fmt.Errorf("ledger unavailable"), nil, 1)
+	defer registerChecks(FMPScc, checks, LedgerReachableCheck)()
+
+	stub := shim.NewMockStub("", nil)
+	stub.ChannelID = "testChannel"
+
+	listenerErr := fmt.Errorf("not registered")
+	RegisterCheck(FMPScc, EventListenerCheck, func(stub shim.ChaincodeStubInterface) error { return listenerErr })
+	resp := SmokeTest(FMPScc, stub, [][]byte{})
+	result, err := UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{LedgerReachableCheck: shim.ERROR, BCCSPCheck: shim.OK, EventListenerCheck: shim.ERROR})
+
+	//a registered check replaces the previously registered check and the built-in check with the same name
+	RegisterCheck(FMPScc, EventListenerCheck, func(stub shim.ChaincodeStubInterface) error { return nil })
+	RegisterCheck(FMPScc, LedgerReachableCheck, func(stub shim.ChaincodeStubInterface) error { return nil })
+	RegisterCheck(FMPScc, BCCSPCheck, func(stub shim.ChaincodeStubInterface) error { return nil })
+	resp = SmokeTest(FMPScc, stub, [][]byte{})
+	if resp.Status != shim.OK {
+		t.Fatalf("FMPScc smoke test failed: %s", resp.GetMessage())
+	}
+	result, err = UnmarshalEchoResponse(resp.Payload)
+	if err != nil {
+		t.Fatalf("Error unmarshalling smoke test result: %s", err)
+	}
+	assertChecks(t, result, map[string]int{LedgerReachableCheck: shim.OK, BCCSPCheck: shim.OK, EventListenerCheck: shim.OK})
+}
+
+func assertChecks(t *testing.T, result *SmokeTestResult, expected map[string]int) {
+	if len(result.Checks) != len(expected) {
+		t.Fatalf("Expecting %d checks but got %+v", len(expected), result.Checks)
+	}
+	for _, check := range result.Checks {
+		status, ok := expected[check.Name]
+		if !ok {
+			t.Fatalf("Unexpected check %s", check.Name)
+		}
+		if check.Status != status {
+			t.Fatalf("Expecting status %d for check %s but got %d: %s", status, check.Name, check.Status, check.Message)
+		}
+	}
+}
+
+// mockChecks returns the cache, ledger and tx client checks with mock services
+func mockChecks(ledgerErr error, txClientErr error, cachedMsps int) map[string]Check {
+	return map[string]Check{
+		CacheInitializedCheck: NewCacheCheck(&mockConfigService{cachedMsps: cachedMsps}),
+		LedgerReachableCheck:  NewLedgerCheck(&mockLedger{err: ledgerErr}),
+		TxClientCheck:         NewTxClientCheck(TxClientCheckerFunc(func(channelID string) error { return txClientErr })),
+	}
+}
+
+// registerChecks registers the given checks of the snap and returns a function that removes the registered checks
+// of the snap
+func registerChecks(extScc string, checks map[string]Check, names ...string) func() {
+	for _, name := range names {
+		RegisterCheck(extScc, name, checks[name])
+	}
+	return func() {
+		checksMtx.Lock()
+		delete(registeredChecks, extScc)
+		checksMtx.Unlock()
+	}
+}
+
+type mockLedger struct {
+	err error
+}
+
+func (l *mockLedger) GetBlockchainInfo(channelID string) (*common.BlockchainInfo, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &common.BlockchainInfo{Height: 1}, nil
+}
+
+type mockConfigService struct {
+	cachedMsps int
+}
+
+func (s *mockConfigService) GetCacheStatus(channelID string) []*configapi.CacheStatus {
+	var statuses []*configapi.CacheStatus
+	for i := 0; i < s.cachedMsps; i++ {
+		statuses = append(statuses, &configapi.CacheStatus{ChannelID: channelID, MspID: fmt.Sprintf("Org%dMSP", i+1)})
+	}
+	return statuses
+}
@@ -7,14 +7,20 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io/ioutil"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	logging "github.com/hyperledger/fabric-sdk-go/pkg/common/logging"
 	configmanagerApi "github.com/securekey/fabric-snaps/configmanager/api"
 	configmgmtService "github.com/securekey/fabric-snaps/configmanager/pkg/service"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
 	httpsnapApi "github.com/securekey/fabric-snaps/httpsnap/api"
 	"github.com/securekey/fabric-snaps/util/configcache"
 	"github.com/securekey/fabric-snaps/util/errors"
@@ -27,12 +33,19 @@ const (
 	cmdRootPrefix       = "core"
 	defaultTimeout      = time.Second * 5
 	defaultCacheRefresh = time.Minute * 180
+	//configConsumerID identifies httpsnap to the config service so that its dirty flags are tracked separately
+	configConsumerID = "httpsnap"
 )
 
 var logger = logging.NewLogger("httpsnap")
 var defaultLogLevel = "info"
 var peerConfigCache = configcache.New(peerConfigFileName, cmdRootPrefix, "/etc/hyperledger/fabric")
 
+//ledgerHashesMtx guards ledgerHashes, which contains the hash of the configs that were last read from the ledger of
+//each channel (when the configs of the peer's MSP aren't cached)
+var ledgerHashesMtx sync.Mutex
+var ledgerHashes = make(map[string]string)
+
 // FilePathSeparator separator defined by os.Separator.
 const FilePathSeparator = string(filepath.Separator)
 
@@ -53,16 +66,21 @@ func NewConfig(peerConfigPath string, channelID string) (httpsnapApi.Config, boo
 	if err != nil {
 		return nil, false, err
 	}
-	//httpSnapConfig Config
-	key := configmanagerApi.ConfigKey{MspID: peerConfig.GetString("peer.localMspId"), PeerID: peerConfig.GetString("peer.id"), AppName: "httpsnap"}
+	//httpSnapConfig Config and the client identities that were imported into the configuration snap
+	mspID, peerID := peerConfig.GetString("peer.localMspId"), peerConfig.GetString("peer.id")
+	keys := []configmanagerApi.ConfigKey{
+		{MspID: mspID, PeerID: peerID, AppName: "httpsnap", AppVersion: configmanagerApi.VERSION},
+		{MspID: mspID, PeerID: peerID, AppName: cfgsnapapi.ClientIdentitiesAppName, AppVersion: cfgsnapapi.ClientIdentitiesAppVersion},
+	}
 	cacheInstance := configmgmtService.GetInstance()
 	if cacheInstance == nil {
 		return nil, false, errors.New(errors.SystemError, "Cannot create cache instance")
 	}
-	configData, dirty, err := cacheInstance.Get(channelID, key)
+	configs, dirty, err := getConfigs(cacheInstance, channelID, keys)
 	if err != nil {
 		return nil, false, errors.WithMessage(errors.SystemError, err, "Failed cacheInstance")
 	}
+	configData := configs[0]
 	if configData == nil {
 		return nil, false, errors.New(errors.InitializeConfigError, "config data is empty")
 	}
@@ -80,6 +98,7 @@ func NewConfig(peerConfigPath string, channelID string) (httpsnapApi.Config, boo
 	if err != nil {
 		return nil, false, err
 	}
+	c.loadClientIdentities(configs[1])
 	if dirty {
 		err = c.initializeLogging()
 		if err != nil {
@@ -285,6 +304,76 @@ func (c *config) GetCryptoProvider() (string, errors.Error) {
 	return cryptoProvider, nil
 }
 
+//getConfigs returns the configs for the given keys of the peer and true if any of them changed since they were last
+//read. The configs are read from the cache or, if the configs of the peer's MSP aren't cached on the channel, from
+//the ledger in a single read.
+func getConfigs(cacheInstance configmanagerApi.ConfigService, channelID string, keys []configmanagerApi.ConfigKey) ([][]byte, bool, errors.Error) {
+	if _, cached := cacheInstance.GetCacheInfo(channelID, keys[0].MspID); !cached {
+		configs, err := cacheInstance.GetConfigsFromLedger(channelID, keys)
+		if err != nil {
+			return nil, false, err
+		}
+		return configs, isLedgerDirty(channelID, configs), nil
+	}
+
+	configs := make([][]byte, len(keys))
+	anyDirty := false
+	for i, key := range keys {
+		config, dirty, err := cacheInstance.GetForConsumer(configConsumerID, channelID, key)
+		if err != nil {
+			return nil, false, err
+		}
+		configs[i] = config
+		anyDirty = anyDirty || dirty
+	}
+	return configs, anyDirty, nil
+}
+
+//isLedgerDirty returns true if the given configs differ from the configs that were last read from the ledger of
+//the channel
+func isLedgerDirty(channelID string, configs [][]byte) bool {
+	hash := sha256.New()
+	for _, config := range configs {
+		//the length prefix keeps the boundaries between the configs
+		hash.Write([]byte(strconv.Itoa(len(config)) + ":"))
+		hash.Write(config)
+	}
+	configsHash := hex.EncodeToString(hash.Sum(nil))
+
+	ledgerHashesMtx.Lock()
+	defer ledgerHashesMtx.Unlock()
+	dirty := ledgerHashes[channelID] != configsHash
+	ledgerHashes[channelID] = configsHash
+	return dirty
+}
+
+//loadClientIdentities adds the given client identities that were imported into the configuration snap to the named
+//client overrides. Named clients in the httpsnap config take precedence.
+func (c *config) loadClientIdentities(identitiesData []byte) {
+	if len(identitiesData) == 0 {
+		return
+	}
+
+	identities := make(map[string]*cfgsnapapi.ClientIdentity)
+	if err := json.Unmarshal(identitiesData, &identities); err != nil {
+		logger.Warnf("Failed to unmarshal client identities: %s", err)
+		return
+	}
+	if c.clientTLS == nil {
+		c.clientTLS = make(map[string]*httpsnapApi.ClientTLS, len(identities))
+	}
+	for name, identity := range identities {
+		if _, ok := c.clientTLS[name]; ok {
+			logger.Debugf("Named client [%s] is configured in httpsnap config, ignoring imported client identity", name)
+			continue
+		}
+		if identity.NotAfter.Before(time.Now()) {
+			logger.Warnf("Certificate of client identity [%s] expired on %s", name, identity.NotAfter)
+		}
+		c.clientTLS[name] = &httpsnapApi.ClientTLS{Crt: identity.Cert}
+	}
+}
+
 func (c *config) preloadEntities() errors.Error {
 
 	//client TLS configs
@@ -18,6 +18,7 @@ import (
 	configmanagerApi "github.com/securekey/fabric-snaps/configmanager/api"
 	"github.com/securekey/fabric-snaps/configmanager/pkg/mgmt"
 	configmgmtService "github.com/securekey/fabric-snaps/configmanager/pkg/service"
+	cfgsnapapi "github.com/securekey/fabric-snaps/configurationsnap/api"
 	httpsnapApi "github.com/securekey/fabric-snaps/httpsnap/api"
 	"github.com/securekey/fabric-snaps/metrics/pkg/util"
 	mockstub "github.com/securekey/fabric-snaps/mocks/mockstub"
@@ -31,6 +32,7 @@ var c httpsnapApi.Config
 var relConfigPath = "/fabric-snaps/httpsnap/cmd/config/"
 var channelID = "testChannel"
 var peerConfigChannelID = "testChannel-peerConfig"
+var identitiesChannelID = "testChannel-identities"
 var mspID = "Org1MSP"
 
 func TestGetClientCert(t *testing.T) {
@@ -295,3 +297,58 @@ func TestKeyCacheConfig(t *testing.T) {
 	assert.True(t, c.IsKeyCacheEnabled())
 	assert.Equal(t, 50*time.Minute, c.KeyCacheRefreshInterval())
 }
+
+func TestImportedClientIdentities(t *testing.T) {
+	configData, err := ioutil.ReadFile("./config.yaml")
+	if err != nil {
+		t.Fatalf("File error: %v", err)
+	}
+	identities := map[string]*cfgsnapapi.ClientIdentity{
+		"imported": {SKI: "0102", Cert: "importedCert", NotAfter: time.Now().Add(time.Hour)},
+		"abc":      {SKI: "0304", Cert: "otherCert", NotAfter: time.Now().Add(time.Hour)},
+	}
+	identitiesBytes, err := json.Marshal(identities)
+	if err != nil {
+		t.Fatalf("Cannot Marshal %s", err)
+	}
+	config := &configmanagerApi.ConfigMessage{MspID: mspID, Peers: []configmanagerApi.PeerConfig{{PeerID: "jdoe",
+		App: []configmanagerApi.AppConfig{
+			{AppName: "httpsnap", Version: configmanagerApi.VERSION, Config: string(configData)},
+			{AppName: cfgsnapapi.ClientIdentitiesAppName, Version: cfgsnapapi.ClientIdentitiesAppVersion, Config: string(identitiesBytes)},
+		}}}}
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Cannot Marshal %s", err)
+	}
+	stub := getMockStub(identitiesChannelID)
+	if err := uploadConfigToHL(stub, configBytes); err != nil {
+		t.Fatalf("Cannot upload %s", err)
+	}
+	configmgmtService.Initialize(stub, mspID)
+
+	testConfig, _, err := NewConfig("../sampleconfig", identitiesChannelID)
+	if err != nil {
+		t.Fatalf("Failed to create config: %s", err)
+	}
+	clientMap := testConfig.GetNamedClientOverride()
+	if _, exist := clientMap["imported"]; !exist {
+		t.Fatalf("imported client not exist")
+	}
+	verifyEqual(t, clientMap["imported"].Crt, "importedCert", "Failed to get imported client Crt.")
+	assert.Empty(t, clientMap["imported"].Ca)
+
+	//named clients in the httpsnap config take precedence
+	verifyEqual(t, clientMap["abc"].Ca, "abcCA", "Failed to get client override CA.")
+	verifyEqual(t, clientMap["abc"].Crt, "abcCert", "Failed to get client override Crt.")
+}
+
+func TestIsLedgerDirty(t *testing.T) {
+	configs := [][]byte{[]byte("httpsnap config"), nil}
+	assert.True(t, isLedgerDirty("ledgerChannel", configs))
+	assert.False(t, isLedgerDirty("ledgerChannel", configs))
+	assert.True(t, isLedgerDirty("otherLedgerChannel", configs))
+
+	//the boundaries between the configs are part of the hash
+	assert.True(t, isLedgerDirty("ledgerChannel", [][]byte{[]byte("httpsnap"), []byte(" config")}))
+	assert.True(t, isLedgerDirty("ledgerChannel", [][]byte{[]byte("httpsnap config"), []byte("identities")}))
+}
@@ -445,7 +445,15 @@ func (httpServiceImpl *HTTPServiceImpl) validateClientConfig(client string, conf
 		}
 
 		clientCert = clientOverrideCrt.Crt
-		caCerts = []string{clientOverrideCrt.Ca}
+		if clientOverrideCrt.Ca != "" {
+			caCerts = []string{clientOverrideCrt.Ca}
+		} else {
+			//named clients without a CA (e.g. imported client identities) use the default CA certs
+			caCerts, err = config.GetCaCerts()
+			if err != nil {
+				return clientCert, caCerts, errors.WithMessage(errors.MissingConfigDataError, err, "failed to get ca certs from httpsnap config")
+			}
+		}
 
 	} else {
 
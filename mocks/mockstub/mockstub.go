@@ -13,9 +13,11 @@ package mocks
 
 import (
 	"container/list"
+	"unicode/utf8"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
 	"github.com/hyperledger/fabric/protos/msp"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"github.com/op/go-logging"
@@ -38,6 +40,8 @@ type MockStub struct {
 	cc shim.Chaincode
 	// registered list of other MockStub chaincodes that can be called from this MockStub
 	Invokables map[string]*MockStub
+	// history of the values written for each key
+	history map[string][]*queryresult.KeyModification
 }
 
 //SetMspID to set mspid
@@ -64,6 +68,7 @@ func NewMockStub(name string, cc shim.Chaincode) *MockStub {
 	s.cc = cc
 	s.State = make(map[string][]byte)
 	s.Invokables = make(map[string]*MockStub)
+	s.history = make(map[string][]*queryresult.KeyModification)
 	s.Keys = list.New()
 	s.ChaincodeEventsChannel = make(chan *pb.ChaincodeEvent, 100)
 
@@ -92,3 +97,106 @@ func (stub *MockStub) MockInvoke(uuid string, args [][]byte) pb.Response {
 func (stub *MockStub) GetArgs() [][]byte {
 	return stub.args
 }
+
+//PutState writes the value to the mock state and records it in the key's history
+func (stub *MockStub) PutState(key string, value []byte) error {
+	if err := stub.MockStub.PutState(key, value); err != nil {
+		return err
+	}
+	stub.addHistory(key, value, false)
+	return nil
+}
+
+//DelState deletes the key from the mock state and records the delete in the key's history
+func (stub *MockStub) DelState(key string) error {
+	if err := stub.MockStub.DelState(key); err != nil {
+		return err
+	}
+	stub.addHistory(key, nil, true)
+	return nil
+}
+
+//GetHistoryForKey returns the values that were written for the given key, oldest first
+func (stub *MockStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{values: stub.history[key]}, nil
+}
+
+func (stub *MockStub) addHistory(key string, value []byte, isDelete bool) {
+	if stub.history == nil {
+		stub.history = make(map[string][]*queryresult.KeyModification)
+	}
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId:      stub.TxID,
+		Value:     value,
+		Timestamp: stub.TxTimestamp,
+		IsDelete:  isDelete,
+	})
+}
+
+type historyIterator struct {
+	values []*queryresult.KeyModification
+	index  int
+}
+
+func (it *historyIterator) HasNext() bool {
+	return it.index < len(it.values)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	value := it.values[it.index]
+	it.index++
+	return value, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+//GetStateByPartialCompositeKeyWithPagination returns a page of the keys that match the partial composite key,
+//starting at the bookmark. The bookmark of the returned metadata is the key at which the next page starts.
+func (stub *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	partialKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	startKey := partialKey
+	if bookmark != "" {
+		startKey = bookmark
+	}
+	endKey := partialKey + string(utf8.MaxRune)
+
+	metadata := &pb.QueryResponseMetadata{}
+	var kvs []*queryresult.KV
+	for elem := stub.Keys.Front(); elem != nil; elem = elem.Next() {
+		key := elem.Value.(string)
+		if key < startKey || key >= endKey {
+			continue
+		}
+		if int32(len(kvs)) == pageSize {
+			metadata.Bookmark = key
+			break
+		}
+		kvs = append(kvs, &queryresult.KV{Key: key, Value: stub.State[key]})
+	}
+	metadata.FetchedRecordsCount = int32(len(kvs))
+	return &kvIterator{values: kvs}, metadata, nil
+}
+
+type kvIterator struct {
+	values []*queryresult.KV
+	index  int
+}
+
+func (it *kvIterator) HasNext() bool {
+	return it.index < len(it.values)
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	value := it.values[it.index]
+	it.index++
+	return value, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
@@ -34,6 +34,8 @@ const (
 	defaultSelectionMaxAttempts       = 1
 	defaultSelectionInterval          = time.Second
 	defaultClientCacheRefreshInterval = 60 * time.Second
+	//configConsumerID identifies txnsnap to the config service so that its dirty flags are tracked separately
+	configConsumerID = "txnsnap"
 )
 
 var logger = logging.NewLogger("txnsnap")
@@ -61,7 +63,7 @@ func NewConfig(peerConfigPath string, channelID string) (transactionsnapApi.Conf
 		return nil, errors.New(errors.SystemError, "Cannot create cache instance")
 	}
 	//txn snap has its own cache and config hash checks, no need of dirty flag from config cache
-	dataConfig, _, err := cacheInstance.Get(channelID, key)
+	dataConfig, _, err := cacheInstance.GetForConsumer(configConsumerID, channelID, key)
 	if err != nil {
 		return nil, errors.WithMessage(errors.InitializeConfigError, err, fmt.Sprintf("Failed to get config cache for channel %s and key %s", channelID, key))
 	}
@@ -92,6 +92,10 @@ const (
 	// GetConfigError ...
 	GetConfigError = "get-config-error"
 
+	// ConfigVersionConflict is returned when a save is rejected because the stored config has changed
+	// since the version expected by the caller
+	ConfigVersionConflict = "config-version-conflict"
+
 	// *** End Configuration Snap *** //
 
 	// *** Start HTTP Snap *** //
@@ -20,6 +20,8 @@ type Error interface {
 
 	// Status returns the error code
 	ErrorCode() ErrorCode
+	// ErrorID returns the ID that identifies the error in the log
+	ErrorID() string
 	// Generate log msg
 	GenerateLogMsg() string
 	// Generate client error msg